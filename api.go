@@ -0,0 +1,165 @@
+/// api.go ---
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"echonet-mqtt/echonet"
+)
+
+// result of a PUT/PATCH request
+type setResult struct {
+	Results map[string]string `json:"results"` // "ok" or error by key
+	State   map[string]any    `json:"state"`
+}
+
+// devicesHandler serves GET /devices.
+func devicesHandler(w http.ResponseWriter, r *http.Request,
+	enet *echonet.Echonet) {
+
+	list := []map[string]any{}
+	for _, obj := range enet.List() {
+		list = append(list, deviceState(obj))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// deviceHandler serves GET /devices/{type}/{name}.
+func deviceHandler(w http.ResponseWriter, r *http.Request,
+	enet *echonet.Echonet) {
+
+	obj := enet.FindObject(r.PathValue("type"), r.PathValue("name"))
+	if obj == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, deviceState(obj))
+}
+
+// setHandler serves PUT/PATCH /devices/{type}/{name}. The body is a
+// JSON object of command keys, e.g. {"mode": "cool", "temperature": 26},
+// which are applied in the order of commandKeys until one fails.
+func setHandler(w http.ResponseWriter, r *http.Request,
+	enet *echonet.Echonet) {
+
+	obj := enet.FindObject(r.PathValue("type"), r.PathValue("name"))
+	if obj == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	var body map[string]any
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	keys := commandKeys(obj)
+	values := map[string]string{}
+	for key, v := range body {
+		if !slices.Contains(keys, key) {
+			http.Error(w, fmt.Sprintf("%s: %s", errUnknownCommand, key),
+				http.StatusBadRequest)
+			return
+		}
+		switch v := v.(type) {
+		case string:
+			values[key] = v
+		case json.Number:
+			values[key] = v.String()
+		default:
+			http.Error(w, fmt.Sprintf("invalid %s: %v", key, v),
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := setResult{Results: map[string]string{}}
+	status := http.StatusOK
+	for _, key := range keys {
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		metrics.Command(key)
+		err := applyCommand(obj, key, value)
+		if err != nil {
+			log_http.Warn("command failed",
+				"device", obj.GetType()+"/"+obj.GetName(),
+				"command", key, "value", value, "err", err)
+			res.Results[key] = err.Error()
+			status = commandStatus(err)
+			break
+		}
+		res.Results[key] = "ok"
+	}
+
+	// With SetGet the commands have read the state back.
+	if !obj.GetConfig().SetGet || status != http.StatusOK {
+		if err := obj.Refresh(); err != nil {
+			log_http.Warn("refresh failed",
+				"device", obj.GetType()+"/"+obj.GetName(), "err", err)
+		}
+	}
+	res.State = deviceState(obj)
+	writeJSON(w, status, res)
+}
+
+// propertiesHandler serves GET /devices/{type}/{name}/properties,
+// the property maps read from the device.
+func propertiesHandler(w http.ResponseWriter, r *http.Request,
+	enet *echonet.Echonet) {
+
+	obj := enet.FindObject(r.PathValue("type"), r.PathValue("name"))
+	if obj == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+	if err := obj.Property(); err != nil {
+		http.Error(w, err.Error(), commandStatus(err))
+		return
+	}
+
+	epcs := func(m []byte) []string {
+		ss := []string{}
+		for _, epc := range m {
+			ss = append(ss, fmt.Sprintf("0x%02x", epc))
+		}
+		return ss
+	}
+	inf, set, get := obj.PropertyMap()
+	writeJSON(w, http.StatusOK, map[string][]string{
+		"inf": epcs(inf),
+		"set": epcs(set),
+		"get": epcs(get),
+	})
+}
+
+// commandStatus maps the error of applyCommand to the HTTP status.
+func commandStatus(err error) int {
+	var verr *echonet.ValueError
+	var serr *echonet.SNAError
+	switch {
+	case errors.As(err, &verr), errors.Is(err, errUnknownCommand):
+		return http.StatusBadRequest
+	case errors.As(err, &serr):
+		return http.StatusConflict
+	case errors.Is(err, echonet.ErrTimeout):
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
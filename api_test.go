@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"echonet-mqtt/echonet"
+)
+
+// request sends the request to the server and decodes the JSON
+// response, or returns the body as a string.
+func request(t *testing.T, srv *httptest.Server, method, path,
+	body string) (int, any) {
+
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v any
+	if json.Unmarshal(b, &v) != nil {
+		return res.StatusCode, strings.TrimSpace(string(b))
+	}
+	return res.StatusCode, v
+}
+
+func TestApiDevices(t *testing.T) {
+	mqtt, enet, _ := startBridge(t)
+	mqtt.wait(t, "light/hall/brightness", "80")
+	srv := httptest.NewServer(httpHandler(enet, mqtt))
+	defer srv.Close()
+
+	status, v := request(t, srv, "GET", "/devices", "")
+	list, _ := v.([]any)
+	if status != 200 || len(list) != 2 {
+		t.Fatalf("GET /devices: %d %v", status, v)
+	}
+	for _, d := range list {
+		d := d.(map[string]any)
+		switch d["name"] {
+		case "living":
+			if d["type"] != "aircon" || d["eoj"] != "013001" ||
+				d["mode"] != "off" || d["temperature"] != 26.0 {
+				t.Errorf("living %v", d)
+			}
+		case "hall":
+			if d["type"] != "light" || d["power"] != "off" ||
+				d["brightness"] != 80.0 {
+				t.Errorf("hall %v", d)
+			}
+		default:
+			t.Errorf("unexpected device %v", d)
+		}
+	}
+
+	status, v = request(t, srv, "GET", "/devices/light/hall", "")
+	if d, _ := v.(map[string]any); status != 200 || d["name"] != "hall" {
+		t.Errorf("GET /devices/light/hall: %d %v", status, v)
+	}
+	status, v = request(t, srv, "GET", "/devices/light/nowhere", "")
+	if status != 404 {
+		t.Errorf("GET /devices/light/nowhere: %d %v", status, v)
+	}
+}
+
+func TestApiSet(t *testing.T) {
+	mqtt, enet, sim := startBridge(t)
+	mqtt.wait(t, "aircon/living/mode", "off")
+	srv := httptest.NewServer(httpHandler(enet, mqtt))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		faults echonet.Faults
+		status int
+		result map[string]string // prefix by key
+	}{
+		{"not found", "/devices/aircon/nowhere", `{"mode": "cool"}`,
+			echonet.Faults{}, 404, nil},
+		{"invalid JSON", "/devices/aircon/living", `{"mode": `,
+			echonet.Faults{}, 400, nil},
+		{"unknown key", "/devices/aircon/living", `{"colour": "red"}`,
+			echonet.Faults{}, 400, nil},
+		{"invalid type", "/devices/aircon/living", `{"mode": true}`,
+			echonet.Faults{}, 400, nil},
+		{"invalid value", "/devices/aircon/living", `{"temperature": "hot"}`,
+			echonet.Faults{}, 400, map[string]string{"temperature": "invalid"}},
+		{"set", "/devices/aircon/living", `{"mode": "heat", "temperature": 22}`,
+			echonet.Faults{}, 200,
+			map[string]string{"mode": "ok", "temperature": "ok"}},
+		{"denied", "/devices/aircon/living", `{"temperature": 25}`,
+			echonet.Faults{SNA: []byte{echonet.EPC_TARGET_TEMP}}, 409,
+			map[string]string{"temperature": "SetC_SNA"}},
+		{"timeout", "/devices/aircon/living", `{"mode": "cool"}`,
+			echonet.Faults{Drop: 1}, 504,
+			map[string]string{"mode": "no response"}},
+	}
+	for _, tt := range tests {
+		sim.SetFaults(tt.faults)
+		status, v := request(t, srv, "PATCH", tt.path, tt.body)
+		if status != tt.status {
+			t.Errorf("%s: status %d expect %d: %v", tt.name, status,
+				tt.status, v)
+			continue
+		}
+		if tt.result == nil {
+			if _, ok := v.(string); !ok {
+				t.Errorf("%s: body %v expect an error message", tt.name, v)
+			}
+			continue
+		}
+		res, _ := v.(map[string]any)
+		results, _ := res["results"].(map[string]any)
+		for key, expect := range tt.result {
+			if s, _ := results[key].(string); !strings.HasPrefix(s, expect) {
+				t.Errorf("%s: %s %q expect %q", tt.name, key, s, expect)
+			}
+		}
+		if len(results) != len(tt.result) {
+			t.Errorf("%s: results %v", tt.name, results)
+		}
+	}
+	sim.SetFaults(echonet.Faults{})
+
+	// the state of the Set_Res is read back
+	status, v := request(t, srv, "GET", "/devices/aircon/living", "")
+	if d, _ := v.(map[string]any); status != 200 || d["mode"] != "heat" ||
+		d["temperature"] != 22.0 {
+		t.Errorf("GET /devices/aircon/living: %d %v", status, v)
+	}
+	if edt := sim.Get(0x013001, echonet.EPC_MODE); edt[0] != 0x43 {
+		t.Errorf("mode %x expect 43", edt)
+	}
+}
@@ -0,0 +1,204 @@
+/// cli.go ---
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+// parseEpc parses the EPC in hex or the name of the property, as
+// printed by get, of the EOJ.
+func parseEpc(eoj uint32, s string) (byte, error) {
+	hex := strings.TrimPrefix(strings.ToLower(s), "0x")
+	if len(hex) == 2 {
+		if v, err := strconv.ParseUint(hex, 16, 8); err == nil {
+			return byte(v), nil
+		}
+	}
+	for epc := 0x80; epc <= 0xff; epc++ {
+		if strings.EqualFold(s, echonet.PropertyName(eoj, byte(epc))) {
+			return byte(epc), nil
+		}
+	}
+	if p := echonet.DefaultMRA().PropertyByName(eoj, s); p != nil {
+		return p.EPC, nil
+	}
+	return 0, fmt.Errorf("unknown property %q", s)
+}
+
+// parseSetting parses <epc>=<value>: the value in hex with 0x, or as
+// encoded by the MRA or the properties known without it, e.g. b0=cool,
+// or in hex.
+func parseSetting(eoj uint32, s string) (echonet.EchonetProperty, error) {
+	var prop echonet.EchonetProperty
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || value == "" {
+		return prop, fmt.Errorf("invalid setting %q (e.g. b0=42)", s)
+	}
+	epc, err := parseEpc(eoj, key)
+	if err != nil {
+		return prop, err
+	}
+	prop.EPC = epc
+
+	p := echonet.DefaultMRA().Property(eoj, epc)
+	switch {
+	case strings.HasPrefix(value, "0x"):
+		p = &echonet.MRAProperty{EPC: epc} // hex
+	case p == nil:
+		edt, known, kerr := echonet.EncodeProperty(eoj, epc, value)
+		if known && kerr == nil {
+			prop.EDT = edt
+			return prop, nil
+		}
+		p = &echonet.MRAProperty{EPC: epc} // hex, e.g. b0=42
+		if _, err := p.Encode(value); err != nil && known {
+			return prop, fmt.Errorf("%s: %s", key, kerr)
+		}
+	}
+	prop.EDT, err = p.Encode(value)
+	if err != nil {
+		return prop, fmt.Errorf("%s: %s", key, err)
+	}
+	return prop, nil
+}
+
+// cliGet reads the properties of the object and prints them.
+func cliGet(obj *echonet.EchonetObject, args []string, w io.Writer) error {
+	var epcs []byte
+	for _, s := range args {
+		epc, err := parseEpc(obj.GetEoj(), s)
+		if err != nil {
+			return err
+		}
+		epcs = append(epcs, epc)
+	}
+	props, err := obj.Get(epcs...)
+	for _, prop := range props {
+		if len(prop.EDT) == 0 {
+			continue // denied, in err
+		}
+		fmt.Fprintf(w, "%02x %-8x %s\n", prop.EPC, prop.EDT,
+			echonet.DecodeProperty(obj.GetEoj(), prop))
+	}
+	return err
+}
+
+// cliSet sets the properties of the object in one request.
+func cliSet(obj *echonet.EchonetObject, args []string, w io.Writer) error {
+	var props []echonet.EchonetProperty
+	for _, s := range args {
+		prop, err := parseSetting(obj.GetEoj(), s)
+		if err != nil {
+			return err
+		}
+		props = append(props, prop)
+	}
+	if err := obj.Set(props...); err != nil {
+		return err
+	}
+	for _, prop := range props {
+		fmt.Fprintf(w, "%02x %-8x %s ok\n", prop.EPC, prop.EDT,
+			echonet.DecodeProperty(obj.GetEoj(), prop))
+	}
+	return nil
+}
+
+// cli runs the get or set subcommand on one device.
+func cli(name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	usage := "usage: echonet2mqtt get [flags] <IP> <EOJ> <EPC ...>"
+	run := cliGet
+	if name == "set" {
+		usage = "usage: echonet2mqtt set [flags] <IP> <EOJ> <EPC>=<VALUE ...>"
+		run = cliSet
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), usage)
+		fmt.Fprintln(fs.Output(),
+			"EPCs in hex or by name, values as decoded or in hex, e.g. b0=cool or b0=42")
+		fs.PrintDefaults()
+	}
+	bind := fs.String("bind", "", "address to listen on, default all")
+	port := fs.Int("port", echonet.ECHONET_PORT,
+		"port to listen on for the response")
+	ifaces := fs.String("interfaces", "",
+		"interface names or CIDRs to join the multicast group on, comma separated")
+	timeout := fs.Duration("timeout", echonet.ECHONET_TIMEOUT, "response timeout")
+	retry := fs.Int("retry", echonet.ECHONET_RETRY, "retries on timeout")
+	mra := fs.String("mra", "", "MRA directory for other classes")
+	verbose := fs.Bool("v", false, "log packets")
+	fs.Parse(args)
+	if fs.NArg() < 3 {
+		fs.Usage()
+		return 2
+	}
+
+	level := "warn"
+	if *verbose {
+		level = "debug"
+	}
+	setupLogging(os.Stderr, LogConfig{Level: level})
+	if *mra != "" {
+		m, err := echonet.LoadMRA(*mra)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		echonet.UseMRA(m)
+	}
+
+	enet, err := echonet.NewEchonetOptions(echonet.Options{
+		Port:       *port,
+		Bind:       *bind,
+		Interfaces: splitList(*ifaces),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer enet.Close()
+	enet.Timeout = *timeout
+	enet.Retry = *retry
+	enet.Interval = 0
+
+	obj, err := enet.NewObject(echonet.Config{Type: "generic", Name: name,
+		Addr: fs.Arg(0), Eoj: fs.Arg(1)})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	go func() {
+		for range enet.RecvChan {
+		}
+	}()
+	if err := enet.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = run(obj, fs.Args()[2:], os.Stdout)
+	var sna *echonet.SNAError
+	switch {
+	case errors.As(err, &sna):
+		fmt.Fprintf(os.Stderr, "denied: %s\n", err)
+		return 1
+	case errors.Is(err, echonet.ErrTimeout):
+		fmt.Fprintf(os.Stderr, "no response within %s\n",
+			*timeout*time.Duration(1+*retry))
+		return 1
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
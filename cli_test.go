@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"echonet-mqtt/echonet"
+)
+
+func TestParseSetting(t *testing.T) {
+	tests := []struct {
+		s   string
+		epc byte
+		edt string
+		err bool
+	}{
+		{"b3=1a", 0xb3, "\x1a", false},
+		{"0xb3=0x1a", 0xb3, "\x1a", false},
+		{"targettemp=1a", 0xb3, "\x1a", false},
+		{"b3=26", 0xb3, "\x1a", false},
+		{"targettemp=26°C", 0xb3, "\x1a", false},
+		{"b0=cool", 0xb0, "\x42", false},
+		{"b0=42", 0xb0, "\x42", false},
+		{"b0=warm", 0, "", true},
+		{"b0", 0, "", true},
+		{"b0=", 0, "", true},
+		{"nosuch=41", 0, "", true},
+		{"b0=4", 0, "", true},
+	}
+	for _, tt := range tests {
+		prop, err := parseSetting(0x013001, tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.s, err)
+			continue
+		}
+		if !tt.err && (prop.EPC != tt.epc || string(prop.EDT) != tt.edt) {
+			t.Errorf("%s: %02x=%x", tt.s, prop.EPC, prop.EDT)
+		}
+	}
+}
+
+func TestCli(t *testing.T) {
+	enet, _ := newEchonet(t, echonet.NewSimAircon(1))
+	enet.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	go func() {
+		for range enet.RecvChan {
+		}
+	}()
+	obj, err := enet.NewObject(echonet.Config{Type: "generic", Name: "get",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enet.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cliSet(obj, []string{"b3=20"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "b3 14") ||
+		!strings.HasSuffix(buf.String(), " ok\n") {
+		t.Errorf("set: %q", buf.String())
+	}
+
+	buf.Reset()
+	if err := cliGet(obj, []string{"b3", "b0"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "b3 14") ||
+		!strings.HasPrefix(lines[1], "b0 42") {
+		t.Errorf("get: %q", buf.String())
+	}
+
+	// not supported: the others are printed, the denied returned
+	buf.Reset()
+	err = cliGet(obj, []string{"b0", "c0"}, &buf)
+	var sna *echonet.SNAError
+	if !errors.As(err, &sna) {
+		t.Errorf("get c0: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "b0 42") {
+		t.Errorf("get c0: %q", buf.String())
+	}
+	if err := cliSet(obj, []string{"c0=41"}, io.Discard); !errors.As(err, &sna) {
+		t.Errorf("set c0: %v", err)
+	}
+}
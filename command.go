@@ -0,0 +1,237 @@
+/// command.go ---
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+var errUnknownCommand = errors.New("unknown command")
+
+// command queued from MQTT
+type command struct {
+	obj   *echonet.EchonetObject
+	key   string
+	value string
+}
+
+// commandKeys returns the settable keys of the object, in the order
+// they are applied.
+func commandKeys(obj *echonet.EchonetObject) []string {
+	switch obj.GetType() {
+	case "light":
+		return []string{"power", "brightness"}
+	case "aircon":
+		keys := []string{"mode", "temperature", "humidity", "fan", "fan_level",
+			"swing"}
+		for _, p := range echonet.AirconProperties() {
+			if p.Set {
+				keys = append(keys, p.Key)
+			}
+		}
+		return keys
+	case "generic":
+		// known after the property maps
+		_, set, _ := obj.PropertyMap()
+		var keys []string
+		for _, epc := range set {
+			keys = append(keys, propertyKey(obj, epc))
+		}
+		return keys
+	}
+	return nil
+}
+
+// propertyKey returns the key of the property of a generic object: the
+// short name in the MRA or the EPC in hex.
+func propertyKey(obj *echonet.EchonetObject, epc byte) string {
+	if p := echonet.DefaultMRA().Property(obj.GetEoj(), epc); p != nil &&
+		p.ShortName != "" {
+		return p.ShortName
+	}
+	return fmt.Sprintf("%02x", epc)
+}
+
+// genericState returns the properties of a generic object by key, the
+// values decoded by the MRA or in hex.
+func genericState(obj *echonet.EchonetObject) map[string]string {
+	m := echonet.DefaultMRA()
+	st := map[string]string{}
+	for epc, edt := range obj.GetProperties() {
+		value := hex.EncodeToString(edt)
+		if p := m.Property(obj.GetEoj(), epc); p != nil {
+			if v, ok := p.Decode(edt); ok {
+				value = v
+			}
+		}
+		st[propertyKey(obj, epc)] = value
+	}
+	return st
+}
+
+// setGeneric sets the property of the key to the value, encoded by the
+// MRA or given in hex.
+func setGeneric(obj *echonet.EchonetObject, key, value string) error {
+	m := echonet.DefaultMRA()
+	p := m.PropertyByName(obj.GetEoj(), key)
+	if p == nil {
+		epc, err := strconv.ParseUint(key, 16, 8)
+		if err != nil || len(key) != 2 {
+			return fmt.Errorf("%w: %s", errUnknownCommand, key)
+		}
+		p = m.Property(obj.GetEoj(), byte(epc))
+		if p == nil {
+			p = &echonet.MRAProperty{EPC: byte(epc)} // hex
+		}
+	}
+	edt, err := p.Encode(value)
+	if err != nil {
+		return &echonet.ValueError{Name: key, Value: value}
+	}
+	return obj.SetProperty(p.EPC, edt)
+}
+
+// commandTopics returns the MQTT topics to subscribe for the object.
+func commandTopics(obj *echonet.EchonetObject) []string {
+	topic := fmt.Sprintf("%s/%s", obj.GetType(), obj.GetName())
+	topics := []string{
+		topic + "/trace/set", // packet trace on/off
+	}
+	if obj.GetType() == "generic" {
+		return append(topics, topic+"/+/set") // any property
+	}
+	for _, key := range commandKeys(obj) {
+		topics = append(topics, topic+"/"+key+"/set")
+	}
+	return topics
+}
+
+// applyCommand sets the value of the key, given by the MQTT topic
+// <type>/<name>/<key>/set or the REST API, and returns the outcome
+// reported by the device.
+func applyCommand(obj *echonet.EchonetObject, key, value string) error {
+	switch obj.GetType() {
+
+	case "light":
+		switch key {
+		case "power":
+			return obj.SetPower(value)
+		case "brightness":
+			level, err := strconv.Atoi(value)
+			if err != nil {
+				return &echonet.ValueError{Name: key, Value: value}
+			}
+			return obj.SetBrightness(level)
+		}
+
+	case "aircon":
+		switch key {
+		case "mode":
+			return obj.SetMode(value)
+		case "temperature":
+			temp, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return &echonet.ValueError{Name: key, Value: value}
+			}
+			return obj.SetTargetTemp(int(temp))
+		case "humidity":
+			humi, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return &echonet.ValueError{Name: key, Value: value}
+			}
+			return obj.SetTargetHumidity(int(humi))
+		case "fan", "fan_level":
+			return obj.SetFan(value)
+		case "swing":
+			return obj.SetSwing(value)
+		default:
+			if slices.Contains(commandKeys(obj), key) {
+				return obj.SetAirconProperty(key, value)
+			}
+		}
+
+	case "generic":
+		return setGeneric(obj, key, value)
+	}
+	return fmt.Errorf("%w: %s", errUnknownCommand, key)
+}
+
+// fanLevel returns the fan level to publish, 1 to 8 or auto.
+func fanLevel(obj *echonet.EchonetObject) string {
+	if level := obj.GetFanLevel(); level > 0 {
+		return strconv.Itoa(level)
+	}
+	return "auto"
+}
+
+// formatValue returns the value to publish, "None" if unknown.
+func formatValue(v int, ok bool) string {
+	if !ok {
+		return "None"
+	}
+	return strconv.Itoa(v)
+}
+
+// stateValue returns the value for JSON, null if unknown.
+func stateValue(v int, ok bool) any {
+	if !ok {
+		return nil
+	}
+	return v
+}
+
+// deviceState returns the state of the object; the settable values
+// use the command keys.
+func deviceState(obj *echonet.EchonetObject) map[string]any {
+	obj = obj.Snapshot()
+	st := map[string]any{
+		"type": obj.GetType(),
+		"name": obj.GetName(),
+		"addr": obj.GetAddr(),
+		"eoj":  fmt.Sprintf("%06x", obj.GetEoj()),
+	}
+	if seen := obj.LastSeen(); !seen.IsZero() {
+		st["last_seen"] = seen.Format(time.RFC3339Nano)
+	} else {
+		st["last_seen"] = nil
+	}
+
+	switch obj.GetType() {
+	case "light":
+		st["power"] = obj.GetPower()
+		st["brightness"] = stateValue(obj.GetBrightness())
+	case "aircon":
+		st["power"] = obj.GetPower()
+		st["mode"] = obj.GetMode()
+		st["temperature"] = stateValue(obj.GetTargetTemp())
+		st["humidity"] = stateValue(obj.GetTargetHumidity())
+		st["fan"] = obj.GetFan()
+		st["fan_level"] = fanLevel(obj)
+		st["fan_modes"] = obj.FanModes()
+		st["swing"] = obj.GetSwing()
+		st["swing_modes"] = obj.SwingModes()
+		st["room_temperature"] = stateValue(obj.GetRoomTemp())
+		st["room_humidity"] = stateValue(obj.GetRoomHumidfy())
+		st["outdoor_temperature"] = stateValue(obj.GetOutdoorTemp())
+		st["watt"] = stateValue(obj.GetWatt())
+		for key, value := range obj.GetAirconProperties() {
+			if value == "" {
+				st[key] = nil // unavailable
+			} else {
+				st[key] = value
+			}
+		}
+	case "generic":
+		for key, value := range genericState(obj) {
+			st[key] = value
+		}
+	}
+	return st
+}
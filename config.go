@@ -0,0 +1,1155 @@
+/// config.go ---
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"echonet-mqtt/echonet"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Broker     string           `json:"broker"`
+	Username   string           `json:"username"`
+	Password   string           `json:"password"`
+	Include    string           `json:"include"` // directory of device files
+	HTTP       string           `json:"http"`    // listen address, e.g. ":9100"
+	Echonet    EchonetConfig    `json:"echonet"`
+	Health     HealthConfig     `json:"health"`
+	Log        LogConfig        `json:"log"`
+	ObjectList []echonet.Config `json:"list"`
+
+	files []string // files read, watched for reload
+}
+
+// ECHONET Lite network settings
+type EchonetConfig struct {
+	Port int    `json:"port"` // port to listen on, default 3610
+	Bind string `json:"bind"` // address to listen on, default all
+	// interface names or CIDRs to join the multicast group and send on,
+	// default the interfaces that look like a home network
+	Interfaces []string `json:"interfaces"`
+	// directory of the Machine Readable Appendix of the ECHONET
+	// Consortium, for the properties of generic devices
+	MRA string `json:"mra"`
+	// file to record the frames sent and received to, pcapng for
+	// .pcapng, JSON lines otherwise, but not .pcap; appended to if it
+	// exists
+	Record string `json:"record"`
+}
+
+// Redacted returns the config with the password masked for logging.
+func (cfg Config) Redacted() Config {
+	if cfg.Password != "" {
+		cfg.Password = "***"
+	}
+	return cfg
+}
+
+// environment variables overriding the config file
+var configEnv = []struct {
+	name  string
+	field string
+}{
+	{"ECHONET_MQTT_BROKER", "broker"},
+	{"ECHONET_MQTT_USERNAME", "username"},
+	{"ECHONET_MQTT_PASSWORD", "password"},
+}
+
+// ${NAME} or ${NAME:-default}, $${NAME} is left as ${NAME}
+var envPattern = regexp.MustCompile(
+	`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// ConfigError is a problem found at a location in a config file.
+type ConfigError struct {
+	File string // config file or environment variable
+	Line int    // 0 if unknown
+	Col  int
+	Path string // e.g. "list[2].eoj"
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	s := e.File
+	if e.Line > 0 {
+		s += fmt.Sprintf(":%d:%d", e.Line, e.Col)
+	}
+	if e.Path != "" {
+		s += ": " + e.Path
+	}
+	return s + ": " + e.Msg
+}
+
+// ConfigErrors holds every problem found in a config file.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	var ss []string
+	for _, e := range errs {
+		ss = append(ss, e.Error())
+	}
+	return strings.Join(ss, "\n")
+}
+
+type position struct {
+	line int
+	col  int
+}
+
+// parsed config document with the location of each element
+type configSource struct {
+	file string
+	tree any
+	pos  map[string]position
+	env  map[string]string // element overridden by environment variable
+	errs ConfigErrors
+	// elements of the wrong kind, dropped to check the others
+	bad map[string]bool
+}
+
+// device config with the document it came from
+type deviceSource struct {
+	src  *configSource
+	path string
+	cfg  echonet.Config
+}
+
+// readConfig reads a JSON, YAML or TOML config file, chosen by the
+// extension, and the device files of the include directory.
+func readConfig(fn string) (Config, error) {
+	var cfg Config
+
+	src, err := loadConfigFile(fn)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.files = []string{fn}
+	src.tree = src.interpolate("", src.tree, reflect.TypeOf(cfg))
+
+	if root, ok := src.tree.(map[string]any); ok {
+		for _, e := range configEnv {
+			if v, ok := os.LookupEnv(e.name); ok {
+				root[e.field] = v
+				src.env[e.field] = e.name
+			}
+		}
+	}
+
+	if !src.checkFields("", src.tree, reflect.TypeOf(cfg)) {
+		return cfg, src.sortedErrors()
+	}
+	if err := src.decode(&cfg); err != nil {
+		return cfg, err
+	}
+
+	var devices []deviceSource
+	for i, c := range cfg.ObjectList {
+		devices = append(devices,
+			deviceSource{src, fmt.Sprintf("list[%d]", i), c})
+	}
+
+	var errs ConfigErrors
+	var sources []*configSource
+	if cfg.Include != "" {
+		dir := cfg.Include
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(fn), dir)
+		}
+		cfg.files = append(cfg.files, dir)
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			src.errorf("include", "%s", err)
+		}
+		for _, ent := range entries {
+			name := ent.Name()
+			if ent.IsDir() || strings.HasPrefix(name, ".") ||
+				configFormat(name) == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			cfg.files = append(cfg.files, path)
+
+			fsrc, err := loadConfigFile(path)
+			if err != nil {
+				errs = append(errs, asConfigErrors(path, err)...)
+				continue
+			}
+			sources = append(sources, fsrc)
+
+			var c echonet.Config
+			fsrc.tree = fsrc.interpolate("", fsrc.tree, reflect.TypeOf(c))
+			if !fsrc.checkFields("", fsrc.tree, reflect.TypeOf(c)) {
+				continue
+			}
+			if err := fsrc.decode(&c); err != nil {
+				errs = append(errs, asConfigErrors(path, err)...)
+				continue
+			}
+			cfg.ObjectList = append(cfg.ObjectList, c)
+			devices = append(devices, deviceSource{fsrc, "", c})
+		}
+	}
+
+	src.checkConfig(cfg)
+	checkDevices(devices)
+
+	errs = append(src.sortedErrors(), errs...)
+	for _, s := range sources {
+		errs = append(errs, s.sortedErrors()...)
+	}
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+
+	return cfg, nil
+}
+
+func configFormat(fn string) string {
+	switch strings.ToLower(filepath.Ext(fn)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return ""
+}
+
+func loadConfigFile(fn string) (*configSource, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch configFormat(fn) {
+	case "yaml":
+		return parseYAML(fn, data)
+	case "toml":
+		return parseTOML(fn, data)
+	}
+	return parseJSON(fn, data)
+}
+
+func asConfigErrors(fn string, err error) ConfigErrors {
+	var cerrs ConfigErrors
+	var cerr *ConfigError
+	switch {
+	case errors.As(err, &cerrs):
+		return cerrs
+	case errors.As(err, &cerr):
+		return ConfigErrors{cerr}
+	}
+	return ConfigErrors{&ConfigError{File: fn, Msg: err.Error()}}
+}
+
+// interpolate replaces ${NAME} in the string values of the document
+// with environment variables. It runs after parsing, so a value cannot
+// change the structure of the document. A value of only a variable
+// takes the kind of the field, e.g. port: ${PORT}.
+func (src *configSource) interpolate(path string, v any, t reflect.Type) any {
+	switch v := v.(type) {
+	case map[string]any:
+		var fields map[string]reflect.Type
+		switch t.Kind() {
+		case reflect.Struct:
+			fields, _ = jsonFields(t)
+		case reflect.Map:
+		default:
+			return v // reported by checkFields
+		}
+		for key, child := range v {
+			ft, ok := fields[key]
+			if t.Kind() == reflect.Map {
+				ft, ok = t.Elem(), true
+			}
+			if ok {
+				v[key] = src.interpolate(joinPath(path, key), child, ft)
+			}
+		}
+		return v
+
+	case []any:
+		if t.Kind() != reflect.Slice {
+			return v
+		}
+		for i, child := range v {
+			v[i] = src.interpolate(fmt.Sprintf("%s[%d]", path, i), child, t.Elem())
+		}
+		return v
+
+	case string:
+		return src.expand(path, v, t)
+	}
+	return v
+}
+
+// expand replaces the variables in the string value of the element.
+func (src *configSource) expand(path string, s string, t reflect.Type) any {
+	ms := envPattern.FindAllStringSubmatchIndex(s, -1)
+	if len(ms) == 0 {
+		return s
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range ms {
+		out.WriteString(s[last:m[0]])
+		last = m[1]
+
+		if s[m[0]+1] == '$' {
+			out.WriteString(s[m[0]+1 : m[1]]) // escaped
+			continue
+		}
+
+		name := s[m[2]:m[3]]
+		v, ok := os.LookupEnv(name)
+		if !ok && m[4] >= 0 {
+			v, ok = s[m[6]:m[7]], true
+		}
+		if !ok {
+			src.errorf(path, "undefined environment variable %s", name)
+		}
+		out.WriteString(v)
+	}
+	out.WriteString(s[last:])
+	r := out.String()
+
+	if len(ms) > 1 || ms[0][0] != 0 || ms[0][1] != len(s) || s[1] == '$' {
+		return r
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(r); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Float32, reflect.Float64:
+		if f, err := strconv.ParseFloat(r, 64); err == nil {
+			return f
+		}
+	}
+	return r
+}
+
+func (src *configSource) decode(v any) error {
+	b, err := json.Marshal(src.tree)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return &ConfigError{File: src.file, Msg: err.Error()}
+	}
+	return nil
+}
+
+func (src *configSource) sortedErrors() ConfigErrors {
+	sort.SliceStable(src.errs, func(i, j int) bool {
+		a, b := src.errs[i], src.errs[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Col < b.Col)
+	})
+	return src.errs
+}
+
+// validate checks the config file and reports all problems.
+func validate(fn string) int {
+	_, err := readConfig(fn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%s: ok\n", fn)
+	return 0
+}
+
+func (src *configSource) errorf(path string, format string, args ...any) {
+	// the element of the wrong kind is reported already
+	for parent := path; ; {
+		if src.bad[parent] {
+			return
+		}
+		i := strings.LastIndexAny(parent, ".[")
+		if i < 0 {
+			break
+		}
+		parent = parent[:i]
+	}
+
+	// missing elements are reported at their parent
+	p, ok := src.pos[path]
+	for parent := path; !ok && parent != ""; {
+		if i := strings.LastIndexAny(parent, ".["); i >= 0 {
+			parent = parent[:i]
+		} else {
+			parent = ""
+		}
+		p, ok = src.pos[parent]
+	}
+
+	e := &ConfigError{
+		File: src.file,
+		Line: p.line,
+		Col:  p.col,
+		Path: path,
+		Msg:  fmt.Sprintf(format, args...),
+	}
+	if name, ok := src.env[path]; ok {
+		e.File, e.Line, e.Col = name, 0, 0
+	}
+	src.errs = append(src.errs, e)
+}
+
+// kindErrorf reports the element of the wrong kind and returns false.
+func (src *configSource) kindErrorf(path string, format string, args ...any) bool {
+	src.errorf(path, format, args...)
+	if src.bad == nil {
+		src.bad = map[string]bool{}
+	}
+	src.bad[path] = true
+	return false
+}
+
+// checkFields checks the document against the Go type of the config,
+// reporting unknown fields and values of the wrong kind. The elements
+// of the wrong kind are dropped, so that the rest can be decoded and
+// checked; false is returned for the element itself.
+func (src *configSource) checkFields(path string, v any, t reflect.Type) bool {
+	if v == nil {
+		return true // null is the zero value
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return src.kindErrorf(path, "must be an object")
+		}
+
+		fields, names := jsonFields(t)
+		for key, child := range m {
+			p := joinPath(path, key)
+			ft, ok := fields[key]
+			if !ok {
+				if s := suggest(key, names); s != "" {
+					src.errorf(p, "unknown field %q (did you mean %q?)", key, s)
+				} else {
+					src.errorf(p, "unknown field %q", key)
+				}
+				continue
+			}
+			if !src.checkFields(p, child, ft) {
+				delete(m, key)
+			}
+		}
+
+	case reflect.Slice:
+		a, ok := v.([]any)
+		if !ok {
+			return src.kindErrorf(path, "must be a list")
+		}
+		for i, child := range a {
+			if !src.checkFields(fmt.Sprintf("%s[%d]", path, i), child, t.Elem()) {
+				a[i] = nil
+			}
+		}
+
+	case reflect.Map:
+		m, ok := v.(map[string]any)
+		if !ok {
+			return src.kindErrorf(path, "must be an object")
+		}
+		for key, child := range m {
+			if !src.checkFields(joinPath(path, key), child, t.Elem()) {
+				delete(m, key)
+			}
+		}
+
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			return src.kindErrorf(path, "must be a string")
+		}
+
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			return src.kindErrorf(path, "must be true or false")
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64:
+		f, ok := v.(float64)
+		if !ok || f != float64(int64(f)) {
+			return src.kindErrorf(path, "must be an integer")
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(float64); !ok {
+			return src.kindErrorf(path, "must be a number")
+		}
+	}
+	return true
+}
+
+// jsonFields returns the types of the fields of the struct by their
+// JSON names, and the names in order.
+func jsonFields(t reflect.Type) (map[string]reflect.Type, []string) {
+	fields := map[string]reflect.Type{}
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = f.Type
+		names = append(names, name)
+	}
+	return fields, names
+}
+
+// checkConfig checks the values of the decoded config.
+func (src *configSource) checkConfig(cfg Config) {
+	if cfg.Broker == "" {
+		src.errorf("broker", "missing broker URL")
+	} else if err := checkBroker(cfg.Broker); err != nil {
+		src.errorf("broker", "%s", err)
+	}
+
+	if cfg.HTTP != "" {
+		_, port, err := net.SplitHostPort(cfg.HTTP)
+		if err == nil {
+			_, err = net.LookupPort("tcp", port)
+		}
+		if err != nil {
+			src.errorf("http", "invalid listen address %q", cfg.HTTP)
+		}
+	}
+
+	if p := cfg.Echonet.Port; p < 0 || p > 65535 {
+		src.errorf("echonet.port", "invalid port %d", p)
+	}
+	if b := cfg.Echonet.Bind; b != "" && !validIP(b) {
+		src.errorf("echonet.bind", "invalid address %q", b)
+	}
+	for i, spec := range cfg.Echonet.Interfaces {
+		path := fmt.Sprintf("echonet.interfaces[%d]", i)
+		if strings.Contains(spec, "/") {
+			if _, _, err := net.ParseCIDR(spec); err != nil {
+				src.errorf(path, "invalid CIDR %q", spec)
+			}
+		} else if spec == "" {
+			src.errorf(path, "missing interface name")
+		}
+	}
+	if dir := cfg.Echonet.MRA; dir != "" {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			src.errorf("echonet.mra", "not a directory: %q", dir)
+		}
+	}
+	if rec := cfg.Echonet.Record; rec != "" {
+		if fi, err := os.Stat(filepath.Dir(rec)); err != nil || !fi.IsDir() {
+			src.errorf("echonet.record", "no directory for %q", rec)
+		}
+		if echonet.RecordFormat(rec) == "pcap" {
+			src.errorf("echonet.record", "%q: %s", rec, echonet.ErrPcapRecord)
+		}
+	}
+	if ip, err := netip.ParseAddr(cfg.Echonet.Bind); err == nil &&
+		!ip.IsUnspecified() && len(cfg.Echonet.Interfaces) > 0 {
+		src.errorf("echonet.interfaces",
+			"not used when bound to a unicast address")
+	}
+
+	src.checkLogConfig(cfg.Log)
+
+	for _, d := range []struct {
+		path  string
+		value string
+	}{
+		{"health.receiver_timeout", cfg.Health.ReceiverTimeout},
+		{"health.send_timeout", cfg.Health.SendTimeout},
+		{"health.device_timeout", cfg.Health.DeviceTimeout},
+	} {
+		if d.value == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d.value); err != nil || v <= 0 {
+			src.errorf(d.path,
+				"invalid duration %q (e.g. \"30s\", \"15m\")", d.value)
+		}
+	}
+}
+
+// checkDevices checks the device configs of all files.
+func checkDevices(devices []deviceSource) {
+	seen := map[string]deviceSource{}
+	for _, dev := range devices {
+		src, c := dev.src, dev.cfg
+		path := func(field string) string {
+			return joinPath(dev.path, field)
+		}
+
+		classes, known := echonet.TypeClass[c.Type]
+		if c.Type == "" {
+			src.errorf(path("type"), "missing type")
+		} else if !known {
+			src.errorf(path("type"), "unknown type %q (must be one of %s)",
+				c.Type, strings.Join(knownTypes(), ", "))
+		}
+
+		if c.Name == "" {
+			src.errorf(path("name"), "missing name")
+		} else if strings.ContainsAny(c.Name, "/+#") {
+			src.errorf(path("name"),
+				"invalid name %q: must not contain '/', '+' or '#'", c.Name)
+		} else {
+			key := c.Type + "/" + c.Name
+			if prev, ok := seen[key]; ok {
+				src.errorf(path("name"), "duplicate name %q (also at %s)",
+					c.Name, prev.src.where(joinPath(prev.path, "name")))
+			} else {
+				seen[key] = dev
+			}
+		}
+
+		if c.Addr == "" && c.Id == "" && c.Mac == "" {
+			src.errorf(path("addr"), "missing address (or id or mac)")
+		} else if c.Addr != "" && !validDeviceAddr(c.Addr) {
+			src.errorf(path("addr"), "invalid address %q", c.Addr)
+		}
+		if c.Id != "" {
+			if _, err := echonet.ParseId(c.Id); err != nil {
+				src.errorf(path("id"), "%s", err)
+			}
+		}
+		if c.Mac != "" {
+			if _, err := net.ParseMAC(c.Mac); err != nil {
+				src.errorf(path("mac"), "invalid MAC address %q", c.Mac)
+			}
+		}
+
+		checkFan(c, src, path)
+
+		if c.Eoj == "" {
+			src.errorf(path("eoj"), "missing EOJ")
+			continue
+		}
+		eoj, err := echonet.ParseEoj(c.Eoj)
+		if err != nil {
+			src.errorf(path("eoj"), "%s", err)
+			continue
+		}
+		// instance code 00 addresses all instances of the class
+		if inst := eoj & 0xff; inst > 0x7f {
+			src.errorf(path("eoj"),
+				"invalid instance code %02x in EOJ %q (must be 00-7f)",
+				inst, c.Eoj)
+		}
+		if len(classes) > 0 {
+			class := uint16(eoj >> 8)
+			match := false
+			var expect []string
+			for _, cl := range classes {
+				match = match || cl == class
+				expect = append(expect, fmt.Sprintf("%04x", cl))
+			}
+			if !match {
+				src.errorf(path("eoj"),
+					"class %04x of EOJ %q does not match type %q (expected %s)",
+					class, c.Eoj, c.Type, strings.Join(expect, " or "))
+			}
+		}
+	}
+}
+
+// checkFan checks the fan levels and labels of an aircon.
+func checkFan(c echonet.Config, src *configSource, path func(string) string) {
+	if c.FanLevels == 0 && len(c.FanLabels) == 0 {
+		return
+	}
+	if c.Type != "aircon" {
+		src.errorf(path("fan_levels"),
+			"fan levels of type %q (only aircon)", c.Type)
+		return
+	}
+	if c.FanLevels < 0 || c.FanLevels > 8 {
+		src.errorf(path("fan_levels"),
+			"invalid fan levels %d (must be 1-8)", c.FanLevels)
+	}
+	if n := len(c.FanLabels); n > 8 {
+		src.errorf(path("fan_labels"), "%d fan labels (at most 8)", n)
+	} else if n > 0 && c.FanLevels > 0 && n != c.FanLevels {
+		src.errorf(path("fan_labels"), "%d fan labels for %d levels",
+			n, c.FanLevels)
+	}
+	for i, label := range c.FanLabels {
+		p := fmt.Sprintf("%s[%d]", path("fan_labels"), i)
+		if label == "" || label == "auto" || strings.ContainsAny(label, "/+#") {
+			src.errorf(p, "invalid fan label %q", label)
+		} else if slices.Index(c.FanLabels, label) < i {
+			src.errorf(p, "duplicate fan label %q", label)
+		}
+	}
+}
+
+// where returns the location of the element for messages.
+func (src *configSource) where(path string) string {
+	if p, ok := src.pos[path]; ok {
+		return fmt.Sprintf("%s:%d:%d", src.file, p.line, p.col)
+	}
+	return src.file + ": " + path
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func checkBroker(broker string) error {
+	if !strings.Contains(broker, "://") {
+		broker = "tcp://" + broker // default of paho
+	}
+
+	u, err := url.Parse(broker)
+	if err != nil {
+		return fmt.Errorf("invalid broker URL: %s", err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts", "tcps", "ws", "wss":
+	case "unix":
+		return nil
+	default:
+		return fmt.Errorf("invalid broker URL %q: unsupported scheme %q",
+			broker, u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("invalid broker URL %q: missing host", broker)
+	}
+	if port := u.Port(); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("invalid broker URL %q: invalid port %q",
+				broker, port)
+		}
+	}
+	return nil
+}
+
+// validDeviceAddr accepts an IP address or hostname with an optional
+// port.
+func validDeviceAddr(addr string) bool {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return false
+		}
+		addr = host
+	}
+	return validIP(addr) || validHostname(addr)
+}
+
+// validIP accepts IPv6 addresses with a zone, e.g. fe80::1%eth0.
+func validIP(addr string) bool {
+	_, err := netip.ParseAddr(addr)
+	return err == nil
+}
+
+func validHostname(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return false // malformed IPv4 address
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 ||
+			label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+				c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func knownTypes() []string {
+	var types []string
+	for t := range echonet.TypeClass {
+		types = append(types, strconv.Quote(t))
+	}
+	sort.Strings(types)
+	return types
+}
+
+// suggest returns the candidate close to the misspelled key.
+func suggest(key string, candidates []string) string {
+	best, dist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(strings.ToLower(key), c); d < dist {
+			best, dist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// json parser recording the position of every element
+type jsonParser struct {
+	src  *configSource
+	data []byte
+	dec  *json.Decoder
+}
+
+func parseJSON(fn string, data []byte) (*configSource, error) {
+	src := &configSource{
+		file: fn,
+		pos:  map[string]position{},
+		env:  map[string]string{},
+	}
+	p := &jsonParser{
+		src:  src,
+		data: data,
+		dec:  json.NewDecoder(bytes.NewReader(data)),
+	}
+
+	src.pos[""] = p.next()
+	tree, err := p.value("")
+	if err == nil {
+		if _, err = p.dec.Token(); err == io.EOF {
+			err = nil
+		} else if err == nil {
+			err = errors.New("unexpected data after top-level value")
+		}
+	}
+	if err != nil {
+		off := p.dec.InputOffset()
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			off = serr.Offset
+		}
+		line, col := lineCol(data, int(off))
+		return nil, &ConfigError{File: fn, Line: line, Col: col,
+			Msg: err.Error()}
+	}
+
+	src.tree = tree
+	return src, nil
+}
+
+func (p *jsonParser) value(path string) (any, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		m := map[string]any{}
+		for p.dec.More() {
+			pos := p.next()
+			key, err := p.dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			k := key.(string)
+			child := joinPath(path, k)
+			p.src.pos[child] = pos
+			m[k], err = p.value(child)
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = p.dec.Token() // '}'
+		return m, err
+
+	case json.Delim('['):
+		a := []any{}
+		for i := 0; p.dec.More(); i++ {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			p.src.pos[child] = p.next()
+			v, err := p.value(child)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err = p.dec.Token() // ']'
+		return a, err
+	}
+
+	return tok, nil
+}
+
+// next returns the position of the next token.
+func (p *jsonParser) next() position {
+	off := int(p.dec.InputOffset())
+	for off < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[off]) >= 0 {
+		off++
+	}
+	line, col := lineCol(p.data, off)
+	return position{line, col}
+}
+
+func lineCol(data []byte, off int) (int, int) {
+	if off > len(data) {
+		off = len(data)
+	}
+	line := 1 + bytes.Count(data[:off], []byte("\n"))
+	col := off - bytes.LastIndexByte(data[:off], '\n')
+	return line, col
+}
+
+func parseYAML(fn string, data []byte) (*configSource, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		msg := strings.TrimPrefix(err.Error(), "yaml: ")
+		line := 0
+		if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			msg = m[2]
+		}
+		return nil, &ConfigError{File: fn, Line: line, Col: 1, Msg: msg}
+	}
+
+	src := &configSource{
+		file: fn,
+		pos:  map[string]position{"": {1, 1}},
+		env:  map[string]string{},
+	}
+	if len(doc.Content) > 0 {
+		src.tree = src.yamlValue("", doc.Content[0])
+	}
+	return src, nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`^line (\d+): (.*)`)
+
+func (src *configSource) yamlValue(path string, n *yaml.Node) any {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return src.yamlValue(path, n.Alias)
+
+	case yaml.MappingNode:
+		m := map[string]any{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+			child := joinPath(path, k.Value)
+			src.pos[child] = position{k.Line, k.Column}
+			m[k.Value] = src.yamlValue(child, v)
+		}
+		return m
+
+	case yaml.SequenceNode:
+		a := []any{}
+		for i, e := range n.Content {
+			child := fmt.Sprintf("%s[%d]", path, i)
+			src.pos[child] = position{e.Line, e.Column}
+			a = append(a, src.yamlValue(child, e))
+		}
+		return a
+	}
+
+	var v any
+	if err := n.Decode(&v); err != nil {
+		return n.Value
+	}
+	return normalize(v)
+}
+
+func parseTOML(fn string, data []byte) (*configSource, error) {
+	var tree map[string]any
+	if _, err := toml.Decode(string(data), &tree); err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			line, col := lineCol(data, perr.Position.Start)
+			msg := perr.Message
+			if msg == "" {
+				msg = err.Error()
+			}
+			return nil, &ConfigError{File: fn, Line: line, Col: col, Msg: msg}
+		}
+		return nil, &ConfigError{File: fn, Msg: err.Error()}
+	}
+
+	return &configSource{
+		file: fn,
+		tree: normalize(tree),
+		pos:  tomlPositions(data),
+		env:  map[string]string{},
+	}, nil
+}
+
+// tomlPositions returns the location of the tables and keys, which the
+// TOML decoder does not tell. The elements inside values spanning lines,
+// e.g. arrays of inline tables, are located at the key.
+func tomlPositions(data []byte) map[string]position {
+	pos := map[string]position{"": {1, 1}}
+	tables := map[string]int{} // elements of the arrays of tables
+	table := ""
+	depth := 0  // of the brackets of a value spanning lines
+	multi := "" // delimiter of a multi-line string
+	for i, line := range strings.Split(string(data), "\n") {
+		text := strings.TrimLeft(line, " \t")
+		at := position{i + 1, len(line) - len(text) + 1}
+
+		if depth > 0 || multi != "" {
+			depth, multi = tomlScan(text, depth, multi)
+			continue
+		}
+		switch {
+		case text == "" || text[0] == '#':
+		case strings.HasPrefix(text, "[["):
+			end := strings.Index(text, "]]")
+			if end < 0 {
+				continue
+			}
+			path := tomlKey(text[2:end])
+			if _, ok := pos[path]; !ok {
+				pos[path] = at
+			}
+			table = fmt.Sprintf("%s[%d]", path, tables[path])
+			tables[path] += 1
+			pos[table] = at
+		case text[0] == '[':
+			end := strings.IndexByte(text, ']')
+			if end < 0 {
+				continue
+			}
+			table = tomlKey(text[1:end])
+			pos[table] = at
+		default:
+			key, value, ok := strings.Cut(text, "=")
+			if !ok {
+				continue
+			}
+			pos[joinPath(table, tomlKey(key))] = at
+			depth, multi = tomlScan(value, 0, "")
+		}
+	}
+	return pos
+}
+
+// tomlKey returns the path of the dotted key.
+func tomlKey(key string) string {
+	var parts []string
+	for _, k := range strings.Split(key, ".") {
+		parts = append(parts, strings.Trim(k, " \t\"'"))
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlScan follows the brackets and multi-line strings of a value.
+func tomlScan(text string, depth int, multi string) (int, string) {
+	for i := 0; i < len(text); i++ {
+		if multi != "" {
+			if strings.HasPrefix(text[i:], multi) {
+				i += len(multi) - 1
+				multi = ""
+			}
+			continue
+		}
+		switch c := text[i]; c {
+		case '#':
+			return depth, multi
+		case '[', '{':
+			depth += 1
+		case ']', '}':
+			depth -= 1
+		case '"', '\'':
+			if delim := strings.Repeat(string(c), 3); strings.HasPrefix(text[i:], delim) {
+				multi = delim
+				i += 2
+				continue
+			}
+			// a string on the line
+			for i++; i < len(text) && text[i] != c; i++ {
+				if c == '"' && text[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return depth, multi
+}
+
+// normalize converts decoded YAML/TOML values to the JSON data model.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+		return v
+	case []map[string]any:
+		a := []any{}
+		for _, e := range v {
+			a = append(a, normalize(e))
+		}
+		return a
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	}
+	return v
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the files into a temporary directory and returns
+// the path of the first.
+func writeConfig(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	first := ""
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if first == "" || strings.HasPrefix(name, "config.") {
+			first = path
+		}
+	}
+	return first
+}
+
+func TestConfigInterpolate(t *testing.T) {
+	t.Setenv("TEST_PASSWORD", "a\"b: c\nbroker: evil")
+	t.Setenv("TEST_PORT", "3611")
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		data := map[string]string{
+			"config.json": `{"broker": "tcp://${TEST_HOST:-localhost}:1883",
+"password": "${TEST_PASSWORD}", "username": "$${USER}",
+"echonet": {"port": "${TEST_PORT}"}}`,
+			"config.yaml": `broker: tcp://${TEST_HOST:-localhost}:1883
+password: ${TEST_PASSWORD}
+username: $${USER}
+echonet:
+  port: ${TEST_PORT}
+`,
+			"config.toml": `broker = "tcp://${TEST_HOST:-localhost}:1883"
+password = "${TEST_PASSWORD}"
+username = "$${USER}"
+[echonet]
+port = "${TEST_PORT}"
+`,
+		}[name]
+		cfg, err := readConfig(writeConfig(t, map[string]string{name: data}))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if cfg.Broker != "tcp://localhost:1883" ||
+			cfg.Password != "a\"b: c\nbroker: evil" ||
+			cfg.Username != "${USER}" || cfg.Echonet.Port != 3611 {
+			t.Errorf("%s: %+v", name, cfg)
+		}
+	}
+
+	fn := writeConfig(t, map[string]string{"config.yaml": `broker: tcp://localhost
+password: ${TEST_UNDEFINED}
+`})
+	_, err := readConfig(fn)
+	if err == nil || !strings.Contains(err.Error(), "config.yaml:2:1: password: undefined environment variable TEST_UNDEFINED") {
+		t.Errorf("undefined: %v", err)
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	for name, data := range map[string]string{
+		"config.yaml": `broker: tcp://localhost
+username: user
+echonet:
+  port: 3611
+  interfaces: [eth0]
+list:
+  - type: aircon
+    name: living
+    addr: 192.168.1.10
+    eoj: "013001"
+    fan_levels: 5
+`,
+		"config.toml": `broker = "tcp://localhost"
+username = "user"
+
+[echonet]
+port = 3611
+interfaces = ["eth0"]
+
+[[list]]
+type = "aircon"
+name = "living"
+addr = "192.168.1.10"
+eoj = "013001"
+fan_levels = 5
+`,
+	} {
+		cfg, err := readConfig(writeConfig(t, map[string]string{name: data}))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if cfg.Broker != "tcp://localhost" || cfg.Echonet.Port != 3611 ||
+			len(cfg.Echonet.Interfaces) != 1 || len(cfg.ObjectList) != 1 ||
+			cfg.ObjectList[0].Eoj != "013001" || cfg.ObjectList[0].FanLevels != 5 {
+			t.Errorf("%s: %+v", name, cfg)
+		}
+	}
+}
+
+func TestConfigTOMLErrors(t *testing.T) {
+	fn := writeConfig(t, map[string]string{"config.toml": `broker = "tcp://localhost"
+http = 9100
+
+[echonet]
+port = 70000
+# comment = [
+interfaces = [
+  "eth0",
+]
+
+[[list]]
+type = "aircon"
+name = "living"
+addr = "192.168.1.10"
+eoj = "013001"
+
+[[list]]
+type = "aircon"
+name = "bedroom"
+addr = "192.168.1.11"
+eoj = 13001
+fan_level = 3
+`})
+	_, err := readConfig(fn)
+	expect := []string{
+		"config.toml:2:1: http: must be a string",
+		"config.toml:5:1: echonet.port: invalid port 70000",
+		"config.toml:21:1: list[1].eoj: must be a string",
+		`config.toml:22:1: list[1].fan_level: unknown field "fan_level" (did you mean "fan_levels"?)`,
+	}
+	checkErrors(t, err, expect)
+}
+
+// checkErrors checks the messages of the config errors, without the
+// directory of the files.
+func checkErrors(t *testing.T, err error, expect []string) {
+	t.Helper()
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v", err)
+	}
+	var msgs []string
+	for _, e := range errs {
+		e := *e
+		e.File = filepath.Base(e.File)
+		msgs = append(msgs, e.Error())
+	}
+	if !slices.Equal(msgs, expect) {
+		t.Errorf("errors\n%s\nexpect\n%s", strings.Join(msgs, "\n"),
+			strings.Join(expect, "\n"))
+	}
+}
+
+func TestConfigEnv(t *testing.T) {
+	t.Setenv("ECHONET_MQTT_BROKER", "tcp://broker:1883")
+	t.Setenv("ECHONET_MQTT_PASSWORD", "secret")
+	t.Setenv("ECHONET_MQTT_USERNAME", "")
+	fn := writeConfig(t, map[string]string{"config.json": `{
+  "broker": "tcp://localhost", "username": "user"
+}`})
+	cfg, err := readConfig(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Broker != "tcp://broker:1883" || cfg.Password != "secret" ||
+		cfg.Username != "" {
+		t.Errorf("config %+v", cfg)
+	}
+
+	// reported at the variable
+	t.Setenv("ECHONET_MQTT_BROKER", "ftp://broker")
+	_, err = readConfig(fn)
+	checkErrors(t, err, []string{
+		`ECHONET_MQTT_BROKER: broker: invalid broker URL "ftp://broker": unsupported scheme "ftp"`,
+	})
+}
+
+func TestConfigInclude(t *testing.T) {
+	fn := writeConfig(t, map[string]string{
+		"config.yaml": `broker: tcp://localhost
+include: devices
+list:
+  - {type: light, name: hall, addr: 192.168.1.20, eoj: "029001"}
+`,
+		"devices/living.json": `{"type": "aircon", "name": "living",
+ "addr": "192.168.1.10", "eoj": "013001"}`,
+		"devices/bedroom.toml": `type = "aircon"
+name = "bedroom"
+mac = "00:11:22:33:44:55"
+eoj = "013001"
+`,
+		"devices/.hidden.json": `not read`,
+		"devices/README":       `not read`,
+	})
+	cfg, err := readConfig(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range cfg.ObjectList {
+		names = append(names, c.Type+"/"+c.Name)
+	}
+	if !slices.Equal(names, []string{"light/hall", "aircon/bedroom", "aircon/living"}) {
+		t.Errorf("devices %v", names)
+	}
+	if len(cfg.files) != 4 {
+		t.Errorf("files %v", cfg.files)
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		data   string
+		expect []string
+	}{
+		{"broker", `{"broker": "ftp://host", "http": "nohost",
+ "echonet": {"port": -1, "bind": "10.0.0.300", "interfaces": ["10.0.0.0/33", ""]}}`,
+			[]string{
+				`config.json:1:2: broker: invalid broker URL "ftp://host": unsupported scheme "ftp"`,
+				`config.json:1:26: http: invalid listen address "nohost"`,
+				`config.json:2:14: echonet.port: invalid port -1`,
+				`config.json:2:26: echonet.bind: invalid address "10.0.0.300"`,
+				`config.json:2:63: echonet.interfaces[0]: invalid CIDR "10.0.0.0/33"`,
+				`config.json:2:78: echonet.interfaces[1]: missing interface name`,
+			}},
+		{"missing broker", `{"health": {"device_timeout": "5"}}`,
+			[]string{
+				`config.json:1:1: broker: missing broker URL`,
+				`config.json:1:13: health.device_timeout: invalid duration "5" (e.g. "30s", "15m")`,
+			}},
+		{"unicast bind", `{"broker": "tcp://localhost",
+ "echonet": {"bind": "192.168.1.2", "interfaces": ["eth0"]}}`,
+			[]string{
+				`config.json:2:37: echonet.interfaces: not used when bound to a unicast address`,
+			}},
+		{"pcap record", `{"broker": "tcp://localhost",
+ "echonet": {"record": "traffic.pcap"}}`,
+			[]string{
+				`config.json:2:14: echonet.record: "traffic.pcap": pcap cannot be appended to, record to .pcapng instead`,
+			}},
+		{"fields", `{"broker": "tcp://localhost", "brokr": 1, "log": {"lvl": "debug"},
+ "echonet": []}`,
+			[]string{
+				`config.json:1:31: brokr: unknown field "brokr" (did you mean "broker"?)`,
+				`config.json:1:51: log.lvl: unknown field "lvl" (did you mean "level"?)`,
+				`config.json:2:2: echonet: must be an object`,
+			}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readConfig(writeConfig(t, map[string]string{"config.json": tc.data}))
+			checkErrors(t, err, tc.expect)
+		})
+	}
+}
+
+func TestCheckDevices(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		list   string
+		expect []string
+	}{
+		{"missing", `[{}]`,
+			[]string{
+				`config.yaml:3:6: list[0].type: missing type`,
+				`config.yaml:3:6: list[0].name: missing name`,
+				`config.yaml:3:6: list[0].addr: missing address (or id or mac)`,
+				`config.yaml:3:6: list[0].eoj: missing EOJ`,
+			}},
+		{"values", `
+    - type: aircn
+      name: a/b
+      addr: 10.0.0.1:99999
+      id: fe00
+      mac: 00:11
+      eoj: "0130ff"`,
+			[]string{
+				`config.yaml:3:7: list[0].type: unknown type "aircn" (must be one of "aircon", "generic", "light")`,
+				`config.yaml:4:7: list[0].name: invalid name "a/b": must not contain '/', '+' or '#'`,
+				`config.yaml:5:7: list[0].addr: invalid address "10.0.0.1:99999"`,
+				`config.yaml:6:7: list[0].id: invalid id "fe00": must be 34 hex digits`,
+				`config.yaml:7:7: list[0].mac: invalid MAC address "00:11"`,
+				`config.yaml:8:7: list[0].eoj: invalid instance code ff in EOJ "0130ff" (must be 00-7f)`,
+			}},
+		{"class", `
+    - {type: light, name: hall, addr: 10.0.0.1, eoj: "013001"}`,
+			[]string{
+				`config.yaml:3:49: list[0].eoj: class 0130 of EOJ "013001" does not match type "light" (expected 0290 or 0291)`,
+			}},
+		{"duplicate", `
+    - {type: light, name: hall, addr: 10.0.0.1, eoj: "029001"}
+    - {type: light, name: hall, addr: 10.0.0.2, eoj: "029001"}
+    - {type: aircon, name: hall, addr: 10.0.0.3, eoj: "013001"}`,
+			[]string{
+				`config.yaml:4:21: list[1].name: duplicate name "hall" (also at config.yaml:3:21)`,
+			}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := "broker: tcp://localhost\nlist:\n    " + tc.list + "\n"
+			if strings.HasPrefix(tc.list, "\n") {
+				data = "broker: tcp://localhost\nlist:" + tc.list + "\n"
+			}
+			fn := writeConfig(t, map[string]string{"config.yaml": data})
+			_, err := readConfig(fn)
+			var errs ConfigErrors
+			if errors.As(err, &errs) {
+				// the file of the duplicate is the whole path
+				for _, e := range errs {
+					e.Msg = strings.ReplaceAll(e.Msg, filepath.Dir(fn)+"/", "")
+				}
+			}
+			checkErrors(t, err, tc.expect)
+		})
+	}
+}
+
+func TestCheckDevicesInclude(t *testing.T) {
+	fn := writeConfig(t, map[string]string{
+		"config.yaml": `broker: tcp://localhost
+include: devices
+list:
+  - {type: light, name: hall, addr: 10.0.0.1, eoj: "029001"}
+`,
+		"devices/hall.yaml": `type: light
+name: hall
+addr: 10.0.0.2
+eoj: "029001"
+`,
+		"devices/living.json": `{"type": "aircon", "nme": "living",
+ "addr": "10.0.0.3", "eoj": "013001"}`,
+	})
+	_, err := readConfig(fn)
+	var errs ConfigErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			e.Msg = strings.ReplaceAll(e.Msg, filepath.Dir(fn)+"/", "")
+		}
+	}
+	checkErrors(t, err, []string{
+		`hall.yaml:2:1: name: duplicate name "hall" (also at config.yaml:4:19)`,
+		`living.json:1:1: name: missing name`,
+		`living.json:1:20: nme: unknown field "nme" (did you mean "name"?)`,
+	})
+}
+
+func TestCheckFan(t *testing.T) {
+	for _, tc := range []struct {
+		device string
+		expect []string
+	}{
+		{`type = "aircon"
+fan_levels = 5
+fan_labels = ["silent", "low", "mid", "high", "turbo"]`, nil},
+		{`type = "light"
+fan_levels = 3`,
+			[]string{`dev.toml:2:1: fan_levels: fan levels of type "light" (only aircon)`}},
+		{`type = "aircon"
+fan_levels = 9
+fan_labels = ["a", "auto", "a", ""]`,
+			[]string{
+				`dev.toml:2:1: fan_levels: invalid fan levels 9 (must be 1-8)`,
+				`dev.toml:3:1: fan_labels: 4 fan labels for 9 levels`,
+				`dev.toml:3:1: fan_labels[1]: invalid fan label "auto"`,
+				`dev.toml:3:1: fan_labels[2]: duplicate fan label "a"`,
+				`dev.toml:3:1: fan_labels[3]: invalid fan label ""`,
+			}},
+	} {
+		class := `eoj = "013001"`
+		if strings.Contains(tc.device, "light") {
+			class = `eoj = "029001"`
+		}
+		fn := writeConfig(t, map[string]string{
+			"config.yaml": "broker: tcp://localhost\ninclude: devices\n",
+			"devices/dev.toml": tc.device + "\nname = \"dev\"\naddr = \"10.0.0.1\"\n" +
+				class + "\n",
+		})
+		_, err := readConfig(fn)
+		if tc.expect == nil {
+			if err != nil {
+				t.Errorf("%s: %v", tc.device, err)
+			}
+			continue
+		}
+		checkErrors(t, err, tc.expect)
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"strings"
@@ -12,50 +11,114 @@ import (
 	"echonet-mqtt/echonet"
 )
 
-type Config struct {
-	Broker     string           `json:"broker"`
-	ObjectList []echonet.Config `json:"list"`
-}
-
 func main() {
-	log.SetFlags(log.Flags() | log.Lmicroseconds)
+	if len(os.Args) == 3 && os.Args[1] == "validate" {
+		os.Exit(validate(os.Args[2]))
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "simulate" {
+		os.Exit(simulate(os.Args[2:]))
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "sniff" {
+		os.Exit(sniff(os.Args[2:]))
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "replay" {
+		os.Exit(replay(os.Args[2:]))
+	}
+	if len(os.Args) >= 2 && (os.Args[1] == "get" || os.Args[1] == "set") {
+		os.Exit(cli(os.Args[1], os.Args[2:]))
+	}
 
 	if len(os.Args) != 2 {
 		fmt.Println("usage: echonet2mqtt <CONFIG FILE>")
+		fmt.Println("       echonet2mqtt validate <CONFIG FILE>")
+		fmt.Println("       echonet2mqtt simulate [flags] [aircon|light|meter ...]")
+		fmt.Println("       echonet2mqtt sniff [flags]")
+		fmt.Println("       echonet2mqtt replay [flags] <CONFIG FILE> <RECORDING>")
+		fmt.Println("       echonet2mqtt get [flags] <IP> <EOJ> <EPC ...>")
+		fmt.Println("       echonet2mqtt set [flags] <IP> <EOJ> <EPC>=<VALUE ...>")
 		os.Exit(0)
 	}
 
 	fn := os.Args[1]
 	cfg, err := readConfig(fn)
 	if err != nil {
-		log.Fatalf("%s: %s", fn, err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	log.Printf("config: %+v\n", cfg)
+	setupLogging(os.Stderr, cfg.Log)
+	log_config.Info("config", "file", fn, "config", fmt.Sprintf("%+v", cfg.Redacted()))
 
-	err = echonet_mqtt(cfg)
+	err = echonet_mqtt(fn, cfg)
 	if err != nil {
-		log.Fatalf("%s", err)
+		fatalf("%s", err)
 	}
 
 	os.Exit(0)
 }
 
-func echonet_mqtt(cfg Config) error {
-	enet, err := echonet.NewEchonet()
+func echonet_mqtt(fn string, cfg Config) error {
+	if cfg.Echonet.MRA != "" {
+		m, err := echonet.LoadMRA(cfg.Echonet.MRA)
+		if err != nil {
+			return err
+		}
+		echonet.UseMRA(m)
+		log_config.Info("mra loaded", "dir", cfg.Echonet.MRA,
+			"classes", m.Classes())
+	}
+
+	var rec *echonet.Recorder
+	if path := cfg.Echonet.Record; path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rec, err = echonet.NewRecorder(f, echonet.RecordFormat(path))
+		if err != nil {
+			return err
+		}
+		log_config.Info("recording", "file", path)
+	}
+
+	enet, err := echonet.NewEchonetOptions(echonet.Options{
+		Port:       cfg.Echonet.Port,
+		Bind:       cfg.Echonet.Bind,
+		Interfaces: cfg.Echonet.Interfaces,
+		Recorder:   rec,
+	})
+	if err != nil {
+		return err
+	}
+
+	mqtt, err := NewMqtt(cfg.Broker, cfg.Username, cfg.Password)
 	if err != nil {
 		return err
 	}
 
+	return run(fn, cfg, enet, mqtt, nil)
+}
+
+// run bridges the devices of the config between enet and mqtt until
+// stop is closed.
+func run(fn string, cfg Config, enet *echonet.Echonet, mqtt Mqtt,
+	stop <-chan struct{}) error {
+
 	for _, c := range cfg.ObjectList {
 		obj, err := enet.NewObject(c)
 		if err != nil {
 			return err
 		}
-		log.Printf("added %s:%06x %s %s\n", c.Addr,
-			obj.GetEoj(), obj.GetType(), obj.GetName())
+		log_bridge.Info("added", "addr", c.Addr,
+			"eoj", fmt.Sprintf("%06x", obj.GetEoj()),
+			"type", obj.GetType(), "name", obj.GetName())
 	}
 
-	err = enet.Start()
+	enet.Listen(metrics.handleEvent)
+	enet.Listen(stream.handleEvent)
+	health.Configure(cfg.Health)
+
+	err := enet.Start()
 	if err != nil {
 		return err
 	}
@@ -65,105 +128,168 @@ func echonet_mqtt(cfg Config) error {
 		time.Sleep(3 * time.Second)
 	}
 
-	mqtt, err := NewMqtt(cfg.Broker)
-	if err != nil {
-		return err
-	}
-
 	// status update
 	go func() {
 		for {
-			err = enet.StateAll()
+			err := enet.StateAll()
 			if err != nil {
-				log.Fatalf("%s", err)
+				fatalf("%s", err)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(300 * time.Second):
 			}
-			time.Sleep(300 * time.Second)
 		}
 	}()
 
 	for _, obj := range enet.List() {
-		topic := fmt.Sprintf("%s/%s", obj.GetType(), obj.GetName())
-		switch obj.GetType() {
-		case "light":
-			mqtt.Subscribe(topic + "/power/set")
-		case "aircon":
-			mqtt.Subscribe(topic + "/mode/set")
-			mqtt.Subscribe(topic + "/temperature/set")
-			mqtt.Subscribe(topic + "/humidity/set")
-			mqtt.Subscribe(topic + "/fan/set")
-			mqtt.Subscribe(topic + "/swing/set")
+		mqtt.Subscribe(commandTopics(obj)...)
+	}
+
+	if cfg.HTTP != "" {
+		err = startHTTP(cfg.HTTP, enet, mqtt)
+		if err != nil {
+			return err
 		}
 	}
 
+	watcher := watchConfig(cfg.files)
+
+	// The commands wait for the response of the device, so they are
+	// applied in order by a worker.
+	commands := make(chan command, 32)
+	defer close(commands)
+	updated := make(chan *echonet.EchonetObject, 32)
+	go func() {
+		for cmd := range commands {
+			err := applyCommand(cmd.obj, cmd.key, cmd.value)
+			if err != nil {
+				log_bridge.Warn("command failed",
+					"device", cmd.obj.GetType()+"/"+cmd.obj.GetName(),
+					"command", cmd.key, "value", cmd.value, "err", err)
+			}
+			select {
+			case updated <- cmd.obj:
+			case <-stop:
+			}
+		}
+	}()
+
 	var update_nodes []*echonet.EchonetObject
 
 	for {
 		select {
 
+		case <-stop:
+			return nil
+
+		case <-watcher.C:
+			newcfg, err := readConfig(fn)
+			if err != nil {
+				log_config.Error("reload failed", "err", err)
+				continue
+			}
+			cfg = reloadConfig(enet, mqtt, cfg, newcfg)
+			watcher.Watch(cfg.files)
+
+			// drop pending updates of removed objects
+			var nodes []*echonet.EchonetObject
+			for _, node := range update_nodes {
+				if enet.FindObject(node.GetType(), node.GetName()) == node {
+					nodes = append(nodes, node)
+				}
+			}
+			update_nodes = nodes
+
 		case obj := <-enet.RecvChan:
-			//log.Printf("recv_echonet: %+v\n", node)
+			//log_bridge.Debug("recv_echonet", "obj", obj)
+			if enet.FindObject(obj.GetType(), obj.GetName()) != obj {
+				continue // removed by reload
+			}
+			obj = obj.Snapshot()
 			topic := fmt.Sprintf("%s/%s", obj.GetType(), obj.GetName())
 			switch obj.GetType() {
 
 			case "light":
 				mqtt.Send(topic+"/power", obj.GetPower())
+				if v, ok := obj.GetBrightness(); ok {
+					mqtt.Send(topic+"/brightness", strconv.Itoa(v))
+				}
 
 			case "aircon":
 				mqtt.Send(topic+"/mode", obj.GetMode())
 				mqtt.Send(topic+"/temperature",
-					strconv.Itoa(obj.GetTargetTemp()))
+					formatValue(obj.GetTargetTemp()))
 				mqtt.Send("sensor/"+topic+"/temperature",
-					strconv.Itoa(obj.GetRoomTemp()))
+					formatValue(obj.GetRoomTemp()))
 				mqtt.Send("sensor/"+topic+"/outtemp",
-					strconv.Itoa(obj.GetOutdoorTemp()))
+					formatValue(obj.GetOutdoorTemp()))
 				mqtt.Send(topic+"/humidity",
-					strconv.Itoa(obj.GetTargetHumidity()))
+					formatValue(obj.GetTargetHumidity()))
 				mqtt.Send("sensor/"+topic+"/humidity",
-					strconv.Itoa(obj.GetRoomHumidfy()))
+					formatValue(obj.GetRoomHumidfy()))
 				mqtt.Send(topic+"/fan", obj.GetFan())
+				mqtt.Send(topic+"/fan_level", fanLevel(obj))
+				fans, _ := json.Marshal(obj.FanModes())
+				mqtt.Send(topic+"/fan_modes", string(fans))
 				mqtt.Send(topic+"/swing", obj.GetSwing())
+				modes, _ := json.Marshal(obj.SwingModes())
+				mqtt.Send(topic+"/swing_modes", string(modes))
 				mqtt.Send("sensor/"+topic+"/watt",
-					strconv.Itoa(obj.GetWatt()))
+					formatValue(obj.GetWatt()))
+				// the optional properties the device has
+				props := obj.GetAirconProperties()
+				for _, p := range echonet.AirconProperties() {
+					value, ok := props[p.Key]
+					if !ok {
+						continue
+					}
+					if value == "" {
+						value = "None"
+					}
+					if p.Sensor {
+						mqtt.Send("sensor/"+topic+"/"+p.Key, value)
+					} else {
+						mqtt.Send(topic+"/"+p.Key, value)
+					}
+				}
+
+			case "generic":
+				for key, value := range genericState(obj) {
+					mqtt.Send(topic+"/"+key, value)
+				}
 			}
+			stream.State(obj)
 
-		case msg := <-recv_mqtt:
-			log.Printf("recv_mqtt: %+v\n", msg)
+		case msg := <-mqtt.Messages():
+			log_mqtt.Debug("recv", "topic", msg[0], "payload", msg[1])
 			topic := strings.Split(msg[0], "/")
 			payload := msg[1]
 
+			if len(topic) != 4 {
+				log_mqtt.Warn("invalid topic", "topic", msg[0])
+				continue
+			}
 			node := enet.FindObject(topic[0], topic[1])
 			if node == nil {
-				return fmt.Errorf("invalid topic: %s", msg[0])
+				log_mqtt.Warn("invalid topic", "topic", msg[0])
+				continue
 			}
+			metrics.Command(topic[2])
 
-			switch topic[0] {
-
-			case "light":
-				switch topic[2] {
-				case "power":
-					node.SetPower(payload)
-				default:
-					return fmt.Errorf("invalid topic: %s", msg[0])
-				}
-				//update_nodes = append(update_nodes, node)
+			if topic[2] == "trace" {
+				setTrace(topic[0]+"/"+topic[1], payload == "on")
+				continue
+			}
+			select {
+			case commands <- command{node, topic[2], payload}:
+			default:
+				log_bridge.Warn("command dropped, queue full", "topic", msg[0])
+			}
 
-			case "aircon":
-				switch topic[2] {
-				case "mode":
-					node.SetMode(payload)
-				case "temperature":
-					temp, _ := strconv.ParseFloat(payload, 32)
-					node.SetTargetTemp(int(temp))
-				case "humidity":
-					humi, _ := strconv.ParseFloat(payload, 32)
-					node.SetTargetHumidity(int(humi))
-				case "fan":
-					node.SetFan(payload)
-				case "swing":
-					node.SetSwing(payload)
-				default:
-					return fmt.Errorf("invalid topic: %s", msg[0])
-				}
+		case node := <-updated:
+			if node.GetType() != "light" {
 				update_nodes = append(update_nodes, node)
 			}
 
@@ -175,19 +301,3 @@ func echonet_mqtt(cfg Config) error {
 		}
 	}
 }
-
-func readConfig(fn string) (Config, error) {
-	var cfg Config
-
-	fp, err := os.Open(fn)
-	if err != nil {
-		return cfg, err
-	}
-
-	jsondec := json.NewDecoder(fp)
-	if err := jsondec.Decode(&cfg); err != nil {
-		return cfg, err
-	}
-
-	return cfg, nil
-}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+// fakeMqtt records the published messages and the subscribed topics.
+type fakeMqtt struct {
+	mutex      sync.Mutex
+	published  map[string]string
+	subscribed map[string]bool
+	recv       chan [2]string
+	down       bool // not connected
+}
+
+func (m *fakeMqtt) Send(topic string, payload string) {
+	m.mutex.Lock()
+	m.published[topic] = payload
+	m.mutex.Unlock()
+}
+
+func (m *fakeMqtt) Subscribe(topics ...string) error {
+	m.mutex.Lock()
+	if m.subscribed == nil {
+		m.subscribed = map[string]bool{}
+	}
+	for _, topic := range topics {
+		m.subscribed[topic] = true
+	}
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *fakeMqtt) Unsubscribe(topics ...string) error {
+	m.mutex.Lock()
+	for _, topic := range topics {
+		delete(m.subscribed, topic)
+	}
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *fakeMqtt) IsConnected() bool          { return !m.down }
+func (m *fakeMqtt) Messages() <-chan [2]string { return m.recv }
+
+// wait waits until the topic is published with the payload.
+func (m *fakeMqtt) wait(t *testing.T, topic, payload string) {
+	t.Helper()
+	var got string
+	for i := 0; i < 50; i++ {
+		m.mutex.Lock()
+		got = m.published[topic]
+		m.mutex.Unlock()
+		if got == payload {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("%s = %q expect %q", topic, got, payload)
+}
+
+// newEchonet connects an Echonet at 10.0.0.1 to a simulator of the
+// objects at 10.0.0.2 on a memory network. The Echonet is not started;
+// both are closed at the end of the test.
+func newEchonet(t *testing.T, objs ...*echonet.SimObject) (*echonet.Echonet, *echonet.Simulator) {
+	network := echonet.NewMemoryNetwork()
+	sim := echonet.NewSimulator("10.0.0.2:3610")
+	sim.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	var err error
+	sim.Transport, err = network.Attach(sim.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		sim.Add(obj)
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+
+	tr, err := network.Attach("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enet, err := echonet.NewEchonetOptions(echonet.Options{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { enet.Close() })
+	enet.Interval = 0
+	enet.Timeout = 200 * time.Millisecond
+	return enet, sim
+}
+
+// startBridge runs the bridge for an aircon and a light hosted by
+// a simulator on a memory network.
+func startBridge(t *testing.T) (*fakeMqtt, *echonet.Echonet, *echonet.Simulator) {
+	setupLogging(io.Discard, LogConfig{})
+	enet, sim := newEchonet(t, echonet.NewSimAircon(1), echonet.NewSimLight(1))
+
+	cfg := Config{
+		ObjectList: []echonet.Config{
+			{Type: "aircon", Name: "living", Addr: "10.0.0.2", Eoj: "013001"},
+			{Type: "light", Name: "hall", Addr: "10.0.0.2", Eoj: "029001"},
+		},
+	}
+	mqtt := &fakeMqtt{
+		published: map[string]string{},
+		recv:      make(chan [2]string, 8),
+	}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- run("", cfg, enet, mqtt, stop)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return mqtt, enet, sim
+}
+
+func TestBridgeState(t *testing.T) {
+	mqtt, _, _ := startBridge(t)
+
+	mqtt.wait(t, "aircon/living/mode", "off")
+	mqtt.wait(t, "aircon/living/temperature", "26")
+	mqtt.wait(t, "sensor/aircon/living/temperature", "28")
+	mqtt.wait(t, "aircon/living/power_saving", "off")
+	mqtt.wait(t, "aircon/living/humidify_level", "auto")
+	mqtt.wait(t, "aircon/living/fan_level", "auto")
+	mqtt.wait(t, "aircon/living/fan_modes", `["auto","low","medium","high"]`)
+	mqtt.wait(t, "light/hall/power", "off")
+	mqtt.wait(t, "light/hall/brightness", "80")
+}
+
+func TestBridgeCommand(t *testing.T) {
+	mqtt, _, sim := startBridge(t)
+	mqtt.wait(t, "aircon/living/mode", "off")
+
+	mqtt.recv <- [2]string{"aircon/living/mode/set", "heat"}
+	mqtt.wait(t, "aircon/living/mode", "heat")
+	if edt := sim.Get(0x013001, echonet.EPC_MODE); edt[0] != 0x43 {
+		t.Errorf("mode %x expect 43", edt)
+	}
+
+	// invalid commands are ignored
+	mqtt.recv <- [2]string{"aircon/living/temperature/set", "hot"}
+	mqtt.recv <- [2]string{"aircon/nowhere/mode/set", "cool"}
+	mqtt.recv <- [2]string{"aircon/living/temperature/set", "22"}
+	mqtt.wait(t, "aircon/living/temperature", "22")
+
+	mqtt.recv <- [2]string{"aircon/living/fan_level/set", "2"}
+	mqtt.wait(t, "aircon/living/fan_level", "2")
+	mqtt.wait(t, "aircon/living/fan", "low")
+
+	mqtt.recv <- [2]string{"aircon/living/swing/set", "both"}
+	mqtt.wait(t, "aircon/living/swing", "both")
+
+	mqtt.recv <- [2]string{"aircon/living/power_saving/set", "on"}
+	mqtt.wait(t, "aircon/living/power_saving", "on")
+	if edt := sim.Get(0x013001, echonet.EPC_POWER_SAVE); edt[0] != 0x41 {
+		t.Errorf("power saving %x expect 41", edt)
+	}
+}
+
+func TestBridgeAnnouncement(t *testing.T) {
+	mqtt, _, sim := startBridge(t)
+	mqtt.wait(t, "light/hall/power", "off")
+
+	// operated on the device itself
+	sim.Set(0x029001, echonet.EPC_POWER, echonet.EDT_ON)
+	mqtt.wait(t, "light/hall/power", "on")
+}
@@ -0,0 +1,277 @@
+package echonet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// propertyCodec converts the EDT of a property from and to its value,
+// like MRAProperty.
+type propertyCodec interface {
+	Decode(edt []byte) (string, bool)
+	Encode(value string) ([]byte, error)
+}
+
+// AirconProperty is a property of the aircon beside the basic state,
+// read when the device has it and published by key. Numbers are in
+// the unit of the state, e.g. °C, and energy in kWh.
+type AirconProperty struct {
+	Key    string
+	EPC    byte
+	Set    bool // settable
+	Sensor bool // a measurement
+	name   string
+	codec  propertyCodec
+}
+
+var on_off_41 = enumCodec{0x41: "on", 0x42: "off"}
+
+// optional properties of the home air conditioner (0x0130)
+var aircon_props = []AirconProperty{
+	{Key: "power_saving", EPC: EPC_POWER_SAVE, Set: true, name: "PowerSaving", codec: on_off_41},
+	{Key: "energy", EPC: EPC_WATT_INTEGRATE, Sensor: true, name: "Energy", codec: numberCodec(ENERGY_KWH)}, // kWh
+	{Key: "fault", EPC: EPC_FAULT, name: "Fault", codec: enumCodec{0x41: "yes", 0x42: "no"}},
+	{Key: "fault_code", EPC: EPC_ERROR_CODE, name: "FaultCode", codec: hexCodec{}},
+	{Key: "on_timer", EPC: EPC_ON_TIMER, Set: true, name: "OnTimer", codec: on_off_41},
+	{Key: "on_timer_time", EPC: EPC_ON_TIMER_TIME, Set: true, name: "OnTimerTime", codec: timeCodec{}},
+	{Key: "on_timer_remaining", EPC: EPC_ON_TIMER_REL, Set: true, name: "OnTimerRelativeTime", codec: timeCodec{}},
+	{Key: "off_timer", EPC: EPC_OFF_TIMER, Set: true, name: "OffTimer", codec: on_off_41},
+	{Key: "off_timer_time", EPC: EPC_OFF_TIMER_TIME, Set: true, name: "OffTimerTime", codec: timeCodec{}},
+	{Key: "off_timer_remaining", EPC: EPC_OFF_TIMER_REL, Set: true, name: "OffTimerRelativeTime", codec: timeCodec{}},
+	{Key: "auto_direction", EPC: EPC_AUTO_DIRECTION, Set: true, name: "AutoDirection", codec: enumCodec(aircon_auto_directions)},
+	{Key: "vertical_direction", EPC: EPC_DIRECTION_V, Set: true, name: "AirFlowVertical", codec: enumCodec(aircon_directions)},
+	{Key: "horizontal_direction", EPC: EPC_DIRECTION_H, Set: true, name: "AirFlowHorizontal", codec: directionCodec{}},
+	{Key: "special_state", EPC: EPC_SPECIAL_STATE, name: "SpecialState",
+		codec: enumCodec{0x40: "normal", 0x41: "defrosting", 0x42: "preheating", 0x43: "heat_removal"}},
+	{Key: "non_priority", EPC: EPC_NON_PRIORITY, name: "NonPriorityState", codec: enumCodec{0x40: "off", 0x41: "on"}},
+	{Key: "auto_temperature", EPC: EPC_AUTO_TEMP, Set: true, name: "AutoTempControl", codec: enumCodec{0x41: "auto", 0x42: "manual"}},
+	{Key: "speed", EPC: EPC_SPEED, Set: true, name: "OperationSpeed", codec: enumCodec{0x41: "normal", 0x42: "high", 0x43: "silent"}},
+	{Key: "cool_temperature", EPC: EPC_COOL_TEMP, Set: true, name: "CoolTemp", codec: numberCodec(TARGET_TEMPERATURE)},
+	{Key: "heat_temperature", EPC: EPC_HEAT_TEMP, Set: true, name: "HeatTemp", codec: numberCodec(TARGET_TEMPERATURE)},
+	{Key: "dry_temperature", EPC: EPC_DRY_TEMP, Set: true, name: "DryTemp", codec: numberCodec(TARGET_TEMPERATURE)},
+	{Key: "ventilation", EPC: EPC_VENTILATION, Set: true, name: "Ventilation",
+		codec: enumCodec{0x41: "out", 0x42: "off", 0x43: "in", 0x44: "both"}},
+	{Key: "ventilation_level", EPC: EPC_VENTILATION_FAN, Set: true, name: "VentilationAirFlow", codec: levelCodec{}},
+	{Key: "humidify", EPC: EPC_HUMIDIFY, Set: true, name: "Humidify", codec: on_off_41},
+	{Key: "humidify_level", EPC: EPC_HUMIDIFY_LEVEL, Set: true, name: "HumidifyLevel", codec: levelCodec{}},
+	{Key: "air_purify", EPC: EPC_AIR_PURIFY, Set: true, name: "AirPurifier", codec: on_off_41},
+}
+
+func init() {
+	for _, p := range aircon_props {
+		if _, ok := lookupProperty(0x013000, p.EPC); !ok {
+			property_info[0x0130][p.EPC] = propertyInfo{p.name, p.codec}
+		}
+	}
+}
+
+// AirconProperties returns the optional properties of the aircon.
+func AirconProperties() []AirconProperty {
+	return aircon_props
+}
+
+func airconProperty(key string) *AirconProperty {
+	for i := range aircon_props {
+		if aircon_props[i].Key == key {
+			return &aircon_props[i]
+		}
+	}
+	return nil
+}
+
+func isAirconProperty(epc byte) bool {
+	for _, p := range aircon_props {
+		if p.EPC == epc {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAirconProperties returns the optional properties received, by
+// key; the value is empty if it cannot be decoded.
+func (obj *EchonetObject) GetAirconProperties() map[string]string {
+	props := obj.GetProperties()
+	st := map[string]string{}
+	for _, p := range aircon_props {
+		if edt, ok := props[p.EPC]; ok {
+			st[p.Key], _ = p.codec.Decode(edt)
+		}
+	}
+	return st
+}
+
+// SetAirconProperty sets the optional property of the key.
+func (obj *EchonetObject) SetAirconProperty(key, value string) error {
+	p := airconProperty(key)
+	if p == nil || !p.Set {
+		return &ValueError{"property", key}
+	}
+	edt, err := p.codec.Encode(value)
+	if err != nil {
+		return &ValueError{key, value}
+	}
+	return obj.SetProperty(p.EPC, edt)
+}
+
+// addAirconProperties adds the optional properties in the get map.
+func (obj *EchonetObject) addAirconProperties(pkt *EchonetPacket) {
+	_, _, get := obj.PropertyMap()
+	for _, epc := range get {
+		if isAirconProperty(epc) {
+			pkt.AddProperty(epc)
+		}
+	}
+}
+
+// optionalState gets the optional properties, once the get map is
+// known.
+func (obj *EchonetObject) optionalState() error {
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(obj.eoj)
+	pkt.SetEsv(ESV_GET)
+	obj.addAirconProperties(pkt)
+	if len(pkt.Props) == 0 {
+		return nil
+	}
+	return obj.sendPacket(pkt)
+}
+
+type enumCodec Enum
+
+func (c enumCodec) Decode(edt []byte) (string, bool) {
+	return Enum(c).Decode(edt)
+}
+
+func (c enumCodec) Encode(value string) ([]byte, error) {
+	code, ok := Enum(c).Encode(value)
+	if !ok {
+		return nil, fmt.Errorf("unknown value: %s", value)
+	}
+	return []byte{code}, nil
+}
+
+type numberCodec NumberType
+
+func (c numberCodec) Decode(edt []byte) (string, bool) {
+	n := NumberType(c).Decode(edt)
+	return n.String(), n.Valid()
+}
+
+func (c numberCodec) Encode(value string) ([]byte, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, err
+	}
+	return NumberType(c).Encode(v)
+}
+
+// levelCodec is auto (0x41) or the level 1 to 8 (0x31-0x38).
+type levelCodec struct{}
+
+func (levelCodec) Decode(edt []byte) (string, bool) {
+	if len(edt) != 1 {
+		return "", false
+	}
+	switch b := edt[0]; {
+	case b == EDT_AUTO:
+		return "auto", true
+	case b >= 0x31 && b <= 0x38:
+		return strconv.Itoa(int(b - 0x30)), true
+	}
+	return "", false
+}
+
+func (levelCodec) Encode(value string) ([]byte, error) {
+	if value == "auto" {
+		return []byte{EDT_AUTO}, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < 1 || v > 8 {
+		return nil, fmt.Errorf("invalid level: %s", value)
+	}
+	return []byte{byte(0x30 + v)}, nil
+}
+
+// timeCodec is HH:MM.
+type timeCodec struct{}
+
+func (timeCodec) Decode(edt []byte) (string, bool) {
+	if len(edt) != 2 {
+		return "", false
+	}
+	d, ok := DecodeTime(edt)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60), true
+}
+
+func (timeCodec) Encode(value string) ([]byte, error) {
+	var h, m int
+	_, err := fmt.Sscanf(value, "%d:%d", &h, &m)
+	if err != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return nil, fmt.Errorf("invalid time: %s", value)
+	}
+	return []byte{byte(h), byte(m)}, nil
+}
+
+// hexCodec is the EDT in hex.
+type hexCodec struct{}
+
+func (hexCodec) Decode(edt []byte) (string, bool) {
+	return hex.EncodeToString(edt), true
+}
+
+func (hexCodec) Encode(value string) ([]byte, error) {
+	return hex.DecodeString(value)
+}
+
+// directionCodec is the horizontal air flow direction: the positions
+// l, lc, c, rc and r joined by "-", e.g. "lc-c-rc". The codes 0x51 to
+// 0x6f are a bitmap of them, 0x41 to 0x44 a few combinations.
+type directionCodec struct{}
+
+var direction_positions = []string{"l", "lc", "c", "rc", "r"}
+
+var direction_codes = map[byte]byte{0x41: 0x03, 0x42: 0x18, 0x43: 0x0e, 0x44: 0x1b}
+
+func (directionCodec) Decode(edt []byte) (string, bool) {
+	if len(edt) != 1 {
+		return "", false
+	}
+	bits, ok := direction_codes[edt[0]]
+	if !ok {
+		if edt[0] < 0x51 || edt[0] > 0x6f {
+			return "", false
+		}
+		bits = edt[0] - 0x50
+	}
+	var pos []string
+	for i, p := range direction_positions {
+		if bits&(0x10>>i) != 0 {
+			pos = append(pos, p)
+		}
+	}
+	return strings.Join(pos, "-"), true
+}
+
+func (directionCodec) Encode(value string) ([]byte, error) {
+	var bits byte
+	for _, s := range strings.Split(value, "-") {
+		i := -1
+		for j, p := range direction_positions {
+			if s == p {
+				i = j
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("invalid direction: %s", value)
+		}
+		bits |= 0x10 >> i
+	}
+	return []byte{0x50 + bits}, nil
+}
@@ -0,0 +1,198 @@
+package echonet
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestAirconCodecs(t *testing.T) {
+	for _, tc := range []struct {
+		key   string
+		edt   []byte
+		value string
+	}{
+		{"power_saving", []byte{0x41}, "on"},
+		{"on_timer_time", []byte{7, 30}, "07:30"},
+		{"off_timer_remaining", []byte{0, 5}, "00:05"},
+		{"vertical_direction", []byte{0x45}, "lower"},
+		{"horizontal_direction", []byte{0x5e}, "lc-c-rc"},
+		{"horizontal_direction", []byte{0x60}, "l"},
+		{"cool_temperature", []byte{26}, "26"},
+		{"ventilation_level", []byte{0x33}, "3"},
+		{"ventilation_level", []byte{0x41}, "auto"},
+		{"air_purify", []byte{0x42}, "off"},
+		{"energy", []byte{0, 0, 0x30, 0x39}, "12.345"}, // kWh
+	} {
+		p := airconProperty(tc.key)
+		v, ok := p.codec.Decode(tc.edt)
+		if !ok || v != tc.value {
+			t.Errorf("%s % x: %q %v expect %q", tc.key, tc.edt, v, ok, tc.value)
+		}
+		edt, err := p.codec.Encode(tc.value)
+		if err != nil || !bytes.Equal(edt, tc.edt) {
+			t.Errorf("%s %s: % x %v expect % x", tc.key, tc.value, edt, err, tc.edt)
+		}
+	}
+
+	// the combinations decode to the positions
+	if v, _ := airconProperty("horizontal_direction").codec.Decode([]byte{0x41}); v != "rc-r" {
+		t.Errorf("horizontal 41: %s expect rc-r", v)
+	}
+	for key, value := range map[string]string{
+		"on_timer_time":        "24:00",
+		"horizontal_direction": "up",
+		"ventilation_level":    "9",
+		"cool_temperature":     "300",
+	} {
+		if _, err := airconProperty(key).codec.Encode(value); err == nil {
+			t.Errorf("%s %s: no error", key, value)
+		}
+	}
+	if name := PropertyName(0x013001, EPC_DIRECTION_V); name != "AirFlowVertical" {
+		t.Errorf("name %s expect AirFlowVertical", name)
+	}
+}
+
+func TestAirconProperties(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read after the property maps
+	if err := obj.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50 && len(obj.GetAirconProperties()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	props := obj.GetAirconProperties()
+	if props["vertical_direction"] != "center" || props["off_timer"] != "off" {
+		t.Errorf("properties %v", props)
+	}
+	if _, ok := props["ventilation"]; ok {
+		t.Error("ventilation not in the get map")
+	}
+
+	if err := obj.SetAirconProperty("vertical_direction", "top"); err != nil {
+		t.Fatal(err)
+	}
+	if edt := sim.Get(0x013001, EPC_DIRECTION_V); edt[0] != 0x41 {
+		t.Errorf("vertical direction %x expect 41", edt)
+	}
+	if err := obj.SetAirconProperty("special_state", "normal"); err == nil {
+		t.Error("special state set")
+	}
+	if err := obj.SetAirconProperty("off_timer_time", "noon"); err == nil {
+		t.Error("invalid time set")
+	}
+}
+
+func TestAirconSwing(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := obj.SwingModes(); len(m) != 4 {
+		t.Errorf("swing modes %v before the property maps", m)
+	}
+	if err := obj.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if m := obj.SwingModes(); !slices.Contains(m, "auto") ||
+		!slices.Contains(m, "lower") {
+		t.Errorf("swing modes %v", m)
+	}
+
+	for _, tc := range []struct {
+		mode, expect string
+		epc, edt     byte
+	}{
+		{"ud", "vertical", EPC_SWING, 0x41},
+		{"both", "both", EPC_SWING, 0x43},
+		{"auto", "auto", EPC_AUTO_DIRECTION, 0x41},
+		{"lower", "lower", EPC_DIRECTION_V, 0x45},
+		{"off", "off", EPC_SWING, EDT_OFF}, // the direction stays in vertical_direction
+	} {
+		if err := obj.SetSwing(tc.mode); err != nil {
+			t.Fatalf("%s: %s", tc.mode, err)
+		}
+		if edt := sim.Get(0x013001, tc.epc); edt[0] != tc.edt {
+			t.Errorf("%s: %02x=%x expect %x", tc.mode, tc.epc, edt, tc.edt)
+		}
+		if err := obj.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if m := obj.GetSwing(); m != tc.expect {
+			t.Errorf("%s: swing %s expect %s", tc.mode, m, tc.expect)
+		}
+	}
+	if edt := sim.Get(0x013001, EPC_FAN); edt[0] != EDT_AUTO {
+		t.Errorf("fan %x changed by the swing", edt)
+	}
+	if err := obj.SetSwing("sideways"); err == nil {
+		t.Error("invalid swing set")
+	}
+}
+
+func TestAirconFan(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	labeled, err := en.NewObject(Config{Type: "aircon", Name: "bedroom",
+		Addr: "10.0.0.2", Eoj: "013001", FanLevels: 5,
+		FanLabels: []string{"silent", "low", "mid", "high", "turbo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		obj          *EchonetObject
+		mode, expect string
+		edt          byte
+	}{
+		{obj, "high", "high", 0x38},
+		{obj, "medium", "medium", 0x34},
+		{obj, "3", "medium", 0x33},
+		{obj, "2", "low", 0x32},
+		{obj, "auto", "auto", EDT_AUTO},
+		{labeled, "turbo", "turbo", 0x35},
+		{labeled, "high", "high", 0x34}, // the label
+		{labeled, "medium", "mid", 0x33},
+		{labeled, "1", "silent", 0x31},
+	} {
+		if err := tc.obj.SetFan(tc.mode); err != nil {
+			t.Fatalf("%s: %s", tc.mode, err)
+		}
+		if edt := sim.Get(0x013001, EPC_FAN); edt[0] != tc.edt {
+			t.Errorf("%s: fan %x expect %x", tc.mode, edt, tc.edt)
+		}
+		if err := tc.obj.Refresh(); err != nil {
+			t.Fatal(err)
+		}
+		if m := tc.obj.GetFan(); m != tc.expect {
+			t.Errorf("%s: fan %s expect %s", tc.mode, m, tc.expect)
+		}
+		if l := tc.obj.GetFanLevel(); l != int(tc.edt)-0x30 && tc.edt != EDT_AUTO {
+			t.Errorf("%s: fan level %d", tc.mode, l)
+		}
+	}
+
+	for _, mode := range []string{"6", "0", "max"} {
+		if err := labeled.SetFan(mode); err == nil {
+			t.Errorf("%s: no error", mode)
+		}
+	}
+	if m := labeled.FanModes(); len(m) != 6 || m[0] != "auto" || m[5] != "turbo" {
+		t.Errorf("fan modes %v", m)
+	}
+}
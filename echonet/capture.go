@@ -0,0 +1,416 @@
+package echonet
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"time"
+)
+
+// RecordedFrame is a UDP datagram of a recording or a capture file.
+type RecordedFrame struct {
+	Time     time.Time
+	Outbound bool // sent by the recording node, if known
+	Src      *net.UDPAddr
+	Dst      *net.UDPAddr
+	Data     []byte
+}
+
+// link types of the capture files
+const (
+	LINKTYPE_NULL      = 0
+	LINKTYPE_ETHERNET  = 1
+	LINKTYPE_RAW       = 101
+	LINKTYPE_LINUX_SLL = 113
+	LINKTYPE_SLL2      = 276
+	DLT_RAW            = 12 // LINKTYPE_RAW on some systems
+	DLT_RAW_OPENBSD    = 14
+)
+
+var ErrCaptureFormat = errors.New("not a pcap or pcapng file")
+
+// ReadCapture reads the UDP datagrams of a pcap or pcapng file and calls
+// fn for each. Other packets are skipped.
+func ReadCapture(r io.Reader, fn func(f RecordedFrame) error) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return ErrCaptureFormat
+	}
+	switch binary.BigEndian.Uint32(magic) {
+	case 0xa1b2c3d4, 0xd4c3b2a1, 0xa1b23c4d, 0x4d3cb2a1:
+		return readPcap(br, fn)
+	case 0x0a0d0d0a:
+		return readPcapng(br, fn)
+	}
+	return ErrCaptureFormat
+}
+
+func readPcap(r io.Reader, fn func(f RecordedFrame) error) error {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if hdr[0] == 0xa1 {
+		order = binary.BigEndian
+	}
+	nano := order.Uint32(hdr) == 0xa1b23c4d
+	linktype := int(order.Uint32(hdr[20:]) & 0xffff)
+
+	rec := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(r, rec); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		sec, frac := int64(order.Uint32(rec)), int64(order.Uint32(rec[4:]))
+		if !nano {
+			frac *= 1000
+		}
+		caplen := order.Uint32(rec[8:])
+		if caplen > 1<<20 {
+			return fmt.Errorf("invalid record length %d", caplen)
+		}
+		data := make([]byte, caplen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		f := RecordedFrame{Time: time.Unix(sec, frac)}
+		if err := emitFrame(f, linktype, data, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// pcapng interface of the section
+type pcapngInterface struct {
+	linktype int
+	tsresol  float64 // seconds per timestamp unit
+}
+
+func readPcapng(r io.Reader, fn func(f RecordedFrame) error) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var ifaces []pcapngInterface
+	hdr := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		btype := binary.LittleEndian.Uint32(hdr)
+		if btype == 0x0a0d0d0a { // section header, byte order follows
+			var bom [4]byte
+			if _, err := io.ReadFull(r, bom[:]); err != nil {
+				return err
+			}
+			order = binary.LittleEndian
+			if bom == [4]byte{0x1a, 0x2b, 0x3c, 0x4d} {
+				order = binary.BigEndian
+			}
+			ifaces = nil
+			blen := order.Uint32(hdr[4:])
+			if blen < 16 || blen > 1<<20 {
+				return fmt.Errorf("invalid block length %d", blen)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(blen)-12); err != nil {
+				return err
+			}
+			continue
+		}
+		btype = order.Uint32(hdr)
+		blen := order.Uint32(hdr[4:])
+		if blen < 12 || blen > 1<<20 {
+			return fmt.Errorf("invalid block length %d", blen)
+		}
+		body := make([]byte, blen-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		body = body[:len(body)-4] // trailing length
+
+		switch btype {
+		case 1: // interface description
+			if len(body) < 8 {
+				return errors.New("short interface block")
+			}
+			iface := pcapngInterface{
+				linktype: int(order.Uint16(body)),
+				tsresol:  1e-6,
+			}
+			for opts := body[8:]; len(opts) >= 4; {
+				code, olen := order.Uint16(opts), int(order.Uint16(opts[2:]))
+				if code == 0 || 4+olen > len(opts) {
+					break
+				}
+				if code == 9 && olen == 1 { // if_tsresol
+					v := opts[4]
+					if v&0x80 != 0 {
+						iface.tsresol = math.Pow(2, -float64(v&0x7f))
+					} else {
+						iface.tsresol = math.Pow(10, -float64(v))
+					}
+				}
+				opts = opts[min(4+(olen+3)&^3, len(opts)):]
+			}
+			ifaces = append(ifaces, iface)
+
+		case 6: // enhanced packet
+			if len(body) < 20 {
+				return errors.New("short packet block")
+			}
+			id := order.Uint32(body)
+			caplen := int64(order.Uint32(body[12:]))
+			if int64(id) >= int64(len(ifaces)) || caplen > int64(len(body)-20) {
+				return errors.New("invalid packet block")
+			}
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			f := RecordedFrame{Time: pcapngTime(ts, ifaces[id].tsresol)}
+			opts := body[min(20+int(caplen+3)&^3, len(body)):]
+			for len(opts) >= 4 {
+				code, olen := order.Uint16(opts), int(order.Uint16(opts[2:]))
+				if code == 0 || 4+olen > len(opts) {
+					break
+				}
+				if code == 2 && olen == 4 { // epb_flags, direction
+					f.Outbound = order.Uint32(opts[4:])&3 == 2
+				}
+				opts = opts[min(4+(olen+3)&^3, len(opts)):]
+			}
+			err := emitFrame(f, ifaces[id].linktype, body[20:20+caplen], fn)
+			if err != nil {
+				return err
+			}
+
+		case 3: // simple packet, of the first interface without time
+			if len(body) < 4 || len(ifaces) == 0 {
+				return errors.New("invalid simple packet block")
+			}
+			err := emitFrame(RecordedFrame{}, ifaces[0].linktype, body[4:], fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func pcapngTime(ts uint64, resol float64) time.Time {
+	units := uint64(math.Round(1 / resol)) // per second
+	if units == 0 {
+		return time.Time{}
+	}
+	sec := ts / units
+	nsec := float64(ts%units) * resol * 1e9
+	return time.Unix(int64(sec), int64(nsec))
+}
+
+// emitFrame calls fn for the UDP datagram in the link layer frame.
+func emitFrame(f RecordedFrame, linktype int, data []byte,
+	fn func(f RecordedFrame) error) error {
+
+	var ethertype uint16
+	switch linktype {
+	case LINKTYPE_ETHERNET:
+		if len(data) < 14 {
+			return nil
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[12:]), data[14:]
+		for ethertype == 0x8100 || ethertype == 0x88a8 { // VLAN tags
+			if len(data) < 4 {
+				return nil
+			}
+			ethertype, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+	case LINKTYPE_LINUX_SLL:
+		if len(data) < 16 {
+			return nil
+		}
+		ethertype, data = binary.BigEndian.Uint16(data[14:]), data[16:]
+	case LINKTYPE_SLL2:
+		if len(data) < 20 {
+			return nil
+		}
+		ethertype, data = binary.BigEndian.Uint16(data), data[20:]
+	case LINKTYPE_NULL:
+		if len(data) < 4 {
+			return nil
+		}
+		data = data[4:] // address family in host byte order
+	case LINKTYPE_RAW, DLT_RAW, DLT_RAW_OPENBSD:
+	default:
+		return nil
+	}
+	if len(data) == 0 || (ethertype != 0 && ethertype != 0x0800 &&
+		ethertype != 0x86dd) {
+		return nil
+	}
+
+	var src, dst net.IP
+	var proto byte
+	switch data[0] >> 4 {
+	case 4:
+		ihl := int(data[0]&0x0f) * 4
+		if len(data) < 20 || ihl < 20 || len(data) < ihl {
+			return nil
+		}
+		if binary.BigEndian.Uint16(data[6:])&0x1fff != 0 {
+			return nil // not the first fragment
+		}
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if total >= ihl && total < len(data) {
+			data = data[:total] // Ethernet padding
+		}
+		proto = data[9]
+		src, dst = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[ihl:]
+	case 6:
+		if len(data) < 40 {
+			return nil
+		}
+		proto = data[6]
+		src, dst = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40:]
+		// hop-by-hop, routing and destination options
+		for (proto == 0 || proto == 43 || proto == 60) && len(data) >= 8 {
+			n := (int(data[1]) + 1) * 8
+			if n > len(data) {
+				return nil
+			}
+			proto, data = data[0], data[n:]
+		}
+	default:
+		return nil
+	}
+	if proto != 17 || len(data) < 8 {
+		return nil
+	}
+	ulen := int(binary.BigEndian.Uint16(data[4:]))
+	payload := data[8:]
+	if ulen >= 8 && ulen-8 < len(payload) {
+		payload = payload[:ulen-8]
+	}
+	f.Src = &net.UDPAddr{IP: slices.Clone(src), Port: int(binary.BigEndian.Uint16(data))}
+	f.Dst = &net.UDPAddr{IP: slices.Clone(dst), Port: int(binary.BigEndian.Uint16(data[2:]))}
+	f.Data = slices.Clone(payload)
+	return fn(f)
+}
+
+// PcapngWriter writes frames to a pcapng file as raw IP packets, each
+// section started by NewPcapngWriter. Sections may be appended to a file.
+type PcapngWriter struct {
+	w io.Writer
+}
+
+// NewPcapngWriter writes the section header and the interface.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	pw := &PcapngWriter{w: w}
+	shb := []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0}
+	shb = binary.LittleEndian.AppendUint64(shb, math.MaxUint64) // length unknown
+	if err := pw.block(0x0a0d0d0a, shb); err != nil {
+		return nil, err
+	}
+	// raw IP, if_tsresol 10^-9
+	idb := []byte{LINKTYPE_RAW, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0}
+	if err := pw.block(1, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *PcapngWriter) block(btype uint32, body []byte) error {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	le := binary.LittleEndian
+	b := le.AppendUint32(nil, btype)
+	b = le.AppendUint32(b, uint32(12+len(body)))
+	b = append(b, body...)
+	b = le.AppendUint32(b, uint32(12+len(body)))
+	_, err := pw.w.Write(b)
+	return err
+}
+
+// Write writes the frame as an enhanced packet block.
+func (pw *PcapngWriter) Write(f RecordedFrame) error {
+	ip := ipPacket(f.Src, f.Dst, f.Data)
+	le := binary.LittleEndian
+	ts := uint64(f.Time.UnixNano())
+	epb := make([]byte, 20, 32+len(ip))
+	le.PutUint32(epb[4:], uint32(ts>>32))
+	le.PutUint32(epb[8:], uint32(ts))
+	le.PutUint32(epb[12:], uint32(len(ip)))
+	le.PutUint32(epb[16:], uint32(len(ip)))
+	epb = append(epb, ip...)
+	for len(epb)%4 != 0 {
+		epb = append(epb, 0)
+	}
+	flags := uint32(1) // inbound
+	if f.Outbound {
+		flags = 2
+	}
+	epb = append(epb, 2, 0, 4, 0) // epb_flags
+	epb = le.AppendUint32(epb, flags)
+	epb = append(epb, 0, 0, 0, 0) // opt_endofopt
+	return pw.block(6, epb)
+}
+
+// ipPacket returns the IPv4 or IPv6 packet of the UDP datagram.
+func ipPacket(src, dst *net.UDPAddr, payload []byte) []byte {
+	be := binary.BigEndian
+	udp := make([]byte, 8, 8+len(payload))
+	be.PutUint16(udp, uint16(src.Port))
+	be.PutUint16(udp[2:], uint16(dst.Port))
+	be.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	if src4, dst4 := src.IP.To4(), dst.IP.To4(); src4 != nil && dst4 != nil {
+		ip := make([]byte, 20, 20+len(udp))
+		ip[0] = 0x45
+		be.PutUint16(ip[2:], uint16(20+len(udp)))
+		ip[8], ip[9] = 64, 17
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		be.PutUint16(ip[10:], ^checksum(0, ip))
+		return append(ip, udp...) // UDP checksum optional
+	}
+
+	ip := make([]byte, 40, 40+len(udp))
+	ip[0] = 0x60
+	be.PutUint16(ip[4:], uint16(len(udp)))
+	ip[6], ip[7] = 17, 64
+	copy(ip[8:], src.IP.To16())
+	copy(ip[24:], dst.IP.To16())
+	// pseudo header: addresses, length and next header
+	sum := checksum(0, ip[8:40])
+	sum = checksum(sum, []byte{0, 0, byte(len(udp) >> 8), byte(len(udp)), 0, 0, 0, 17})
+	if c := ^checksum(sum, udp); c != 0 {
+		be.PutUint16(udp[6:], c)
+	} else {
+		be.PutUint16(udp[6:], 0xffff)
+	}
+	return append(ip, udp...)
+}
+
+// checksum adds the data to the ones' complement sum.
+func checksum(sum uint16, b []byte) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xffff {
+		s = s&0xffff + s>>16
+	}
+	return uint16(s)
+}
@@ -0,0 +1,226 @@
+package echonet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ipv4UDP returns the IPv4 packet of the UDP datagram, checksums unset.
+func ipv4UDP(src, dst string, sport, dport int, payload []byte) []byte {
+	b := make([]byte, 28, 28+len(payload))
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], uint16(28+len(payload)))
+	b[8], b[9] = 64, 17
+	copy(b[12:], net.ParseIP(src).To4())
+	copy(b[16:], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(b[20:], uint16(sport))
+	binary.BigEndian.PutUint16(b[22:], uint16(dport))
+	binary.BigEndian.PutUint16(b[24:], uint16(8+len(payload)))
+	return append(b, payload...)
+}
+
+// ipv6UDP returns the IPv6 packet of the UDP datagram.
+func ipv6UDP(src, dst string, sport, dport int, payload []byte) []byte {
+	b := make([]byte, 48, 48+len(payload))
+	b[0] = 0x60
+	binary.BigEndian.PutUint16(b[4:], uint16(8+len(payload)))
+	b[6], b[7] = 17, 1
+	copy(b[8:], net.ParseIP(src))
+	copy(b[24:], net.ParseIP(dst))
+	binary.BigEndian.PutUint16(b[40:], uint16(sport))
+	binary.BigEndian.PutUint16(b[42:], uint16(dport))
+	binary.BigEndian.PutUint16(b[44:], uint16(8+len(payload)))
+	return append(b, payload...)
+}
+
+func testFrame() []byte {
+	pkt := NewEchonetPacket()
+	pkt.SetTid(7)
+	pkt.SetSeoj(0x013001)
+	pkt.SetDeoj(ECHONET_EOJ_NODE)
+	pkt.SetEsv(ESV_INF)
+	pkt.AddProperty(EPC_MODE, 0x43)
+	return pkt.Bytes()
+}
+
+func TestReadPcap(t *testing.T) {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	hdr := make([]byte, 24)
+	le.PutUint32(hdr, 0xa1b2c3d4)
+	le.PutUint16(hdr[4:], 2)
+	le.PutUint16(hdr[6:], 4)
+	le.PutUint32(hdr[16:], 65535)
+	le.PutUint32(hdr[20:], LINKTYPE_ETHERNET)
+	buf.Write(hdr)
+
+	ip := ipv4UDP("192.168.1.20", "224.0.23.0", 3610, 3610, testFrame())
+	eth := append(make([]byte, 12), 0x08, 0x00)
+	eth = append(eth, ip...)
+	eth = append(eth, 0, 0, 0, 0) // padding
+	rec := make([]byte, 16)
+	le.PutUint32(rec, 1760772600)
+	le.PutUint32(rec[4:], 250000)
+	le.PutUint32(rec[8:], uint32(len(eth)))
+	le.PutUint32(rec[12:], uint32(len(eth)))
+	buf.Write(rec)
+	buf.Write(eth)
+
+	var frames []RecordedFrame
+	err := ReadCapture(&buf, func(f RecordedFrame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("%d frames", len(frames))
+	}
+	f := frames[0]
+	if f.Src.String() != "192.168.1.20:3610" || f.Dst.String() != "224.0.23.0:3610" ||
+		!bytes.Equal(f.Data, testFrame()) ||
+		!f.Time.Equal(time.Unix(1760772600, 250000000)) {
+		t.Errorf("frame %v %v %v % x", f.Time, f.Src, f.Dst, f.Data)
+	}
+}
+
+func TestReadPcapng(t *testing.T) {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	block := func(btype uint32, body []byte) {
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+		b := make([]byte, 8, 12+len(body))
+		le.PutUint32(b, btype)
+		le.PutUint32(b[4:], uint32(12+len(body)))
+		b = append(b, body...)
+		b = le.AppendUint32(b, uint32(12+len(body)))
+		buf.Write(b)
+	}
+	shb := []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0}
+	shb = append(shb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	block(0x0a0d0d0a, shb)
+	// raw IP, if_tsresol 10^-9
+	block(1, []byte{LINKTYPE_RAW, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0})
+
+	ip := ipv6UDP("fe80::2", "ff02::1", 3610, 3610, testFrame())
+	epb := make([]byte, 20)
+	ts := uint64(1760772600)*1e9 + 5
+	le.PutUint32(epb[4:], uint32(ts>>32))
+	le.PutUint32(epb[8:], uint32(ts))
+	le.PutUint32(epb[12:], uint32(len(ip)))
+	le.PutUint32(epb[16:], uint32(len(ip)))
+	block(6, append(epb, ip...))
+	block(5, []byte{0, 0, 0, 0}) // interface statistics, skipped
+
+	var frames []RecordedFrame
+	err := ReadCapture(&buf, func(f RecordedFrame) error {
+		frames = append(frames, f)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 {
+		t.Fatalf("%d frames", len(frames))
+	}
+	f := frames[0]
+	if f.Src.String() != "[fe80::2]:3610" || f.Dst.String() != "[ff02::1]:3610" ||
+		!bytes.Equal(f.Data, testFrame()) || !f.Time.Equal(time.Unix(1760772600, 5)) {
+		t.Errorf("frame %v %v %v % x", f.Time, f.Src, f.Dst, f.Data)
+	}
+
+	if err := ReadCapture(strings.NewReader("not a capture"),
+		func(RecordedFrame) error { return nil }); err != ErrCaptureFormat {
+		t.Errorf("err %v expect %v", err, ErrCaptureFormat)
+	}
+}
+
+func TestReadPcapngCrafted(t *testing.T) {
+	le := binary.LittleEndian
+	block := func(btype uint32, body []byte) []byte {
+		b := make([]byte, 8, 12+len(body))
+		le.PutUint32(b, btype)
+		le.PutUint32(b[4:], uint32(12+len(body)))
+		b = append(b, body...)
+		return le.AppendUint32(b, uint32(12+len(body)))
+	}
+	shb := []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0}
+	shb = append(shb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	// read without panic, only the invalid length is an error
+	for name, b := range map[string][]byte{
+		// if_tsresol at the end of the block without padding
+		"option": block(1, []byte{LINKTYPE_RAW, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0, 9}),
+		// a captured length that overflows with the padding
+		"caplen": append(block(1, []byte{LINKTYPE_RAW, 0, 0, 0, 0, 0, 0, 0}),
+			block(6, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0xfd, 0xff, 0xff, 0xff, 0, 0, 0, 0})...),
+		// the packet option runs past the block without padding
+		"epb option": append(block(1, []byte{LINKTYPE_RAW, 0, 0, 0, 0, 0, 0, 0}),
+			block(6, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+				0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 1, 0, 1})...),
+	} {
+		data := append(block(0x0a0d0d0a, shb), b...)
+		err := ReadCapture(bytes.NewReader(data),
+			func(RecordedFrame) error { return nil })
+		if (err != nil) != (name == "caplen") {
+			t.Errorf("%s: error %v", name, err)
+		}
+	}
+}
+
+func TestPcapngWriter(t *testing.T) {
+	var buf bytes.Buffer
+	frames := []RecordedFrame{
+		{Time: time.Unix(1760772600, 123), Outbound: true,
+			Src:  &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3610},
+			Dst:  &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 3610},
+			Data: testFrame()},
+		{Time: time.Unix(1760772601, 0),
+			Src:  &net.UDPAddr{IP: net.ParseIP("fe80::2"), Port: 3610},
+			Dst:  &net.UDPAddr{IP: net.ParseIP("ff02::1"), Port: 3610},
+			Data: testFrame()[:13]},
+	}
+	// two sections, as appended to a file
+	for _, f := range frames {
+		pw, err := NewPcapngWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pw.Write(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var read []RecordedFrame
+	err := ReadCapture(&buf, func(f RecordedFrame) error {
+		read = append(read, f)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(frames) {
+		t.Fatalf("%d frames", len(read))
+	}
+	for i, f := range read {
+		e := frames[i]
+		if !f.Time.Equal(e.Time) || f.Outbound != e.Outbound ||
+			f.Src.String() != e.Src.String() || f.Dst.String() != e.Dst.String() ||
+			!bytes.Equal(f.Data, e.Data) {
+			t.Errorf("frame %d %+v expect %+v", i, f, e)
+		}
+	}
+
+	// the checksums of the headers
+	ip := ipPacket(frames[0].Src, frames[0].Dst, frames[0].Data)
+	if c := checksum(0, ip[:20]); c != 0xffff {
+		t.Errorf("IPv4 header checksum %04x", c)
+	}
+}
@@ -0,0 +1,234 @@
+package echonet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// propertyInfo names a property for Decode and converts its value,
+// nil codec if only the name is known.
+type propertyInfo struct {
+	name  string
+	codec propertyCodec
+}
+
+// classes known without the MRA
+var class_names = map[uint16]string{
+	0x0130: "HomeAirConditioner",
+	0x0288: "LowVoltageSmartMeter",
+	0x0290: "GeneralLighting",
+	0x0291: "MonoFunctionalLighting",
+	0x05ff: "Controller",
+	0x0ef0: "NodeProfile",
+}
+
+var on_off = Enum{EDT_ON: "on", EDT_OFF: "off"}
+
+// unitCodec is a number with the unit, which is optional to encode.
+type unitCodec struct {
+	t    NumberType
+	unit string
+}
+
+func (c unitCodec) Decode(edt []byte) (string, bool) {
+	n := c.t.Decode(edt)
+	if !n.Valid() {
+		return n.String(), n.Status != STATUS_INVALID
+	}
+	return n.String() + c.unit, true
+}
+
+func (c unitCodec) Encode(value string) ([]byte, error) {
+	return numberCodec(c.t).Encode(strings.TrimSuffix(value, c.unit))
+}
+
+// properties known without the MRA by class, 0x0000 for the super class
+var property_info = map[uint16]map[byte]propertyInfo{
+	0x0000: {
+		EPC_POWER:          {"OperationStatus", enumCodec(on_off)},
+		EPC_PLACE:          {"InstallationLocation", nil},
+		EPC_VERSION:        {"StandardVersion", nil},
+		EPC_ID:             {"Id", nil},
+		EPC_WATT:           {"Power", unitCodec{UINT16, "W"}},
+		EPC_WATT_INTEGRATE: {"Energy", unitCodec{UINT32, "Wh"}},
+		EPC_FAULT:          {"Fault", enumCodec{0x41: "yes", 0x42: "no"}},
+		EPC_MAKER:          {"Manufacturer", nil},
+		EPC_SERIAL:         {"SerialNumber", nil},
+		EPC_POWER_SAVE:     {"PowerSaving", enumCodec{0x41: "on", 0x42: "off"}},
+		EPC_INF_PROPMAP:    {"InfPropertyMap", nil},
+		EPC_SET_PROPMAP:    {"SetPropertyMap", nil},
+		EPC_GET_PROPMAP:    {"GetPropertyMap", nil},
+	},
+	0x0ef0: {
+		EPC_NODE_INS_NUM:    {"InstanceCount", unitCodec{UINT32, ""}},
+		EPC_NODE_CLASS_NUM:  {"ClassCount", unitCodec{UINT16, ""}},
+		EPC_NODE_INS_INF:    {"InstanceListNotification", nil},
+		EPC_NODE_INS_LIST:   {"InstanceList", nil},
+		EPC_NODE_CLASS_LIST: {"ClassList", nil},
+	},
+	0x0130: {
+		EPC_FAN:             {"AirFlow", levelCodec{}},
+		EPC_SWING:           {"Swing", enumCodec(aircon_swings)},
+		EPC_MODE:            {"OperationMode", enumCodec(aircon_modes)},
+		EPC_TARGET_TEMP:     {"TargetTemp", unitCodec{TARGET_TEMPERATURE, "°C"}},
+		EPC_TARGET_HUMIDITY: {"TargetHumidity", unitCodec{PERCENT, "%"}},
+		EPC_ROOM_HUMIDITY:   {"RoomHumidity", unitCodec{PERCENT, "%"}},
+		EPC_ROOM_TEMP:       {"RoomTemp", unitCodec{TEMPERATURE, "°C"}},
+		EPC_OUTDOOR_TEMP:    {"OutdoorTemp", unitCodec{TEMPERATURE, "°C"}},
+		EPC_HUMIDIFY:        {"Humidify", enumCodec{0x41: "on", 0x42: "off"}},
+		EPC_HUMIDIFY_LEVEL:  {"HumidifyLevel", levelCodec{}},
+	},
+	0x0288: {
+		EPC_METER_COEFFICIENT: {"Coefficient", unitCodec{UINT32, ""}},
+		EPC_METER_DIGITS:      {"Digits", unitCodec{UINT8, ""}},
+		EPC_METER_ENERGY:      {"Energy", unitCodec{UINT32, ""}},
+		EPC_METER_UNIT:        {"EnergyUnit", nil},
+		EPC_METER_POWER:       {"Power", unitCodec{INT32, "W"}},
+		EPC_METER_CURRENT:     {"Current", nil},
+	},
+	0x0290: {
+		EPC_BRIGHTNESS: {"Brightness", unitCodec{PERCENT, "%"}},
+		EPC_LIGHT_MODE: {"LightingMode", enumCodec{0x41: "auto", 0x42: "normal", 0x43: "night", 0x45: "color"}},
+	},
+}
+
+func init() {
+	property_info[0x0291] = property_info[0x0290]
+}
+
+// units of the MRA shown in short
+var mra_units = map[string]string{
+	"Celsius": "°C",
+}
+
+// ClassName returns the name of the class of the EOJ, from the MRA if
+// unknown, and empty if neither knows it.
+func ClassName(eoj uint32) string {
+	class := uint16(eoj >> 8)
+	if name, ok := class_names[class]; ok {
+		return name
+	}
+	if c := DefaultMRA().Class(class); c != nil {
+		return upperFirst(c.ShortName)
+	}
+	return ""
+}
+
+// PropertyName returns the name of the property of the EOJ, empty if
+// unknown.
+func PropertyName(eoj uint32, epc byte) string {
+	if info, ok := lookupProperty(eoj, epc); ok {
+		return info.name
+	}
+	if p := DefaultMRA().Property(eoj, epc); p != nil {
+		return upperFirst(p.ShortName)
+	}
+	return ""
+}
+
+func lookupProperty(eoj uint32, epc byte) (propertyInfo, bool) {
+	for _, class := range []uint16{uint16(eoj >> 8), 0x0000} {
+		if info, ok := property_info[class][epc]; ok {
+			return info, true
+		}
+	}
+	return propertyInfo{}, false
+}
+
+// EncodeProperty encodes the value of the property known without the
+// MRA, e.g. cool for the operation mode of the aircon; false if the
+// property has no codec.
+func EncodeProperty(eoj uint32, epc byte, value string) ([]byte, bool, error) {
+	info, ok := lookupProperty(eoj, epc)
+	if !ok || info.codec == nil {
+		return nil, false, nil
+	}
+	edt, err := info.codec.Encode(value)
+	return edt, true, err
+}
+
+func upperFirst(s string) string {
+	for i, r := range s {
+		return string(unicode.ToUpper(r)) + s[i+len(string(r)):]
+	}
+	return s
+}
+
+// DecodeProperty renders the property of the EOJ as Name=value, Name
+// without EDT, and the EPC and EDT in hex if unknown.
+func DecodeProperty(eoj uint32, prop EchonetProperty) string {
+	name := fmt.Sprintf("0x%02x", prop.EPC)
+	var value string
+	ok := false
+
+	switch prop.EPC {
+	case EPC_INF_PROPMAP, EPC_SET_PROPMAP, EPC_GET_PROPMAP:
+		name = PropertyName(eoj, prop.EPC)
+		var epcs []string
+		for _, epc := range getPropertyMap(prop) {
+			epcs = append(epcs, fmt.Sprintf("%02x", epc))
+		}
+		value, ok = "["+strings.Join(epcs, ",")+"]", true
+
+	default:
+		if info, found := lookupProperty(eoj, prop.EPC); found {
+			name = info.name
+			if info.codec != nil {
+				value, ok = info.codec.Decode(prop.EDT)
+			}
+		} else if p := DefaultMRA().Property(eoj, prop.EPC); p != nil {
+			name = upperFirst(p.ShortName)
+			value, ok = p.Decode(prop.EDT)
+			if ok && p.Data != nil {
+				unit := p.Data.Unit
+				if u, found := mra_units[unit]; found {
+					unit = u
+				}
+				if p.Data.Type == "number" && unit != "" {
+					value += unit
+				}
+			}
+		}
+	}
+
+	if len(prop.EDT) == 0 {
+		return name
+	}
+	if !ok {
+		value = hex.EncodeToString(prop.EDT)
+	}
+	return name + "=" + value
+}
+
+// Decode renders the packet for humans: the classes of the objects,
+// the names and the values of the properties.
+func (pkt *EchonetPacket) Decode() string {
+	object := func(eoj uint32) string {
+		if name := ClassName(eoj); name != "" {
+			return fmt.Sprintf("%s[%06x]", name, eoj)
+		}
+		return fmt.Sprintf("%06x", eoj)
+	}
+	// the properties are of the destination in requests
+	eoj := pkt.SEOJ
+	if pkt.ESV >= ESV_SETI && pkt.ESV < ESV_SET_RES {
+		eoj = pkt.DEOJ
+	}
+	props := func(props []EchonetProperty) string {
+		s := ""
+		for _, prop := range props {
+			s += " " + DecodeProperty(eoj, prop)
+		}
+		return s
+	}
+
+	s := fmt.Sprintf("%s => %s TID:%d %s", object(pkt.SEOJ), object(pkt.DEOJ),
+		pkt.TID, EsvName(pkt.ESV))
+	s += props(pkt.Props)
+	if isSetGet(pkt.ESV) {
+		s += " /" + props(pkt.GetProps)
+	}
+	return s
+}
@@ -0,0 +1,170 @@
+package echonet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	ECHONET_REDISCOVERY = 30 * time.Second // least interval of rediscovery
+)
+
+// ErrNoAddress is returned for a device not discovered yet.
+var ErrNoAddress = errors.New("address unknown")
+
+// ParseId parses an identification number (EPC 0x83) written as 34 hex
+// digits: 0xfe, the manufacturer code and a unique number.
+func ParseId(s string) ([]byte, error) {
+	id, err := hex.DecodeString(s)
+	if err != nil || len(id) != 17 {
+		return nil, fmt.Errorf("invalid id %q: must be 34 hex digits", s)
+	}
+	return id, nil
+}
+
+// rediscover asks all nodes for their identification number,
+// manufacturer code and serial number, at most once per Rediscovery.
+func (en *Echonet) rediscover() {
+	en.node_mutex.Lock()
+	if time.Since(en.discovered) < en.Rediscovery {
+		en.node_mutex.Unlock()
+		return
+	}
+	en.discovered = time.Now()
+	en.node_mutex.Unlock()
+
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(ECHONET_EOJ_NODE)
+	pkt.SetEsv(ESV_GET)
+	pkt.AddProperty(EPC_ID)
+	pkt.AddProperty(EPC_MAKER)
+	pkt.AddProperty(EPC_SERIAL) // optional, Get_SNA without it
+
+	en.Logger.Debug("discovery", "packet", pkt.Decode())
+	err := en.transport.Multicast(pkt.Bytes())
+	if err != nil {
+		en.Logger.Warn("discovery failed", "err", err)
+	}
+}
+
+// learnNode records the identification number, the manufacturer code,
+// the serial number and the MAC address of the node sending the packet,
+// and moves the objects of the node to its address.
+func (en *Echonet) learnNode(addr *net.UDPAddr, pkt *EchonetPacket) {
+	if pkt.GetSeoj()>>8 != ECHONET_EOJ_NODE>>8 {
+		return
+	}
+	switch pkt.GetEsv() {
+	case ESV_GET_RES, ESV_GET_SNA, ESV_INF, ESV_INFC:
+	default:
+		return
+	}
+	var id, maker, serial []byte
+	for _, prop := range pkt.Props {
+		if len(prop.EDT) == 0 {
+			continue
+		}
+		switch prop.EPC {
+		case EPC_ID:
+			id = prop.EDT
+		case EPC_MAKER:
+			maker = prop.EDT
+		case EPC_SERIAL:
+			serial = prop.EDT
+		}
+	}
+	if id == nil {
+		return
+	}
+
+	key := addr.IP.String()
+	en.node_mutex.Lock()
+	n := en.node(addr)
+	found := !bytes.Equal(n.id, id)
+	if found {
+		n.id = bytes.Clone(id)
+		for k, o := range en.nodes {
+			if k != key && bytes.Equal(o.id, id) {
+				o.id, o.mac = nil, nil // moved away
+			}
+		}
+	}
+	if maker != nil {
+		n.maker = bytes.Clone(maker)
+	}
+	if serial != nil {
+		n.serial = bytes.Clone(serial)
+	}
+	// not resolved by the first packet, looked up again after a while
+	lookup := n.mac == nil && time.Since(n.no_mac) >= en.Rediscovery
+	mac := n.mac
+	en.node_mutex.Unlock()
+
+	if lookup {
+		mac = lookupMac(addr.IP)
+		en.node_mutex.Lock()
+		n = en.node(addr)
+		if mac != nil {
+			n.mac = mac
+		} else {
+			n.no_mac = time.Now()
+		}
+		en.node_mutex.Unlock()
+	}
+
+	if found {
+		en.Logger.Info("node found", "addr", key,
+			"id", hex.EncodeToString(id), "maker", hex.EncodeToString(maker),
+			"serial", strings.TrimRight(string(serial), "\x00 "),
+			"mac", mac.String())
+	}
+	for _, obj := range en.List() {
+		en.resolve(obj)
+	}
+}
+
+// resolve moves the object to the address of its node, and returns
+// whether the node is known.
+func (en *Echonet) resolve(obj *EchonetObject) bool {
+	if obj.id == nil && obj.mac == nil {
+		return false
+	}
+
+	var found *node
+	en.node_mutex.Lock()
+	for _, n := range en.nodes {
+		if (obj.id != nil && bytes.Equal(n.id, obj.id)) ||
+			(obj.mac != nil && bytes.Equal(n.mac, obj.mac)) {
+			c := *n
+			found = &c
+			break
+		}
+	}
+	en.node_mutex.Unlock()
+	if found == nil {
+		return false
+	}
+
+	old := obj.addr.Load()
+	if old != nil && old.IP.Equal(found.addr.IP) {
+		return true
+	}
+	addr := *found.addr
+	if old != nil {
+		addr.Port = old.Port
+	}
+	en.attach(obj, &addr)
+	if old == nil {
+		obj.logger.Info("discovered", "addr", addr.IP.String())
+	} else {
+		obj.logger.Warn("moved", "from", old.IP.String(),
+			"to", addr.IP.String())
+	}
+	return true
+}
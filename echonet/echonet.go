@@ -4,44 +4,120 @@ ECHONET Lite
 package echonet
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"maps"
 	"net"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"golang.org/x/net/ipv4"
 )
 
 const (
-	ECHONET_PORT      = 3610         // ECHONET port number
-	ECHONET_EOJ_NODE  = 0x0ef001     // ECHONET node object code
-	ECHONET_MULTICAST = "224.0.23.0" // ECHONET multicast address
+	ECHONET_PORT       = 3610         // ECHONET port number
+	ECHONET_EOJ_NODE   = 0x0ef001     // ECHONET node object code
+	ECHONET_MULTICAST  = "224.0.23.0" // ECHONET multicast address
+	ECHONET_MULTICAST6 = "ff02::1"    // ECHONET IPv6 multicast address
 )
 
 var (
 	send_mutex sync.Mutex
 )
 
+// operation modes of the air conditioner (0xb0)
+var aircon_modes = Enum{
+	0x41: "auto",
+	0x42: "cool",
+	0x43: "heat",
+	0x44: "dry",
+	0x45: "fan",
+	0x46: "other",
+}
+
+// automatic swing of the air flow (0xa3), named as the swing modes of
+// Home Assistant
+var aircon_swings = Enum{
+	EDT_OFF: "off",
+	0x41:    "vertical",
+	0x42:    "horizontal",
+	0x43:    "both",
+}
+
+// the former swing modes
+var swing_aliases = map[string]string{
+	"ud": "vertical",
+	"lr": "horizontal",
+	"on": "both",
+}
+
+// automatic control of the air flow direction (0xa1)
+var aircon_auto_directions = Enum{
+	0x41: "auto",
+	0x42: "manual",
+	0x43: "auto_vertical",
+	0x44: "auto_horizontal",
+}
+
+// fixed vertical air flow directions (0xa4), top to bottom
+var aircon_directions = Enum{
+	0x41: "top",
+	0x44: "upper",
+	0x43: "center",
+	0x45: "lower",
+	0x42: "bottom",
+}
+
+var direction_names = []string{"top", "upper", "center", "lower", "bottom"}
+
+// ECHONET class codes handled by each object type
+var TypeClass = map[string][]uint16{
+	"aircon": {0x0130},         // home air conditioner
+	"light":  {0x0290, 0x0291}, // general / mono functional lighting
+	// any class, the properties are described by the MRA
+	"generic": nil,
+}
+
 // Echonet object
 type EchonetObject struct {
 	parent          *Echonet
-	addr            *net.UDPAddr
-	conn            net.Conn
+	addr            atomic.Pointer[net.UDPAddr] // nil until discovered
+	id              []byte                      // identification number
+	mac             net.HardwareAddr
+	state_mutex     sync.Mutex // guards the state and the maps below
 	power           bool
 	mode            string
-	target_temp     int
-	target_humidity int
-	room_temp       int
-	room_humidity   int
-	outdoor_temp    int
+	target_temp     Number
+	target_auto     bool // target temperature 0xfd, set by the device
+	target_humidity Number
+	room_temp       Number
+	room_humidity   Number
+	outdoor_temp    Number
 	fan             int
 	swing           int
-	watt            int
-	tid             uint16
+	swing_dir       bool // the last swing mode set is a fixed direction
+	watt            Number
+	brightness      Number
+	inf_map         []byte // property maps, see Property
+	set_map         []byte
+	get_map         []byte
+	props           map[byte][]byte // of generic objects, aircon options
 	eoj             uint32
 	cfg             Config
+	last_seen       atomic.Int64 // unix nano
+	logger          *slog.Logger
+}
+
+// ValueError is returned by the setters for an invalid value.
+type ValueError struct {
+	Name  string
+	Value string
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Name, e.Value)
 }
 
 // Echonet object config
@@ -50,37 +126,88 @@ type Config struct {
 	Name string `json:"name"`
 	Addr string `json:"addr"`
 	Eoj  string `json:"eoj"`
+	// The node is found by the identification number (0x83 of the
+	// node profile, the manufacturer code and a unique number) or the
+	// MAC address, and followed when its address changes. Addr is
+	// optional then.
+	Id  string `json:"id"`
+	Mac string `json:"mac"`
+	// The setters read the state back in the same SetGet request,
+	// for devices supporting it.
+	SetGet bool `json:"setget"`
+	// The fan levels of the aircon, 1 to 8 (default 8), and their
+	// names, published as the fan mode instead of low, medium and high.
+	FanLevels int      `json:"fan_levels"`
+	FanLabels []string `json:"fan_labels"`
 }
 
 // Echonet
 type Echonet struct {
-	ObjectList []*EchonetObject
-	RecvChan   chan *EchonetObject
-	mconn_send *net.UDPConn
-	mconn_recv *ipv4.PacketConn
+	ObjectList  []*EchonetObject
+	RecvChan    chan *EchonetObject
+	Timeout     time.Duration // response timeout
+	Retry       int           // number of retries on timeout
+	Logger      *slog.Logger  // packets are logged at debug level
+	Interval    time.Duration // pause after each packet sent
+	Rediscovery time.Duration // least interval of the discovery of moved nodes
+	transport   Transport
+	done        chan struct{} // closed by Close
+	list_mutex  sync.Mutex
+	listeners   []func(ev *Event)
+	pending     map[transactionKey]*transaction
+	trans_mutex sync.Mutex
+	queue       atomic.Int32  // packets waiting to be sent
+	tid         atomic.Uint32 // of the requests of all objects
+	status      Status
+	stat_mutex  sync.Mutex
+	nodes       map[string]*node // discovered nodes by IP address
+	node_mutex  sync.Mutex
+	discovered  time.Time // last discovery sent
+}
+
+// Options of the ECHONET Lite node.
+type Options struct {
+	Port int    // port to listen on, default ECHONET_PORT
+	Bind string // address to listen on, default all
+	// interface names or CIDRs to join the multicast group and send on,
+	// default the interfaces that look like a home network
+	Interfaces []string
+	Transport  Transport // overrides the UDP transport, e.g. in tests
+	Recorder   *Recorder // records the frames sent and received
 }
 
 func NewEchonet() (*Echonet, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp",
-		net.JoinHostPort(ECHONET_MULTICAST, strconv.Itoa(ECHONET_PORT)))
-	if err != nil {
-		return nil, err
-	}
+	return NewEchonetOptions(Options{})
+}
 
-	conn_send, err := net.DialUDP("udp4", nil, udpAddr)
-	if err != nil {
-		return nil, err
+func NewEchonetOptions(opts Options) (*Echonet, error) {
+	t := opts.Transport
+	if t == nil {
+		port := opts.Port
+		if port == 0 {
+			port = ECHONET_PORT
+		}
+		var err error
+		t, err = NewUDPTransport(opts.Bind, port, opts.Interfaces)
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	conn_recv, err := multicastSocket(ECHONET_MULTICAST, ECHONET_PORT)
-	if err != nil {
-		return nil, err
+	if opts.Recorder != nil {
+		t = opts.Recorder.Wrap(t)
 	}
 
 	return &Echonet{
-		RecvChan:   make(chan *EchonetObject, 32),
-		mconn_send: conn_send,
-		mconn_recv: conn_recv,
+		RecvChan:    make(chan *EchonetObject, 32),
+		Timeout:     ECHONET_TIMEOUT,
+		Retry:       ECHONET_RETRY,
+		Logger:      slog.Default().With("subsystem", "echonet"),
+		Interval:    ECHONET_INTERVAL,
+		Rediscovery: ECHONET_REDISCOVERY,
+		transport:   t,
+		pending:     map[transactionKey]*transaction{},
+		nodes:       map[string]*node{},
+		done:        make(chan struct{}),
 	}, nil
 }
 
@@ -90,76 +217,214 @@ func (en *Echonet) SendAnnounce() {
 	pkt.SetDeoj(ECHONET_EOJ_NODE)
 	pkt.SetEsv(ESV_GET)
 	pkt.AddProperty(EPC_NODE_INS_LIST) // instalce list
+	pkt.AddProperty(EPC_ID)            // identification number
 
-	_, err := en.mconn_send.Write(pkt.Bytes())
+	err := en.transport.Multicast(pkt.Bytes())
 	if err != nil {
-		panic(err)
+		en.Logger.Error("announce failed", "err", err)
 	}
 	time.Sleep(250 * time.Millisecond)
 }
 
-func (en *Echonet) receiver(conn *ipv4.PacketConn) {
-	defer conn.Close()
+func (en *Echonet) receiver() {
 
 	for {
+		en.updateStatus(func(st *Status) {
+			st.Heartbeat = time.Now()
+		})
+
 		buf := make([]byte, 1500)
-		length, cm, addr, err := conn.ReadFrom(buf)
+		length, addr, dst, err := en.transport.Recv(buf,
+			time.Now().Add(RECV_HEARTBEAT))
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			continue
+		}
+		if errors.Is(err, net.ErrClosed) {
+			en.updateStatus(func(st *Status) {
+				st.Receiving = false
+			})
+			return
+		}
 		if err != nil {
-			panic(err)
+			// e.g. of one family of the UDP transport, the other is fine
+			en.Logger.Warn("receive failed", "err", err)
+			en.updateStatus(func(st *Status) {
+				st.RecvError = err
+			})
+			continue
 		}
+		en.updateStatus(func(st *Status) {
+			st.LastRecv = time.Now()
+		})
 
+		src := addr.IP.String()
 		recv_pkt := NewEchonetPacket()
-		recv_pkt.Parse(buf[:length])
-		src, _, _ := net.SplitHostPort(addr.String())
-		log.Printf("Recv: %+v => %+v %s\n", src, cm.Dst, recv_pkt.String())
-
-		for _, obj := range en.ObjectList {
-			if obj.addr.IP.String() == src &&
-				obj.GetEoj() == recv_pkt.GetSeoj() {
-				obj.Handler(recv_pkt)
+		err = recv_pkt.Parse(buf[:length])
+		if err != nil {
+			en.Logger.Warn("invalid packet", "src", src, "dst", dst,
+				"err", err)
+			en.emit(&Event{Kind: EVENT_PARSE_ERROR, Addr: src,
+				Data: buf[:length], Err: err})
+			continue
+		}
+		en.learnNode(addr, recv_pkt)
+		en.nodeAnnounced(addr, recv_pkt)
+
+		objs, groups := en.dispatch(addr.IP, recv_pkt.GetSeoj())
+		if esv := recv_pkt.GetEsv(); esv != ESV_INF && esv != ESV_INFC {
+			// responses to the requests for all instances
+			objs = append(objs, groups...)
+		}
+
+		logger := en.Logger
+		if len(objs) > 0 {
+			logger = objs[0].logger
+		}
+		logger.Debug("recv", "src", src, "dst", dst,
+			"packet", recv_pkt.Decode())
+
+		// update the state before waking up the requests
+		for _, obj := range objs {
+			obj.Handler(recv_pkt)
+		}
+
+		if len(objs) == 0 {
+			en.emit(&Event{Kind: EVENT_RECV, Addr: src,
+				Data: buf[:length], Packet: recv_pkt})
+		}
+		// one event per object, with the round trip time of its request
+		for i, obj := range objs {
+			ev := &Event{Kind: EVENT_RECV, Addr: src,
+				Data: buf[:length], Packet: recv_pkt, Object: obj,
+				Repeated: i > 0}
+			if isResponse(recv_pkt.GetEsv()) {
+				ev.RTT, _ = en.complete(obj, recv_pkt)
 			}
+			obj.last_seen.Store(time.Now().UnixNano())
+			en.emit(ev)
 		}
 	}
 }
 
 func (en *Echonet) Start() error {
-	go en.receiver(en.mconn_recv)
-
+	en.updateStatus(func(st *Status) {
+		st.Receiving = true
+		st.Heartbeat = time.Now() // not stalled before the first loop
+	})
+	go en.receiver()
+
+	t := en.transport
+	if r, ok := t.(*recordingTransport); ok {
+		t = r.Transport
+	}
+	if t, ok := t.(*UDPTransport); ok && !t.unicast {
+		go en.watchInterfaces(t)
+	}
 	return nil
 }
 
-func (en *Echonet) NewObject(cfg Config) (*EchonetObject, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp4",
-		net.JoinHostPort(cfg.Addr, strconv.Itoa(ECHONET_PORT)))
-	if err != nil {
-		return nil, err
-	}
-
-	conn_send, err := net.DialUDP("udp4", nil, udpAddr)
-	if err != nil {
-		return nil, err
+// Close stops the receiver and closes the transport.
+func (en *Echonet) Close() error {
+	select {
+	case <-en.done:
+	default:
+		close(en.done)
 	}
+	return en.transport.Close()
+}
 
-	eoj, err := strconv.ParseUint(cfg.Eoj, 16, 24)
+func (en *Echonet) NewObject(cfg Config) (*EchonetObject, error) {
+	eoj, err := ParseEoj(cfg.Eoj)
 	if err != nil {
 		return nil, err
 	}
 
+	nodata := Number{Status: STATUS_NO_DATA} // until received
 	obj := EchonetObject{
-		parent: en,
-		addr:   udpAddr,
-		conn:   conn_send,
-		tid:    1,
-		eoj:    uint32(eoj),
-		cfg:    cfg,
+		parent:          en,
+		eoj:             uint32(eoj),
+		cfg:             cfg,
+		logger:          en.Logger.With("device", cfg.Type+"/"+cfg.Name),
+		target_temp:     nodata,
+		target_humidity: nodata,
+		room_temp:       nodata,
+		room_humidity:   nodata,
+		outdoor_temp:    nodata,
+		watt:            nodata,
+		brightness:      nodata,
 	}
+	if cfg.Id != "" {
+		obj.id, err = ParseId(cfg.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Mac != "" {
+		obj.mac, err = net.ParseMAC(cfg.Mac)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var udpAddr *net.UDPAddr
+	if cfg.Addr != "" {
+		udpAddr, err = net.ResolveUDPAddr("udp", deviceAddr(cfg.Addr))
+		if err != nil {
+			return nil, err
+		}
+	} else if obj.id == nil && obj.mac == nil {
+		return nil, errors.New("missing address")
+	}
+
+	en.list_mutex.Lock()
 	en.ObjectList = append(en.ObjectList, &obj)
+	en.list_mutex.Unlock()
 
+	if udpAddr != nil {
+		en.attach(&obj, udpAddr)
+	}
+	if !en.resolve(&obj) && obj.addr.Load() == nil {
+		en.rediscover()
+	}
 	return &obj, nil
 }
 
+// RemoveObject removes the object from the list. Packets from the
+// device are no longer dispatched to it.
+func (en *Echonet) RemoveObject(obj *EchonetObject) error {
+	en.list_mutex.Lock()
+	for i, o := range en.ObjectList {
+		if o == obj {
+			en.ObjectList = append(en.ObjectList[:i:i], en.ObjectList[i+1:]...)
+			break
+		}
+	}
+	en.list_mutex.Unlock()
+
+	en.detach(obj)
+	en.cancel(obj)
+	return nil
+}
+
+// QueueDepth returns the number of packets waiting to be sent.
+func (en *Echonet) QueueDepth() int {
+	return int(en.queue.Load())
+}
+
+// ParseEoj parses an object code written as 6 hex digits,
+// class group, class and instance (e.g. "013001").
+func ParseEoj(s string) (uint32, error) {
+	if len(s) != 6 {
+		return 0, fmt.Errorf("invalid EOJ %q: must be 6 hex digits", s)
+	}
+	eoj, err := strconv.ParseUint(s, 16, 24)
+	if err != nil {
+		return 0, fmt.Errorf("invalid EOJ %q: must be 6 hex digits", s)
+	}
+	return uint32(eoj), nil
+}
+
 func (en *Echonet) FindObject(objtype, objname string) *EchonetObject {
-	for _, obj := range en.ObjectList {
+	for _, obj := range en.List() {
 		if obj.cfg.Type == objtype && obj.cfg.Name == objname {
 			return obj
 		}
@@ -168,7 +433,7 @@ func (en *Echonet) FindObject(objtype, objname string) *EchonetObject {
 }
 
 func (en *Echonet) StateAll() error {
-	for _, obj := range en.ObjectList {
+	for _, obj := range en.List() {
 		err := obj.State()
 		if err != nil {
 			return err
@@ -177,8 +442,14 @@ func (en *Echonet) StateAll() error {
 	return nil
 }
 
+// List returns a snapshot of the object list.
 func (en *Echonet) List() []*EchonetObject {
-	return en.ObjectList
+	en.list_mutex.Lock()
+	defer en.list_mutex.Unlock()
+
+	list := make([]*EchonetObject, len(en.ObjectList))
+	copy(list, en.ObjectList)
+	return list
 }
 
 func (obj *EchonetObject) GetEoj() uint32 {
@@ -193,21 +464,145 @@ func (obj *EchonetObject) GetName() string {
 	return obj.cfg.Name
 }
 
+func (obj *EchonetObject) GetConfig() Config {
+	return obj.cfg
+}
+
+// GetAddr returns the current IP address, empty until discovered.
+func (obj *EchonetObject) GetAddr() string {
+	if a := obj.addr.Load(); a != nil {
+		return a.IP.String()
+	}
+	return ""
+}
+
 func (obj *EchonetObject) sendPacket(pkt *EchonetPacket) error {
-	pkt.SetTid(obj.tid)
-	obj.tid += 1
+	_, err := obj.send(pkt)
+	return err
+}
 
-	send_mutex.Lock()
+// request sends the packet and waits for the response.
+func (obj *EchonetObject) request(pkt *EchonetPacket) (*EchonetPacket, error) {
+	t, err := obj.send(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, nil
+	}
+	return t.wait()
+}
+
+func (obj *EchonetObject) send(pkt *EchonetPacket) (*transaction, error) {
+	pkt.SetTid(uint16(obj.parent.tid.Add(1)))
 
-	_, err := obj.conn.Write(pkt.Bytes())
+	var t *transaction
+	if needResponse(pkt.GetEsv()) {
+		t = obj.parent.begin(obj, pkt)
+	}
+	err := obj.write(pkt)
+	if t != nil {
+		if err != nil {
+			obj.parent.complete(obj, pkt) // drop the request
+			return nil, err
+		}
+		obj.parent.start(t)
+	}
+	return t, err
+}
+
+// set sends the Set request and returns the outcome reported by the
+// device in Set_Res. With SetGet configured, the state is read back in
+// the same request.
+func (obj *EchonetObject) set(pkt *EchonetPacket) error {
+	if !obj.cfg.SetGet {
+		_, err := obj.request(pkt)
+		return err
+	}
+
+	state, err := obj.statePacket()
+	if err != nil {
+		return err
+	}
+	pkt.SetEsv(ESV_SETGET)
+	_, _, get := obj.PropertyMap()
+	for _, prop := range state.Props {
+		if get == nil || slices.Contains(get, prop.EPC) {
+			pkt.AddGetProperty(prop.EPC)
+		}
+	}
+	res, err := obj.request(pkt)
+	var sna *SNAError
+	if res == nil || !errors.As(err, &sna) {
+		return err
+	}
+	// The get section only reads the state back, so only the denied
+	// properties of the set section fail the setting.
+	denied := &SNAError{Esv: sna.Esv, Eoj: sna.Eoj}
+	for _, prop := range res.Props {
+		if len(prop.EDT) > 0 {
+			denied.Epcs = append(denied.Epcs, prop.EPC)
+		}
+	}
+	if len(denied.Epcs) == 0 {
+		return nil
+	}
+	return denied
+}
+
+// SetGet sets the properties and reads the get properties back in one
+// request. The state is updated before it returns; the get section of
+// the response is returned also on SNAError.
+func (obj *EchonetObject) SetGet(set []EchonetProperty, get ...byte) ([]EchonetProperty, error) {
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(obj.eoj)
+	pkt.SetEsv(ESV_SETGET)
+	for _, prop := range set {
+		pkt.AddProperty(prop.EPC, prop.EDT...)
+	}
+	for _, epc := range get {
+		pkt.AddGetProperty(epc)
+	}
+
+	res, err := obj.request(pkt)
+	if res == nil {
+		return nil, err
+	}
+	return res.GetProps, err
+}
+
+func (obj *EchonetObject) write(pkt *EchonetPacket) error {
+	en := obj.parent
+	en.queue.Add(1)
+	send_mutex.Lock()
+	en.queue.Add(-1)
+	en.updateStatus(func(st *Status) {
+		st.SendStarted = time.Now()
+	})
+	defer func() {
+		en.updateStatus(func(st *Status) {
+			st.SendStarted = time.Time{}
+		})
+		send_mutex.Unlock()
+	}()
+
+	addr := obj.addr.Load()
+	if addr == nil {
+		en.rediscover()
+		return ErrNoAddress
+	}
+	b := pkt.Bytes()
+	err := en.transport.Send(b, addr)
 	if err != nil {
 		return fmt.Errorf("send failed: %s", err)
 	}
-	dst, _, _ := net.SplitHostPort(obj.conn.RemoteAddr().String())
-	log.Printf("Send: %s %s\n", dst, pkt.String())
-	time.Sleep(800 * time.Millisecond)
+	dst := addr.IP.String()
+	obj.logger.Debug("send", "dst", dst, "packet", pkt.Decode())
+	en.emit(&Event{Kind: EVENT_SEND, Addr: dst,
+		Data: b, Packet: pkt, Object: obj})
+	time.Sleep(en.Interval)
 
-	send_mutex.Unlock()
 	return nil
 }
 
@@ -215,16 +610,21 @@ func (obj *EchonetObject) SetPower(pow string) error {
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
-	pkt.SetEsv(ESV_SETI)
-	if pow == "on" {
+	pkt.SetEsv(ESV_SETC)
+	switch pow {
+	case "on":
 		pkt.AddProperty(EPC_POWER, EDT_ON) // power on
-	} else {
+	case "off":
 		pkt.AddProperty(EPC_POWER, EDT_OFF) // power off
+	default:
+		return &ValueError{"power", pow}
 	}
-	return obj.sendPacket(pkt)
+	return obj.set(pkt)
 }
 
 func (obj *EchonetObject) GetPower() string {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
 	if obj.power {
 		return "on"
 	}
@@ -235,7 +635,7 @@ func (obj *EchonetObject) SetMode(mode string) error {
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
-	pkt.SetEsv(ESV_SETI)
+	pkt.SetEsv(ESV_SETC)
 
 	switch mode {
 	case "off":
@@ -258,149 +658,301 @@ func (obj *EchonetObject) SetMode(mode string) error {
 		pkt.AddProperty(EPC_POWER, EDT_ON) // power on
 		pkt.AddProperty(EPC_MODE, 0x45)    // fan mode
 	default:
-		return fmt.Errorf("invalid mode: %s", mode)
+		return &ValueError{"mode", mode}
 	}
 
-	return obj.sendPacket(pkt)
+	return obj.set(pkt)
 }
 
 func (obj *EchonetObject) GetMode() (mode string) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
 	if obj.power {
 		return obj.mode
 	}
 	return "off"
 }
 
+// fan modes without labels, the lowest, middle and highest level
+var fan_presets = []string{"low", "medium", "high"}
+
+// fanLevels returns the number of fan levels of the aircon.
+func (obj *EchonetObject) fanLevels() int {
+	if n := obj.cfg.FanLevels; n > 0 {
+		return n
+	}
+	if n := len(obj.cfg.FanLabels); n > 0 {
+		return n
+	}
+	return 8
+}
+
+// presetLevels returns the levels of low, medium and high.
+func (obj *EchonetObject) presetLevels() []int {
+	n := obj.fanLevels()
+	return []int{1, (n + 1) / 2, n}
+}
+
+// SetFan sets the fan to auto, a label configured, low, medium or high,
+// or a level from 1 to the levels of the aircon.
 func (obj *EchonetObject) SetFan(mode string) error {
+	level := 0 // auto
+	if i := slices.Index(obj.cfg.FanLabels, mode); i >= 0 {
+		level = i + 1
+	} else if i := slices.Index(fan_presets, mode); i >= 0 {
+		level = obj.presetLevels()[i]
+	} else if v, err := strconv.Atoi(mode); err == nil {
+		if v < 1 || v > obj.fanLevels() {
+			return &ValueError{"fan", mode}
+		}
+		level = v
+	} else if mode != "auto" {
+		return &ValueError{"fan", mode}
+	}
+
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
-	pkt.SetEsv(ESV_SETI)
-
-	switch mode {
-	case "auto":
+	pkt.SetEsv(ESV_SETC)
+	if level == 0 {
 		pkt.AddProperty(EPC_FAN, EDT_AUTO)
-	case "low":
-		pkt.AddProperty(EPC_FAN, 0x31)
-	case "medium":
-		pkt.AddProperty(EPC_FAN, 0x33)
-	case "high":
-		pkt.AddProperty(EPC_FAN, 0x35)
-	default:
-		return fmt.Errorf("invalid mode: %s", mode)
+	} else {
+		pkt.AddProperty(EPC_FAN, byte(0x30+level))
 	}
+	return obj.set(pkt)
+}
 
-	return obj.sendPacket(pkt)
+// GetFanLevel returns the level of the fan, 1 to 8, and 0 for auto.
+func (obj *EchonetObject) GetFanLevel() int {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	if obj.fan >= 0x31 && obj.fan <= 0x38 {
+		return obj.fan - 0x30
+	}
+	return 0
 }
 
+// GetFan returns the fan mode: auto, the label of the level, or the
+// nearest of low, medium and high without labels.
 func (obj *EchonetObject) GetFan() (mode string) {
-	switch obj.fan {
-	case EDT_AUTO:
+	level := obj.GetFanLevel()
+	if level == 0 {
 		return "auto"
-	case 0x31, 0x32:
-		return "low"
-	case 0x33, 0x34:
-		return "medium"
-	case 0x35, 0x36, 0x37, 0x38:
-		return "high"
 	}
-	return "auto"
+	if labels := obj.cfg.FanLabels; len(labels) > 0 {
+		if level <= len(labels) {
+			return labels[level-1]
+		}
+		return strconv.Itoa(level) // above the levels configured
+	}
+	dist := func(l int) int { return max(level-l, l-level) }
+	presets := obj.presetLevels()
+	nearest := 0
+	for i, l := range presets {
+		if dist(l) < dist(presets[nearest]) {
+			nearest = i
+		}
+	}
+	return fan_presets[nearest]
+}
+
+// FanModes returns the fan modes: auto and the labels, or low, medium
+// and high; none if the set map is known without the fan.
+func (obj *EchonetObject) FanModes() []string {
+	if _, set, _ := obj.PropertyMap(); set != nil && !slices.Contains(set, EPC_FAN) {
+		return []string{}
+	}
+	if labels := obj.cfg.FanLabels; len(labels) > 0 {
+		return append([]string{"auto"}, labels...)
+	}
+	return append([]string{"auto"}, fan_presets...)
 }
 
+// SetSwing sets the swing mode: off, vertical, horizontal or both
+// swinging (0xa3), auto for the automatic direction control (0xa1), or
+// a fixed vertical direction (0xa4), e.g. center. The former ud, lr and
+// on are accepted also.
 func (obj *EchonetObject) SetSwing(mode string) error {
+	if m, ok := swing_aliases[mode]; ok {
+		mode = m
+	}
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
-	pkt.SetEsv(ESV_SETI)
+	pkt.SetEsv(ESV_SETC)
 
-	switch mode {
-	case "off":
+	if swing, ok := aircon_swings.Encode(mode); ok {
+		pkt.AddProperty(EPC_SWING, swing)
+		if mode == "off" && obj.inSetMap(EPC_AUTO_DIRECTION) {
+			pkt.AddProperty(EPC_AUTO_DIRECTION, 0x42) // manual
+		}
+	} else if mode == "auto" {
 		pkt.AddProperty(EPC_SWING, EDT_OFF)
-	case "ud":
-		pkt.AddProperty(EPC_FAN, 0x41) // up and down
-	case "lr":
-		pkt.AddProperty(EPC_FAN, 0x42) // left and right
-	case "on":
-		pkt.AddProperty(EPC_FAN, 0x43)
-	default:
-		return fmt.Errorf("invalid mode: %s", mode)
+		pkt.AddProperty(EPC_AUTO_DIRECTION, 0x41)
+	} else if dir, ok := aircon_directions.Encode(mode); ok {
+		pkt.AddProperty(EPC_SWING, EDT_OFF)
+		if obj.inSetMap(EPC_AUTO_DIRECTION) {
+			pkt.AddProperty(EPC_AUTO_DIRECTION, 0x42) // manual
+		}
+		pkt.AddProperty(EPC_DIRECTION_V, dir)
+	} else {
+		return &ValueError{"swing", mode}
 	}
 
-	return obj.sendPacket(pkt)
+	err := obj.set(pkt)
+	if err == nil {
+		obj.state_mutex.Lock()
+		obj.swing_dir = slices.Contains(direction_names, mode)
+		obj.state_mutex.Unlock()
+	}
+	return err
 }
 
+// GetSwing returns the swing mode reported by the device: swinging,
+// auto while the direction is controlled automatically, or off. The
+// fixed vertical direction is returned only if it was the last mode
+// set; it is published as vertical_direction otherwise.
 func (obj *EchonetObject) GetSwing() (mode string) {
-	switch obj.fan {
-	case EDT_OFF:
-		return "off"
-	case 0x41:
-		return "ud"
-	case 0x42:
-		return "lr"
-	case 0x43:
-		return "on"
+	obj.state_mutex.Lock()
+	swing, swing_dir := obj.swing, obj.swing_dir
+	obj.state_mutex.Unlock()
+	if mode, ok := aircon_swings.Decode([]byte{byte(swing)}); ok &&
+		mode != "off" {
+		return mode
+	}
+	props := obj.GetProperties()
+	if auto, ok := aircon_auto_directions.Decode(props[EPC_AUTO_DIRECTION]); ok &&
+		auto != "manual" {
+		return "auto"
+	}
+	if dir, ok := aircon_directions.Decode(props[EPC_DIRECTION_V]); ok &&
+		swing_dir {
+		return dir
 	}
 	return "off"
 }
 
+// SwingModes returns the swing modes the device supports by its set
+// map, the swinging ones while the map is unknown.
+func (obj *EchonetObject) SwingModes() []string {
+	modes := []string{"off", "vertical", "horizontal", "both"}
+	if obj.inSetMap(EPC_AUTO_DIRECTION) {
+		modes = append(modes, "auto")
+	}
+	if obj.inSetMap(EPC_DIRECTION_V) {
+		modes = append(modes, direction_names...)
+	}
+	return modes
+}
+
+// inSetMap returns true if the set map is known and has the property.
+func (obj *EchonetObject) inSetMap(epc byte) bool {
+	_, set, _ := obj.PropertyMap()
+	return slices.Contains(set, epc)
+}
+
 func (obj *EchonetObject) SetTargetTemp(temp int) error {
-	if obj.target_temp == 0xfd {
+	obj.state_mutex.Lock()
+	auto := obj.target_auto
+	obj.state_mutex.Unlock()
+	if auto {
 		// In case of 0xfd, the target temperature is auto.
 		return nil // ignore setting
 	}
-	if temp < 0 || temp > 50 {
-		return fmt.Errorf("invalid temperature %d", temp)
+	edt, err := UINT8.Encode(float64(temp))
+	if err != nil || temp > 50 {
+		return &ValueError{"temperature", strconv.Itoa(temp)}
 	}
 
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
-	pkt.SetEsv(ESV_SETI)
-	pkt.AddProperty(EPC_TARGET_TEMP, byte(temp))
-	return obj.sendPacket(pkt)
+	pkt.SetEsv(ESV_SETC)
+	pkt.AddProperty(EPC_TARGET_TEMP, edt...)
+	return obj.set(pkt)
 }
 
-func (obj *EchonetObject) GetTargetTemp() (temp int) {
-	if obj.target_temp == 0xfd {
+// GetTargetTemp returns the target temperature, false if unknown.
+func (obj *EchonetObject) GetTargetTemp() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	if obj.target_auto {
 		// In case of 0xfd, the target temperature is auto.
-		return obj.room_temp
+		return obj.room_temp.Int()
 	}
-	return obj.target_temp
+	return obj.target_temp.Int()
 }
 
 func (obj *EchonetObject) SetTargetHumidity(humi int) error {
-	if humi < 0 || humi > 100 {
-		return fmt.Errorf("invalid humidity %d", humi)
+	edt, err := PERCENT.Encode(float64(humi))
+	if err != nil || humi > 100 {
+		return &ValueError{"humidity", strconv.Itoa(humi)}
 	}
 
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
-	pkt.SetEsv(ESV_SETI)
-	pkt.AddProperty(EPC_TARGET_HUMIDITY, byte(humi))
-	return obj.sendPacket(pkt)
+	pkt.SetEsv(ESV_SETC)
+	pkt.AddProperty(EPC_TARGET_HUMIDITY, edt...)
+	return obj.set(pkt)
+}
+
+// The sensor getters return false while the value is unknown, e.g.
+// before the first response or if the device cannot measure it.
+
+func (obj *EchonetObject) GetTargetHumidity() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.target_humidity.Int()
+}
+
+func (obj *EchonetObject) GetRoomTemp() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.room_temp.Int()
 }
 
-func (obj *EchonetObject) GetTargetHumidity() (temp int) {
-	return obj.target_humidity
+func (obj *EchonetObject) GetOutdoorTemp() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.outdoor_temp.Int()
 }
 
-func (obj *EchonetObject) GetRoomTemp() int {
-	return obj.room_temp
+func (obj *EchonetObject) GetRoomHumidfy() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.room_humidity.Int()
 }
 
-func (obj *EchonetObject) GetOutdoorTemp() int {
-	return obj.outdoor_temp
+func (obj *EchonetObject) GetWatt() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.watt.Int()
 }
 
-func (obj *EchonetObject) GetRoomHumidfy() int {
-	return obj.room_humidity
+func (obj *EchonetObject) SetBrightness(level int) error {
+	edt, err := PERCENT.Encode(float64(level))
+	if err != nil || level > 100 {
+		return &ValueError{"brightness", strconv.Itoa(level)}
+	}
+
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(obj.eoj)
+	pkt.SetEsv(ESV_SETC)
+	pkt.AddProperty(EPC_BRIGHTNESS, edt...)
+	return obj.set(pkt)
 }
 
-func (obj *EchonetObject) GetWatt() int {
-	return obj.watt
+// GetBrightness returns the brightness in %, false for lights without
+// it or before it is read.
+func (obj *EchonetObject) GetBrightness() (int, bool) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.brightness.Int()
 }
 
+// Property gets the property maps and waits for the response.
 func (obj *EchonetObject) Property() error {
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
@@ -409,10 +961,116 @@ func (obj *EchonetObject) Property() error {
 	pkt.AddProperty(EPC_INF_PROPMAP) // announce map
 	pkt.AddProperty(EPC_SET_PROPMAP) // set map
 	pkt.AddProperty(EPC_GET_PROPMAP) // get map
-	return obj.sendPacket(pkt)
+	_, err := obj.request(pkt)
+	return err
+}
+
+// GetProperties returns the properties received of a generic object.
+func (obj *EchonetObject) GetProperties() map[byte][]byte {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	props := make(map[byte][]byte, len(obj.props))
+	for epc, edt := range obj.props {
+		props[epc] = edt
+	}
+	return props
+}
+
+// SetProperty sets the property and returns the outcome reported by
+// the device.
+func (obj *EchonetObject) SetProperty(epc byte, edt []byte) error {
+	return obj.Set(EchonetProperty{EPC: epc, EDT: edt})
+}
+
+// Set sets the properties in one SetC request.
+func (obj *EchonetObject) Set(props ...EchonetProperty) error {
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(obj.eoj)
+	pkt.SetEsv(ESV_SETC)
+	for _, prop := range props {
+		pkt.AddProperty(prop.EPC, prop.EDT...)
+	}
+	return obj.set(pkt)
+}
+
+// Get reads the properties in one Get request. The properties of the
+// response are returned also on SNAError, the denied ones without EDT.
+func (obj *EchonetObject) Get(epcs ...byte) ([]EchonetProperty, error) {
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(obj.eoj)
+	pkt.SetEsv(ESV_GET)
+	for _, epc := range epcs {
+		pkt.AddProperty(epc)
+	}
+	res, err := obj.request(pkt)
+	if res == nil {
+		return nil, err
+	}
+	return res.Props, err
+}
+
+// PropertyMap returns the EPCs announced, settable and gettable,
+// known after Property.
+func (obj *EchonetObject) PropertyMap() (inf, set, get []byte) {
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	return obj.inf_map, obj.set_map, obj.get_map
+}
+
+// Snapshot returns a copy of the object with its state taken at once,
+// for reading a consistent state while the receiver updates it. The
+// copy is not registered and must not be used to send.
+func (obj *EchonetObject) Snapshot() *EchonetObject {
+	s := &EchonetObject{parent: obj.parent, id: obj.id, mac: obj.mac,
+		eoj: obj.eoj, cfg: obj.cfg, logger: obj.logger}
+	s.addr.Store(obj.addr.Load())
+	s.last_seen.Store(obj.last_seen.Load())
+
+	obj.state_mutex.Lock()
+	defer obj.state_mutex.Unlock()
+	s.power = obj.power
+	s.mode = obj.mode
+	s.target_temp = obj.target_temp
+	s.target_auto = obj.target_auto
+	s.target_humidity = obj.target_humidity
+	s.room_temp = obj.room_temp
+	s.room_humidity = obj.room_humidity
+	s.outdoor_temp = obj.outdoor_temp
+	s.fan = obj.fan
+	s.swing = obj.swing
+	s.swing_dir = obj.swing_dir
+	s.watt = obj.watt
+	s.brightness = obj.brightness
+	s.inf_map, s.set_map, s.get_map = obj.inf_map, obj.set_map, obj.get_map
+	s.props = maps.Clone(obj.props)
+	return s
 }
 
 func (obj *EchonetObject) State() error {
+	if obj.addr.Load() == nil {
+		obj.parent.rediscover()
+		return nil // not found yet
+	}
+	pkt, err := obj.statePacket()
+	if err != nil {
+		return err
+	}
+	return obj.sendPacket(pkt)
+}
+
+// Refresh gets the state like State and waits for the response.
+func (obj *EchonetObject) Refresh() error {
+	pkt, err := obj.statePacket()
+	if err != nil {
+		return err
+	}
+	_, err = obj.request(pkt)
+	return err
+}
+
+func (obj *EchonetObject) statePacket() (*EchonetPacket, error) {
 	pkt := NewEchonetPacket()
 	pkt.SetSeoj(ECHONET_EOJ_NODE)
 	pkt.SetDeoj(obj.eoj)
@@ -430,66 +1088,124 @@ func (obj *EchonetObject) State() error {
 		pkt.AddProperty(EPC_FAN)             // fan
 		pkt.AddProperty(EPC_SWING)           // swing
 		pkt.AddProperty(EPC_WATT)            // watt
+		// the optional properties the device has
+		if _, _, get := obj.PropertyMap(); get == nil {
+			pkt.AddProperty(EPC_INF_PROPMAP)
+			pkt.AddProperty(EPC_SET_PROPMAP)
+			pkt.AddProperty(EPC_GET_PROPMAP)
+		}
+		obj.addAirconProperties(pkt)
 	case "light":
 		pkt.AddProperty(EPC_POWER) // power
+		// brightness, if the light is dimmable
+		_, _, get := obj.PropertyMap()
+		if get == nil {
+			pkt.AddProperty(EPC_INF_PROPMAP)
+			pkt.AddProperty(EPC_SET_PROPMAP)
+			pkt.AddProperty(EPC_GET_PROPMAP)
+		} else if slices.Contains(get, EPC_BRIGHTNESS) {
+			pkt.AddProperty(EPC_BRIGHTNESS)
+		}
+	case "generic":
+		// all readable properties, known after the property maps
+		_, _, get := obj.PropertyMap()
+		if get == nil {
+			pkt.AddProperty(EPC_INF_PROPMAP)
+			pkt.AddProperty(EPC_SET_PROPMAP)
+			pkt.AddProperty(EPC_GET_PROPMAP)
+			break
+		}
+		for _, epc := range get {
+			if epc != EPC_INF_PROPMAP && epc != EPC_SET_PROPMAP &&
+				epc != EPC_GET_PROPMAP {
+				pkt.AddProperty(epc)
+			}
+		}
 	default:
-		return fmt.Errorf("invalid type: %s", obj.cfg.Type)
+		return nil, fmt.Errorf("invalid type: %s", obj.cfg.Type)
 	}
-
-	return obj.sendPacket(pkt)
+	return pkt, nil
 }
 
 func (obj *EchonetObject) Handler(pkt *EchonetPacket) {
+	props := pkt.Props
+	if isSetGet(pkt.ESV) {
+		props = pkt.GetProps // the set section has no state
+	}
+	// Get_SNA and SetGet_SNA carry the readable properties as well.
 	if pkt.ESV == ESV_GET_RES || pkt.ESV == ESV_SETGET_RES ||
-		pkt.ESV == ESV_INF {
-		for _, prop := range pkt.Props {
+		pkt.ESV == ESV_INF || pkt.ESV == ESV_GET_SNA ||
+		pkt.ESV == ESV_SETGET_SNA {
+		obj.state_mutex.Lock()
+		first := obj.get_map == nil
+		for _, prop := range props {
+			if len(prop.EDT) == 0 {
+				continue // not available
+			}
+			if obj.cfg.Type == "generic" ||
+				(obj.cfg.Type == "aircon" && isAirconProperty(prop.EPC)) {
+				if obj.props == nil {
+					obj.props = map[byte][]byte{}
+				}
+				obj.props[prop.EPC] = prop.EDT
+				if obj.cfg.Type == "generic" &&
+					prop.EPC != EPC_INF_PROPMAP &&
+					prop.EPC != EPC_SET_PROPMAP &&
+					prop.EPC != EPC_GET_PROPMAP {
+					continue
+				}
+			}
+			if obj.cfg.Type == "light" && prop.EPC == EPC_BRIGHTNESS {
+				obj.brightness = PERCENT.Decode(prop.EDT)
+				continue
+			}
 			switch prop.EPC {
 			case EPC_POWER:
 				obj.power = prop.EDT[0] == EDT_ON
 				// While power is off, keep the mode.
 			case EPC_MODE:
-				switch prop.EDT[0] {
-				case 0x41:
-					obj.mode = "auto"
-				case 0x42:
-					obj.mode = "cool"
-				case 0x43:
-					obj.mode = "heat"
-				case 0x44:
-					obj.mode = "dry"
-				case 0x45:
-					obj.mode = "fan"
-				case 0x46:
-					obj.mode = "other"
+				if mode, ok := aircon_modes.Decode(prop.EDT); ok {
+					obj.mode = mode
 				}
 			case EPC_TARGET_TEMP:
-				obj.target_temp = int(prop.EDT[0])
+				// 0xfd is auto
+				obj.target_temp = TARGET_TEMPERATURE.Decode(prop.EDT)
+				obj.target_auto = obj.target_temp.Status == STATUS_NO_DATA
 			case EPC_ROOM_TEMP:
-				obj.room_temp = int(int8(prop.EDT[0]))
-				if obj.room_temp < -127 || obj.room_temp > 125 {
-					// error value
-					obj.room_temp = 20 // tentative
-				}
+				obj.room_temp = TEMPERATURE.Decode(prop.EDT)
 			case EPC_OUTDOOR_TEMP:
-				obj.outdoor_temp = int(int8(prop.EDT[0]))
-				if obj.outdoor_temp < -127 || obj.outdoor_temp > 125 {
-					// error value
-					obj.outdoor_temp = 20 // tentative
-				}
+				obj.outdoor_temp = TEMPERATURE.Decode(prop.EDT)
 			case EPC_ROOM_HUMIDITY:
-				obj.room_humidity = int(prop.EDT[0])
+				obj.room_humidity = PERCENT.Decode(prop.EDT)
 			case EPC_TARGET_HUMIDITY:
-				obj.target_humidity = int(prop.EDT[0])
+				obj.target_humidity = PERCENT.Decode(prop.EDT)
 			case EPC_FAN:
 				obj.fan = int(prop.EDT[0])
 			case EPC_SWING:
 				obj.swing = int(prop.EDT[0])
 			case EPC_WATT:
-				obj.watt = int(prop.EDT[0])*0x100 + int(prop.EDT[1])
+				obj.watt = UINT16.Decode(prop.EDT)
+			case EPC_INF_PROPMAP:
+				obj.inf_map = getPropertyMap(prop)
+			case EPC_SET_PROPMAP:
+				obj.set_map = getPropertyMap(prop)
+			case EPC_GET_PROPMAP:
+				obj.get_map = getPropertyMap(prop)
 			}
 		}
 
+		known := obj.get_map != nil
+		obj.state_mutex.Unlock()
+
+		if first && known {
+			switch obj.cfg.Type {
+			case "generic", "light":
+				go obj.State() // the properties of the map
+			case "aircon":
+				go obj.optionalState()
+			}
+		}
 		obj.parent.RecvChan <- obj
-		//log.Printf("Handler: %+v\n", node)
+		//obj.logger.Debug("handler", "obj", obj)
 	}
 }
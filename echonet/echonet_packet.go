@@ -25,10 +25,20 @@ const (
 	EPC_POWER           = 0x80
 	EPC_PLACE           = 0x81
 	EPC_VERSION         = 0x82
+	EPC_ID              = 0x83
 	EPC_WATT            = 0x84
 	EPC_WATT_INTEGRATE  = 0x85
 	EPC_ERROR_CODE      = 0x86
+	EPC_FAULT           = 0x88
+	EPC_MAKER           = 0x8a
+	EPC_SERIAL          = 0x8d
 	EPC_POWER_SAVE      = 0x8f
+	EPC_ON_TIMER        = 0x90
+	EPC_ON_TIMER_TIME   = 0x91
+	EPC_ON_TIMER_REL    = 0x92
+	EPC_OFF_TIMER       = 0x94
+	EPC_OFF_TIMER_TIME  = 0x95
+	EPC_OFF_TIMER_REL   = 0x96
 	EPC_INF_PROPMAP     = 0x9d
 	EPC_SET_PROPMAP     = 0x9e
 	EPC_GET_PROPMAP     = 0x9f
@@ -40,18 +50,40 @@ const (
 
 	// air conditioner
 	EPC_FAN             = 0xa0
+	EPC_AUTO_DIRECTION  = 0xa1
 	EPC_SWING           = 0xa3
+	EPC_DIRECTION_V     = 0xa4
+	EPC_DIRECTION_H     = 0xa5
+	EPC_SPECIAL_STATE   = 0xaa
+	EPC_NON_PRIORITY    = 0xab
 	EPC_MODE            = 0xb0
+	EPC_AUTO_TEMP       = 0xb1
+	EPC_SPEED           = 0xb2
 	EPC_TARGET_TEMP     = 0xb3
 	EPC_TARGET_HUMIDITY = 0xb4
+	EPC_COOL_TEMP       = 0xb5
+	EPC_HEAT_TEMP       = 0xb6
+	EPC_DRY_TEMP        = 0xb7
 	EPC_ROOM_HUMIDITY   = 0xba
 	EPC_ROOM_TEMP       = 0xbb
 	EPC_OUTDOOR_TEMP    = 0xbe
+	EPC_VENTILATION     = 0xc0
 	EPC_HUMIDIFY        = 0xc1
+	EPC_VENTILATION_FAN = 0xc2
 	EPC_HUMIDIFY_LEVEL  = 0xc4
+	EPC_AIR_PURIFY      = 0xc7
 
 	// lighting
 	EPC_BRIGHTNESS = 0xb0
+	EPC_LIGHT_MODE = 0xb6
+
+	// low voltage smart electric energy meter
+	EPC_METER_COEFFICIENT = 0xd3
+	EPC_METER_DIGITS      = 0xd7
+	EPC_METER_ENERGY      = 0xe0
+	EPC_METER_UNIT        = 0xe1
+	EPC_METER_POWER       = 0xe7
+	EPC_METER_CURRENT     = 0xe8
 
 	EDT_ON   = 0x30
 	EDT_OFF  = 0x31
@@ -74,6 +106,15 @@ type EchonetPacket struct {
 	ESV   byte
 	OPC   byte
 	Props []EchonetProperty
+	// SetGet frames carry the set section in OPC and Props, followed
+	// by the get section
+	OPCGet   byte
+	GetProps []EchonetProperty
+}
+
+// isSetGet returns true if the frame of the ESV has a get section.
+func isSetGet(esv byte) bool {
+	return esv == ESV_SETGET || esv == ESV_SETGET_RES || esv == ESV_SETGET_SNA
 }
 
 func NewEchonetPacket() *EchonetPacket {
@@ -106,66 +147,61 @@ func (pkt *EchonetPacket) Bytes() []byte {
 		b = append(b, prop.PDC)
 		b = append(b, prop.EDT...)
 	}
+	if isSetGet(pkt.ESV) {
+		b = append(b, pkt.OPCGet)
+		for _, prop := range pkt.GetProps {
+			b = append(b, prop.EPC)
+			b = append(b, prop.PDC)
+			b = append(b, prop.EDT...)
+		}
+	}
 
 	return b
 }
 
-func (pkt *EchonetPacket) String() string {
-	s := fmt.Sprintf("EOJ:%06x=>%06x TID:%d ", pkt.SEOJ, pkt.DEOJ, pkt.TID)
-
-	switch pkt.ESV {
+// EsvName returns the name of the ESV.
+func EsvName(esv byte) string {
+	switch esv {
 	case ESV_SETI:
-		s += "SetI"
+		return "SetI"
 	case ESV_SETC:
-		s += "SetC"
+		return "SetC"
 	case ESV_GET:
-		s += "Get"
+		return "Get"
 	case ESV_INF_REQ:
-		s += "INF_REQ"
+		return "INF_REQ"
 	case ESV_SETGET:
-		s += "SetGet"
+		return "SetGet"
 	case ESV_SET_RES:
-		s += "Set_Res" // response for SetC(0x61)
+		return "Set_Res" // response for SetC(0x61)
 	case ESV_GET_RES:
-		s += "Get_Res" // response for Get(0x62)
+		return "Get_Res" // response for Get(0x62)
 	case ESV_INF:
-		s += "INF"
+		return "INF"
 	case ESV_INFC:
-		s += "INFC"
+		return "INFC"
 	case ESV_INFC_RES:
-		s += "INFC_Res" // response for INFC(0x74)
+		return "INFC_Res" // response for INFC(0x74)
 	case ESV_SETGET_RES:
-		s += "SetGet_Res" // response for SetGet(0x6e)
+		return "SetGet_Res" // response for SetGet(0x6e)
 	case ESV_SETI_SNA:
-		s += "SetI_SNA"
+		return "SetI_SNA"
 	case ESV_SETC_SNA:
-		s += "SetC_SNA"
+		return "SetC_SNA"
 	case ESV_GET_SNA:
-		s += "Get_SNA"
+		return "Get_SNA"
 	case ESV_INF_SNA:
-		s += "INF_SNA"
+		return "INF_SNA"
 	case ESV_SETGET_SNA:
-		s += "SetGet_SNA"
-	default:
-		s += fmt.Sprintf("0x%02x", pkt.ESV)
-	}
-
-	for _, prop := range pkt.Props {
-		s += fmt.Sprintf(" (%02x", prop.EPC)
-		edt_list := prop.EDT
-		if prop.EPC == EPC_INF_PROPMAP ||
-			prop.EPC == EPC_SET_PROPMAP ||
-			prop.EPC == EPC_GET_PROPMAP {
-			edt_list = getPropertyMap(prop)
-		}
-
-		for _, edt := range edt_list {
-			s += fmt.Sprintf(" %02x", edt)
-		}
-		s += ")"
+		return "SetGet_SNA"
 	}
+	return fmt.Sprintf("0x%02x", esv)
+}
 
-	return s
+// String renders the packet like Decode, with the names and values
+// from the MRA if loaded.
+func (pkt *EchonetPacket) String() string {
+	return pkt.Decode()
 }
 
 func (pkt *EchonetPacket) Parse(payload []byte) error {
@@ -185,22 +221,53 @@ func (pkt *EchonetPacket) Parse(payload []byte) error {
 		return fmt.Errorf("invalid EHD: 0x%04x", pkt.EHD)
 	}
 
+	var err error
 	idx := 12
-	pkt.Props = nil
-	for i := 0; i < int(pkt.OPC); i++ {
+	pkt.Props, idx, err = parseProps(payload, idx, pkt.OPC)
+	if err != nil {
+		return err
+	}
+
+	pkt.OPCGet = 0
+	pkt.GetProps = nil
+	if isSetGet(pkt.ESV) {
+		if idx >= length {
+			return fmt.Errorf("truncated OPCGet")
+		}
+		pkt.OPCGet = payload[idx]
+		pkt.GetProps, _, err = parseProps(payload, idx+1, pkt.OPCGet)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseProps parses opc properties at idx and returns the index
+// after them.
+func parseProps(payload []byte, idx int, opc byte) ([]EchonetProperty, int, error) {
+	length := len(payload)
+	var props []EchonetProperty
+	for i := 0; i < int(opc); i++ {
+		if idx+2 > length {
+			return nil, idx, fmt.Errorf("truncated property %d of %d", i+1, opc)
+		}
 		prop := EchonetProperty{
 			EPC: payload[idx],
 			PDC: payload[idx+1],
 		}
 		idx += 2
+		if idx+int(prop.PDC) > length {
+			return nil, idx, fmt.Errorf("truncated EDT of EPC 0x%02x", prop.EPC)
+		}
 		for j := 0; j < int(prop.PDC); j++ {
 			prop.EDT = append(prop.EDT, payload[idx])
 			idx += 1
 		}
-		pkt.Props = append(pkt.Props, prop)
+		props = append(props, prop)
 	}
-
-	return nil
+	return props, idx, nil
 }
 
 func (pkt *EchonetPacket) SetTid(tid uint16) {
@@ -244,11 +311,23 @@ func (pkt *EchonetPacket) AddProperty(epc byte, edt ...byte) {
 	})
 }
 
+// AddGetProperty adds a property to the get section of a SetGet frame.
+func (pkt *EchonetPacket) AddGetProperty(epc byte, edt ...byte) {
+	pkt.OPCGet += 1
+	pkt.GetProps = append(pkt.GetProps, EchonetProperty{
+		EPC: epc,
+		PDC: byte(len(edt)),
+		EDT: edt,
+	})
+}
+
 func getPropertyMap(prop EchonetProperty) (propmap []byte) {
 	if prop.EPC == EPC_INF_PROPMAP ||
 		prop.EPC == EPC_SET_PROPMAP ||
 		prop.EPC == EPC_GET_PROPMAP {
-		if prop.PDC == 17 {
+		if len(prop.EDT) == 0 {
+			return nil // request
+		} else if prop.PDC == 17 {
 			propmap = []byte{}
 			for i := 0; i < 8; i++ {
 				for j := 0; j < 16; j++ {
@@ -263,3 +342,20 @@ func getPropertyMap(prop EchonetProperty) (propmap []byte) {
 	}
 	return propmap
 }
+
+// makePropertyMap encodes the EPCs as the EDT of a property map,
+// the inverse of getPropertyMap.
+func makePropertyMap(epcs []byte) []byte {
+	if len(epcs) < 16 {
+		return append([]byte{byte(len(epcs))}, epcs...)
+	}
+	edt := make([]byte, 17)
+	edt[0] = byte(len(epcs))
+	for _, epc := range epcs {
+		if epc < 0x80 {
+			continue
+		}
+		edt[1+int(epc&0x0f)] |= 1 << ((epc - 0x80) >> 4)
+	}
+	return edt
+}
@@ -2,6 +2,7 @@ package echonet
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 )
 
@@ -53,4 +54,98 @@ func TestProperty(t *testing.T) {
 	if !bytes.Equal(m, e) {
 		t.Errorf("property result %+v expect %+v\n", m, e)
 	}
+
+	edt := makePropertyMap(e)
+	if !bytes.Equal(edt, prop.EDT) {
+		t.Errorf("property map %+v expect %+v\n", edt, prop.EDT)
+	}
+}
+
+func TestParseTruncated(t *testing.T) {
+	e := []byte{
+		0x10, 0x81, // EHD
+		0x12, 0x34, // TID
+		0x11, 0x22, 0x33, // SEOJ
+		0xaa, 0xbb, 0xcc, // DEOJ
+		0x72,             // ESV
+		0x02,             // OPC
+		0x80, 0x01, 0x30, // EPC,PDC,EDT
+		0xa0, 0x02, 0x41, // EPC,PDC,EDT (short)
+	}
+
+	pkt := NewEchonetPacket()
+	for _, n := range []int{11, 13, len(e)} {
+		if err := pkt.Parse(e[:n]); err == nil {
+			t.Errorf("parse %d bytes: expect error\n", n)
+		}
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	e := []byte{
+		0x10, 0x81, // EHD
+		0x00, 0x01, // TID
+		0x05, 0xff, 0x01, // SEOJ
+		0x01, 0x30, 0x01, // DEOJ
+		0x6e,             // ESV
+		0x01,             // OPCSet
+		0xb3, 0x01, 0x18, // EPC,PDC,EDT
+		0x02,       // OPCGet
+		0xb3, 0x00, // EPC,PDC
+		0xbb, 0x00, // EPC,PDC
+	}
+
+	pkt := NewEchonetPacket()
+	if err := pkt.Parse(e); err != nil {
+		t.Fatal(err)
+	}
+	if pkt.OPC != 1 || pkt.Props[0].EDT[0] != 0x18 || pkt.OPCGet != 2 ||
+		pkt.GetProps[1].EPC != 0xbb {
+		t.Errorf("parsed %+v", pkt)
+	}
+	if b := pkt.Bytes(); !bytes.Equal(b, e) {
+		t.Errorf("bytes % x expect % x", b, e)
+	}
+	if s := pkt.String(); !strings.HasSuffix(s, "SetGet TargetTemp=24°C / TargetTemp RoomTemp") {
+		t.Errorf("string %s", s)
+	}
+	if err := pkt.Parse(e[:15]); err == nil {
+		t.Error("parse without get section: expect error")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	pkt := NewEchonetPacket()
+	pkt.SetTid(3)
+	pkt.SetSeoj(0x013001)
+	pkt.SetDeoj(ECHONET_EOJ_NODE)
+	pkt.SetEsv(ESV_GET_RES)
+	pkt.AddProperty(EPC_MODE, 0x42)
+	pkt.AddProperty(EPC_TARGET_TEMP, 26)
+	pkt.AddProperty(EPC_ROOM_TEMP, 0x7e)
+	pkt.AddProperty(EPC_GET_PROPMAP, 0x02, 0x80, 0xb0)
+	pkt.AddProperty(0xf0, 0x01, 0x02)
+	expect := "HomeAirConditioner[013001] => NodeProfile[0ef001] TID:3 " +
+		"Get_Res OperationMode=cool TargetTemp=26°C RoomTemp=no data " +
+		"GetPropertyMap=[80,b0] 0xf0=0102"
+	if s := pkt.Decode(); s != expect {
+		t.Errorf("decode %s\nexpect %s", s, expect)
+	}
+
+	// the properties of requests are of the destination
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(0x029001)
+	pkt.SetEsv(ESV_SETGET)
+	pkt.Props = nil
+	pkt.AddProperty(EPC_BRIGHTNESS, 50)
+	pkt.AddGetProperty(EPC_POWER)
+	if s := pkt.Decode(); !strings.HasSuffix(s,
+		"SetGet Brightness=50% / OperationStatus") {
+		t.Errorf("decode %s", s)
+	}
+
+	err := &SNAError{Esv: ESV_SETC_SNA, Eoj: 0x013001, Epcs: []byte{0xb3}}
+	if s := err.Error(); s != "SetC_SNA: 0xb3(TargetTemp)" {
+		t.Errorf("error %s", s)
+	}
 }
@@ -0,0 +1,447 @@
+package echonet
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startEchonet connects an Echonet at 10.0.0.1 to a simulator at
+// 10.0.0.2 on a memory network.
+func startEchonet(t *testing.T, objs ...*SimObject) (*Echonet, *Simulator) {
+	network := NewMemoryNetwork()
+	sim := NewSimulator("10.0.0.2:3610")
+	var err error
+	sim.Transport, err = network.Attach(sim.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		sim.Add(obj)
+	}
+	// started before, the announcement of the node is not seen
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := network.Attach("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	en, err := NewEchonetOptions(Options{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	en.Timeout = 100 * time.Millisecond
+	en.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	en.Interval = 0
+	go func() {
+		for range en.RecvChan {
+		}
+	}()
+	if err := en.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sim.Close()
+		en.Close()
+	})
+	return en, sim
+}
+
+func TestEchonetSet(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := obj.SetMode("heat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if mode := obj.GetMode(); mode != "heat" {
+		t.Errorf("mode %s expect heat", mode)
+	}
+
+	var verr *ValueError
+	if err := obj.SetMode("warm"); !errors.As(err, &verr) {
+		t.Errorf("SetMode error %v expect ValueError", err)
+	}
+
+	sim.SetFaults(Faults{SNA: []byte{EPC_TARGET_TEMP}})
+	var serr *SNAError
+	err = obj.SetTargetTemp(22)
+	if !errors.As(err, &serr) || serr.Epcs[0] != EPC_TARGET_TEMP {
+		t.Errorf("SetTargetTemp error %v expect SNA of 0xb3", err)
+	}
+
+	sim.SetFaults(Faults{Drop: 1})
+	if err := obj.SetTargetTemp(22); !errors.Is(err, ErrTimeout) {
+		t.Errorf("SetTargetTemp error %v expect timeout", err)
+	}
+}
+
+func TestEchonetEvents(t *testing.T) {
+	en, sim := startEchonet(t, NewSimLight(1))
+	obj, err := en.NewObject(Config{Type: "light", Name: "hall",
+		Addr: "10.0.0.2", Eoj: "029001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan *Event, 16)
+	en.Listen(func(ev *Event) { events <- ev })
+
+	sim.SetFaults(Faults{Drop: 1})
+	go obj.Refresh()
+	kinds := map[int]int{}
+	for ev := range events {
+		kinds[ev.Kind] += 1
+		if ev.Kind == EVENT_TIMEOUT {
+			break
+		}
+	}
+	if kinds[EVENT_SEND] != 1+en.Retry || kinds[EVENT_RETRY] != en.Retry {
+		t.Errorf("events %v", kinds)
+	}
+}
+
+func TestMatchInterface(t *testing.T) {
+	ip, lan, _ := net.ParseCIDR("192.168.1.10/24")
+	ip6, ula, _ := net.ParseCIDR("fd00::10/64")
+	nets := []*net.IPNet{{IP: ip, Mask: lan.Mask}, {IP: ip6, Mask: ula.Mask}}
+
+	for _, tc := range []struct {
+		specs []string
+		match bool
+	}{
+		{[]string{"eth0"}, true},
+		{[]string{"eth1"}, false},
+		{[]string{"eth"}, false},
+		{[]string{"192.168.0.0/16"}, true},
+		{[]string{"192.168.2.0/24"}, false},
+		{[]string{"docker0", "192.168.1.0/24"}, true},
+		{[]string{"10.0.0.0/8", "br0"}, false},
+		{[]string{"fd00::/8"}, true},
+		{[]string{"fe80::/10"}, false},
+	} {
+		if m := matchInterface(tc.specs, "eth0", nets); m != tc.match {
+			t.Errorf("%v: %v expect %v", tc.specs, m, tc.match)
+		}
+	}
+}
+
+func TestDeviceAddr(t *testing.T) {
+	for addr, expect := range map[string]string{
+		"192.168.1.10":       "192.168.1.10:3610",
+		"192.168.1.10:13610": "192.168.1.10:13610",
+		"fe80::1%eth0":       "[fe80::1%eth0]:3610",
+		"[fd00::1]:13610":    "[fd00::1]:13610",
+		"aircon.local":       "aircon.local:3610",
+	} {
+		if s := deviceAddr(addr); s != expect {
+			t.Errorf("%s: %s expect %s", addr, s, expect)
+		}
+	}
+}
+
+func TestEchonetDiscovery(t *testing.T) {
+	network := NewMemoryNetwork()
+	startSim := func(addr string) *Simulator {
+		sim := NewSimulator(addr)
+		sim.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		var err error
+		sim.Transport, err = network.Attach(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sim.Add(NewSimAircon(1))
+		if err := sim.Start(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sim.Close() })
+		return sim
+	}
+	waitAddr := func(obj *EchonetObject, addr string) {
+		t.Helper()
+		for i := 0; i < 50 && obj.GetAddr() != addr; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if a := obj.GetAddr(); a != addr {
+			t.Fatalf("addr %q expect %q", a, addr)
+		}
+	}
+
+	old := startSim("10.0.0.2:3610")
+	id := old.Get(ECHONET_EOJ_NODE, EPC_ID)
+	mac, _ := net.ParseMAC("02:00:00:00:00:02")
+	defer func(f func(net.IP) net.HardwareAddr) { lookupMac = f }(lookupMac)
+	lookupMac = func(ip net.IP) net.HardwareAddr {
+		if ip.Equal(net.ParseIP("10.0.0.2")) {
+			return mac
+		}
+		return nil
+	}
+
+	tr, err := network.Attach("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	en, err := NewEchonetOptions(Options{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	en.Timeout = 100 * time.Millisecond
+	en.Retry = 0
+	en.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	en.Interval = 0
+	en.Rediscovery = 0
+	if err := en.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { en.Close() })
+
+	byId, err := en.NewObject(Config{Type: "aircon", Name: "id",
+		Eoj: "013001", Id: hex.EncodeToString(id)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	byMac, err := en.NewObject(Config{Type: "aircon", Name: "mac",
+		Eoj: "013001", Mac: mac.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitAddr(byId, "10.0.0.2")
+	waitAddr(byMac, "10.0.0.2")
+	if err := byId.SetMode("heat"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node moves to another address
+	old.Close()
+	moved := startSim("10.0.0.3:3610")
+	moved.Set(ECHONET_EOJ_NODE, EPC_ID, id...)
+
+	if err := byId.Refresh(); !errors.Is(err, ErrTimeout) {
+		t.Errorf("err %v expect timeout", err)
+	}
+	waitAddr(byId, "10.0.0.3")
+	if err := byId.SetMode("cool"); err != nil {
+		t.Fatal(err)
+	}
+	if edt := moved.Get(0x013001, EPC_MODE); edt[0] != 0x42 {
+		t.Errorf("mode %x expect 42", edt)
+	}
+
+	if _, err := en.NewObject(Config{Type: "aircon", Name: "none",
+		Eoj: "013001"}); err == nil {
+		t.Error("no error without address")
+	}
+}
+
+func TestEchonetInstances(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1), NewSimAircon(2))
+	var objs []*EchonetObject
+	for _, eoj := range []string{"013001", "013002", "013000"} {
+		obj, err := en.NewObject(Config{Type: "aircon", Name: eoj,
+			Addr: "10.0.0.2", Eoj: eoj})
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+	first, second, all := objs[0], objs[1], objs[2]
+
+	// to all instances
+	if err := all.SetMode("heat"); err != nil {
+		t.Fatal(err)
+	}
+	for _, eoj := range []uint32{0x013001, 0x013002} {
+		if edt := sim.Get(eoj, EPC_MODE); edt[0] != 0x43 {
+			t.Errorf("%06x mode %x expect 43", eoj, edt)
+		}
+	}
+	if err := first.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := all.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	// an announcement reaches the instance only
+	sim.Set(0x013002, EPC_MODE, 0x42)
+	for i := 0; i < 50 && second.GetMode() != "cool"; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m := second.GetMode(); m != "cool" {
+		t.Errorf("second mode %s expect cool", m)
+	}
+	if m := first.GetMode(); m != "heat" {
+		t.Errorf("first mode %s expect heat", m)
+	}
+	if m := all.GetMode(); m != "heat" {
+		t.Errorf("all mode %s expect heat", m)
+	}
+}
+
+func TestEchonetGroupRequests(t *testing.T) {
+	en, _ := startEchonet(t, NewSimAircon(1))
+	var objs []*EchonetObject
+	for _, eoj := range []string{"013001", "013000"} {
+		obj, err := en.NewObject(Config{Type: "aircon", Name: eoj,
+			Addr: "10.0.0.2", Eoj: eoj})
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+
+	rtts := make(chan *EchonetObject, 16)
+	var mutex sync.Mutex
+	first := map[*EchonetPacket]int{} // events not Repeated
+	en.Listen(func(ev *Event) {
+		if ev.Kind == EVENT_RECV {
+			mutex.Lock()
+			if !ev.Repeated {
+				first[ev.Packet] += 1
+			} else if first[ev.Packet] == 0 {
+				t.Errorf("repeated event before the first")
+			}
+			mutex.Unlock()
+		}
+		if ev.Kind == EVENT_RECV && ev.RTT > 0 {
+			rtts <- ev.Object
+		}
+	})
+	// the responses of both reach both objects, each completes its own
+	errs := make(chan error, len(objs))
+	for _, obj := range objs {
+		go func() { errs <- obj.Refresh() }()
+	}
+	for range objs {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	answered := map[*EchonetObject]bool{}
+	timeout := time.After(time.Second)
+	for len(answered) < len(objs) {
+		select {
+		case obj := <-rtts:
+			answered[obj] = true
+		case <-timeout:
+			t.Fatalf("round trips of %v", answered)
+		}
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for pkt, n := range first {
+		if n != 1 {
+			t.Errorf("%s: %d first events", pkt, n)
+		}
+	}
+}
+
+func TestEchonetSetGet(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001", SetGet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// read back without Refresh
+	if err := obj.SetTargetTemp(22); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := obj.GetTargetTemp(); v != 22 {
+		t.Errorf("target temperature %d expect 22", v)
+	}
+
+	get, err := obj.SetGet([]EchonetProperty{
+		{EPC: EPC_POWER, EDT: []byte{EDT_ON}},
+		{EPC: EPC_MODE, EDT: []byte{0x43}},
+	}, EPC_POWER, EPC_MODE, EPC_ROOM_TEMP)
+	if err != nil || len(get) != 3 || get[1].EDT[0] != 0x43 {
+		t.Fatalf("SetGet %v %v", get, err)
+	}
+	if m := obj.GetMode(); m != "heat" {
+		t.Errorf("mode %s expect heat", m)
+	}
+
+	sim.SetFaults(Faults{SNA: []byte{EPC_ROOM_TEMP}})
+	get, err = obj.SetGet([]EchonetProperty{{EPC: EPC_TARGET_TEMP, EDT: []byte{23}}},
+		EPC_TARGET_TEMP, EPC_ROOM_TEMP)
+	var sna *SNAError
+	if !errors.As(err, &sna) || len(sna.Epcs) != 1 || sna.Epcs[0] != EPC_ROOM_TEMP {
+		t.Fatalf("SetGet error %v expect SNA of room temperature", err)
+	}
+	if v, _ := obj.GetTargetTemp(); len(get) != 2 || get[0].EDT[0] != 23 || v != 23 {
+		t.Errorf("SetGet %v target %d", get, v)
+	}
+}
+
+func TestEchonetSetGetState(t *testing.T) {
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001", SetGet: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a state property denied in the get section does not fail the set
+	sim.SetFaults(Faults{SNA: []byte{EPC_OUTDOOR_TEMP}})
+	if err := obj.SetTargetTemp(24); err != nil {
+		t.Fatalf("SetTargetTemp error %v", err)
+	}
+	if v, _ := obj.GetTargetTemp(); v != 24 {
+		t.Errorf("target temperature %d expect 24", v)
+	}
+
+	sim.SetFaults(Faults{SNA: []byte{EPC_TARGET_TEMP}})
+	var sna *SNAError
+	err = obj.SetTargetTemp(25)
+	if !errors.As(err, &sna) || len(sna.Epcs) != 1 || sna.Epcs[0] != EPC_TARGET_TEMP {
+		t.Errorf("SetTargetTemp error %v expect SNA of 0xb3", err)
+	}
+}
+
+func TestEchonetLightBrightness(t *testing.T) {
+	switchable := newSimObject(0x029002, deviceProps(nil),
+		[]byte{EPC_POWER}, []byte{EPC_POWER})
+	en, _ := startEchonet(t, NewSimLight(1), switchable)
+
+	for _, tt := range []struct {
+		eoj    string
+		expect bool
+	}{
+		{"029001", true},
+		{"029002", false}, // without 0xb0
+	} {
+		obj, err := en.NewObject(Config{Type: "light", Name: tt.eoj,
+			Addr: "10.0.0.2", Eoj: tt.eoj})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the property maps first
+		for i := 0; i < 2; i++ {
+			if err := obj.Refresh(); err != nil {
+				t.Fatalf("%s: %s", tt.eoj, err)
+			}
+		}
+		level, ok := obj.GetBrightness()
+		if ok != tt.expect || (ok && level != 80) {
+			t.Errorf("%s: brightness %d %v expect %v", tt.eoj, level, ok,
+				tt.expect)
+		}
+	}
+}
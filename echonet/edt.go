@@ -0,0 +1,222 @@
+package echonet
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// EdtStatus of a decoded EDT
+type EdtStatus int
+
+const (
+	STATUS_OK        EdtStatus = iota
+	STATUS_NO_DATA             // not measured or not available
+	STATUS_OVERFLOW            // above the range
+	STATUS_UNDERFLOW           // below the range
+	STATUS_INVALID             // EDT of the wrong size
+)
+
+func (s EdtStatus) String() string {
+	switch s {
+	case STATUS_OK:
+		return "ok"
+	case STATUS_NO_DATA:
+		return "no data"
+	case STATUS_OVERFLOW:
+		return "overflow"
+	case STATUS_UNDERFLOW:
+		return "underflow"
+	}
+	return "invalid"
+}
+
+// Number is a decoded numeric EDT.
+type Number struct {
+	Value  float64
+	Status EdtStatus
+}
+
+// Valid returns true if the value is a measurement.
+func (n Number) Valid() bool {
+	return n.Status == STATUS_OK
+}
+
+// Int returns the value rounded to an integer and whether it is valid.
+func (n Number) Int() (int, bool) {
+	return int(math.Round(n.Value)), n.Valid()
+}
+
+func (n Number) String() string {
+	if !n.Valid() {
+		return n.Status.String()
+	}
+	return fmt.Sprintf("%g", n.Value)
+}
+
+// NumberType is the encoding of a numeric property. The largest codes
+// are reserved: overflow, underflow and optionally no data, i.e.
+// 0xff/0xfe/0xfd for unsigned char and 0x7f/0x80/0x7e for signed char.
+type NumberType struct {
+	Size   int     // 1, 2 or 4 bytes, big endian
+	Signed bool    // two's complement
+	Scale  float64 // unit of the code, e.g. 0.1 for 0.1 °C; 0 means 1
+	NoData bool    // the code below overflow means no data
+}
+
+var (
+	UINT8  = NumberType{Size: 1}
+	INT8   = NumberType{Size: 1, Signed: true}
+	UINT16 = NumberType{Size: 2}
+	INT16  = NumberType{Size: 2, Signed: true}
+	UINT32 = NumberType{Size: 4}
+	INT32  = NumberType{Size: 4, Signed: true}
+
+	// percentage, 0xfd means not measurable
+	PERCENT = NumberType{Size: 1, NoData: true}
+	// set temperature in °C, 0xfd means undefined (auto)
+	TARGET_TEMPERATURE = NumberType{Size: 1, NoData: true}
+	// temperature in °C, 0x7e means not measurable
+	TEMPERATURE = NumberType{Size: 1, Signed: true, NoData: true}
+	// temperature in 0.1 °C, 0x7ffe means not measurable
+	TEMPERATURE10 = NumberType{Size: 2, Signed: true, Scale: 0.1, NoData: true}
+	// energy in Wh, as kWh
+	ENERGY_KWH = NumberType{Size: 4, Scale: 0.001}
+)
+
+func (t NumberType) scale() float64 {
+	if t.Scale == 0 {
+		return 1
+	}
+	return t.Scale
+}
+
+// limits returns the codes of overflow and underflow, and the range of
+// the valid codes.
+func (t NumberType) limits() (over, under, lo, hi int64) {
+	bits := uint(t.Size * 8)
+	if t.Signed {
+		over = 1<<(bits-1) - 1
+		under = -1 << (bits - 1)
+		lo, hi = under+1, over-1
+	} else {
+		over = 1<<bits - 1
+		under = over - 1
+		lo, hi = 0, over-2
+	}
+	if t.NoData {
+		hi -= 1
+	}
+	return
+}
+
+// Decode decodes the EDT.
+func (t NumberType) Decode(edt []byte) Number {
+	if len(edt) != t.Size {
+		return Number{Status: STATUS_INVALID}
+	}
+	var u uint64
+	for _, b := range edt {
+		u = u<<8 | uint64(b)
+	}
+	code := int64(u)
+	if t.Signed {
+		shift := 64 - uint(t.Size*8)
+		code = int64(u<<shift) >> shift
+	}
+
+	over, under, _, hi := t.limits()
+	switch {
+	case code == over:
+		return Number{Status: STATUS_OVERFLOW}
+	case code == under:
+		return Number{Status: STATUS_UNDERFLOW}
+	case code > hi:
+		return Number{Status: STATUS_NO_DATA}
+	}
+	// 23.5 rather than 23.500000000000004 for a scale of 0.1
+	if inv := 1 / t.scale(); inv > 1 && inv == math.Round(inv) {
+		return Number{Value: float64(code) / inv}
+	}
+	return Number{Value: float64(code) * t.scale()}
+}
+
+// Encode encodes the value, which must be within the valid range.
+func (t NumberType) Encode(v float64) ([]byte, error) {
+	code := int64(math.Round(v / t.scale()))
+	_, _, lo, hi := t.limits()
+	if code < lo || code > hi {
+		return nil, fmt.Errorf("out of range: %g", v)
+	}
+	edt := make([]byte, t.Size)
+	for i := t.Size - 1; i >= 0; i-- {
+		edt[i] = byte(code)
+		code >>= 8
+	}
+	return edt, nil
+}
+
+// Enum maps the codes of a 1 byte property to names.
+type Enum map[byte]string
+
+// Decode returns the name of the code, false if unknown.
+func (e Enum) Decode(edt []byte) (string, bool) {
+	if len(edt) != 1 {
+		return "", false
+	}
+	name, ok := e[edt[0]]
+	return name, ok
+}
+
+// Encode returns the code of the name, false if unknown.
+func (e Enum) Encode(name string) (byte, bool) {
+	for code, n := range e {
+		if n == name {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// DecodeBitmap returns the numbers of the bits set, bit 0 being the
+// least significant bit of the last byte.
+func DecodeBitmap(edt []byte) []int {
+	var bits []int
+	for i := range edt {
+		b := edt[len(edt)-1-i]
+		for j := 0; j < 8; j++ {
+			if b&(1<<j) != 0 {
+				bits = append(bits, i*8+j)
+			}
+		}
+	}
+	return bits
+}
+
+// DecodeDate decodes YYYY:MM:DD (4 bytes) in the local time zone.
+func DecodeDate(edt []byte) (time.Time, bool) {
+	if len(edt) != 4 {
+		return time.Time{}, false
+	}
+	y, m, d := int(edt[0])<<8|int(edt[1]), int(edt[2]), int(edt[3])
+	if m < 1 || m > 12 || d < 1 || d > 31 {
+		return time.Time{}, false
+	}
+	return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.Local), true
+}
+
+// DecodeTime decodes HH:MM or HH:MM:SS as the time since midnight.
+func DecodeTime(edt []byte) (time.Duration, bool) {
+	if len(edt) != 2 && len(edt) != 3 {
+		return 0, false
+	}
+	h, m, s := int(edt[0]), int(edt[1]), 0
+	if len(edt) == 3 {
+		s = int(edt[2])
+	}
+	if h > 23 || m > 59 || s > 59 {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute +
+		time.Duration(s)*time.Second, true
+}
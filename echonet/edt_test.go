@@ -0,0 +1,93 @@
+package echonet
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNumberType(t *testing.T) {
+	for _, tc := range []struct {
+		typ    NumberType
+		edt    []byte
+		value  float64
+		status EdtStatus
+	}{
+		{UINT8, []byte{0x00}, 0, STATUS_OK},
+		{UINT8, []byte{0xfd}, 253, STATUS_OK},
+		{UINT8, []byte{0xfe}, 0, STATUS_UNDERFLOW},
+		{UINT8, []byte{0xff}, 0, STATUS_OVERFLOW},
+		{PERCENT, []byte{0xfd}, 0, STATUS_NO_DATA},
+		{TEMPERATURE, []byte{0xf6}, -10, STATUS_OK},
+		{TEMPERATURE, []byte{0x7d}, 125, STATUS_OK},
+		{TEMPERATURE, []byte{0x7e}, 0, STATUS_NO_DATA},
+		{TEMPERATURE, []byte{0x7f}, 0, STATUS_OVERFLOW},
+		{TEMPERATURE, []byte{0x80}, 0, STATUS_UNDERFLOW},
+		{TEMPERATURE10, []byte{0xff, 0x9c}, -10, STATUS_OK},
+		{TEMPERATURE10, []byte{0x00, 0xeb}, 23.5, STATUS_OK},
+		{TEMPERATURE10, []byte{0x7f, 0xfe}, 0, STATUS_NO_DATA},
+		{TEMPERATURE10, []byte{0x00, 0x03}, 0.3, STATUS_OK},
+		{ENERGY_KWH, []byte{0, 0, 0, 0x09}, 0.009, STATUS_OK},
+		{ENERGY_KWH, []byte{0, 0x01, 0xe2, 0x40}, 123.456, STATUS_OK},
+		{UINT16, []byte{0x01, 0xf4}, 500, STATUS_OK},
+		{UINT16, []byte{0xff, 0xff}, 0, STATUS_OVERFLOW},
+		{INT32, []byte{0xff, 0xff, 0xff, 0xfe}, -2, STATUS_OK},
+		{INT32, []byte{0x80, 0, 0, 0}, 0, STATUS_UNDERFLOW},
+		{UINT32, []byte{0, 0, 0x01, 0x00}, 256, STATUS_OK},
+		{UINT16, []byte{0x01}, 0, STATUS_INVALID},
+	} {
+		n := tc.typ.Decode(tc.edt)
+		if n.Status != tc.status || (n.Valid() && n.Value != tc.value) {
+			t.Errorf("%+v % x: %v expect %g %v", tc.typ, tc.edt, n,
+				tc.value, tc.status)
+		}
+		if !n.Valid() {
+			continue
+		}
+		edt, err := tc.typ.Encode(tc.value)
+		if err != nil || !bytes.Equal(edt, tc.edt) {
+			t.Errorf("%+v %g: % x %v expect % x", tc.typ, tc.value, edt,
+				err, tc.edt)
+		}
+	}
+
+	for _, v := range []float64{-1, 254} {
+		if _, err := UINT8.Encode(v); err == nil {
+			t.Errorf("encode %g: expect error", v)
+		}
+	}
+}
+
+func TestEdt(t *testing.T) {
+	e := Enum{0x41: "auto", 0x42: "cool"}
+	if name, ok := e.Decode([]byte{0x42}); !ok || name != "cool" {
+		t.Errorf("enum decode %s", name)
+	}
+	if _, ok := e.Decode([]byte{0x43}); ok {
+		t.Error("enum decode unknown code")
+	}
+	if code, ok := e.Encode("auto"); !ok || code != 0x41 {
+		t.Errorf("enum encode %02x", code)
+	}
+
+	if bits := DecodeBitmap([]byte{0x01, 0x82}); !slices.Equal(bits,
+		[]int{1, 7, 8}) {
+		t.Errorf("bitmap %v", bits)
+	}
+
+	d, ok := DecodeDate([]byte{0x07, 0xea, 10, 18})
+	if !ok || d.Year() != 2026 || d.Month() != 10 || d.Day() != 18 {
+		t.Errorf("date %v", d)
+	}
+	if _, ok := DecodeDate([]byte{0x07, 0xea, 13, 1}); ok {
+		t.Error("date with month 13")
+	}
+	if tm, ok := DecodeTime([]byte{7, 30}); !ok ||
+		tm != 7*time.Hour+30*time.Minute {
+		t.Errorf("time %v", tm)
+	}
+	if _, ok := DecodeTime([]byte{24, 0}); ok {
+		t.Error("time 24:00")
+	}
+}
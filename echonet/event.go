@@ -0,0 +1,66 @@
+package echonet
+
+import "time"
+
+// kind of event
+const (
+	EVENT_SEND        = iota // packet sent
+	EVENT_RECV               // packet received, once per object
+	EVENT_PARSE_ERROR        // invalid packet received
+	EVENT_TIMEOUT            // no response to a request
+	EVENT_RETRY              // request sent again
+)
+
+// Event notifies the ECHONET traffic to listeners.
+type Event struct {
+	Kind   int
+	Time   time.Time
+	Addr   string         // peer address
+	Data   []byte         // raw packet
+	Packet *EchonetPacket // nil on parse error
+	Object *EchonetObject // nil if not from/to a known object
+	RTT    time.Duration  // round trip time of a response
+	Err    error
+	// The packet of the previous EVENT_RECV, dispatched to one more
+	// object, e.g. the instance besides its group object.
+	Repeated bool
+}
+
+// Listen registers a function called on every event.
+// It is called from the sender and the receiver, so it must not block.
+func (en *Echonet) Listen(fn func(ev *Event)) {
+	en.list_mutex.Lock()
+	en.listeners = append(en.listeners, fn)
+	en.list_mutex.Unlock()
+}
+
+func (en *Echonet) emit(ev *Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	en.list_mutex.Lock()
+	listeners := en.listeners
+	en.list_mutex.Unlock()
+
+	for _, fn := range listeners {
+		fn(ev)
+	}
+}
+
+// EventName returns the name of the event kind.
+func EventName(kind int) string {
+	switch kind {
+	case EVENT_SEND:
+		return "send"
+	case EVENT_RECV:
+		return "recv"
+	case EVENT_PARSE_ERROR:
+		return "parse_error"
+	case EVENT_TIMEOUT:
+		return "timeout"
+	case EVENT_RETRY:
+		return "retry"
+	}
+	return "unknown"
+}
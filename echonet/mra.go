@@ -0,0 +1,599 @@
+package echonet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// MRA is the Machine Readable Appendix of the ECHONET Consortium: the
+// classes, their properties and the data types in JSON.
+type MRA struct {
+	classes map[uint16]*MRAClass
+}
+
+// MRAClass is a device class, the super class (0x0000) or the node
+// profile (0x0ef0).
+type MRAClass struct {
+	Class      uint16
+	Name       string // English name, e.g. "Home air conditioner"
+	ShortName  string // e.g. "homeAirConditioner"
+	Properties map[byte]*MRAProperty
+}
+
+// MRAProperty is a property of a class.
+type MRAProperty struct {
+	EPC       byte
+	Name      string // English name, e.g. "Operation status"
+	ShortName string // e.g. "operationStatus"
+	Get       bool   // access rules other than notApplicable
+	Set       bool
+	Inf       bool
+	Data      *MRAData
+}
+
+// MRAData is the data type of a property or of an element of it.
+type MRAData struct {
+	Type       string // number, state, level, bitmap, raw, date, ...
+	Format     string // of number, e.g. "int8", "uint16"
+	Size       int
+	Minimum    *float64
+	Maximum    *float64
+	MultipleOf float64 // unit of the code of number, 0 means 1
+	Unit       string
+	Enum       []MRAEnum
+	Base       int // first code of level
+	OneOf      []*MRAData
+	Items      *MRAData // of array
+	ItemSize   int
+	Elements   []MRAElement // of object
+}
+
+// MRAEnum is a code of a state.
+type MRAEnum struct {
+	EDT         []byte
+	Name        string // e.g. "true", "cooling"
+	Description string // English, e.g. "ON"
+	Value       *float64
+}
+
+// MRAElement is a member of an object.
+type MRAElement struct {
+	Name string
+	Data *MRAData
+}
+
+// JSON of the MRA files
+type mraText struct {
+	Ja string `json:"ja"`
+	En string `json:"en"`
+}
+
+type mraFile struct {
+	Eoj        string        `json:"eoj"`
+	ClassName  mraText       `json:"className"`
+	ShortName  string        `json:"shortName"`
+	Properties []mraProperty `json:"elProperties"`
+}
+
+type mraProperty struct {
+	Epc          string            `json:"epc"`
+	PropertyName mraText           `json:"propertyName"`
+	ShortName    string            `json:"shortName"`
+	AccessRule   map[string]string `json:"accessRule"`
+	Data         json.RawMessage   `json:"data"`
+	OneOf        []mraProperty     `json:"oneOf"` // variants by release
+}
+
+type mraData struct {
+	Ref        string       `json:"$ref"`
+	Type       string       `json:"type"`
+	Format     string       `json:"format"`
+	Size       int          `json:"size"`
+	MinSize    int          `json:"minSize"`
+	MaxSize    int          `json:"maxSize"`
+	Minimum    *float64     `json:"minimum"`
+	Maximum    *float64     `json:"maximum"`
+	MultipleOf float64      `json:"multipleOf"`
+	Multiple   float64      `json:"multiple"`
+	Unit       string       `json:"unit"`
+	Base       string       `json:"base"`
+	Enum       []mraEnum    `json:"enum"`
+	OneOf      []mraData    `json:"oneOf"`
+	Items      *mraData     `json:"items"`
+	ItemSize   int          `json:"itemSize"`
+	Properties []mraElement `json:"properties"`
+}
+
+type mraEnum struct {
+	Edt          string   `json:"edt"`
+	Name         string   `json:"name"`
+	Descriptions mraText  `json:"descriptions"`
+	NumericValue *float64 `json:"numericValue"`
+}
+
+type mraElement struct {
+	ElementName string  `json:"elementName"`
+	ShortName   string  `json:"shortName"`
+	Element     mraData `json:"element"`
+}
+
+// the MRA used by EchonetPacket.Decode and the generic objects
+var mra_default atomic.Pointer[MRA]
+
+// UseMRA sets the MRA for the names and values of the properties, nil
+// for none.
+func UseMRA(m *MRA) {
+	mra_default.Store(m)
+}
+
+// DefaultMRA returns the MRA set by UseMRA, nil if none.
+func DefaultMRA() *MRA {
+	return mra_default.Load()
+}
+
+// LoadMRA reads the MRA directory, i.e. definitions/definitions.json
+// and the class files below superClass, nodeProfile and devices.
+func LoadMRA(dir string) (*MRA, error) {
+	definitions := map[string]mraData{}
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		if d.Name() == "definitions.json" {
+			var f struct {
+				Definitions map[string]mraData `json:"definitions"`
+			}
+			if err := readJSON(path, &f); err != nil {
+				return err
+			}
+			for name, def := range f.Definitions {
+				definitions[name] = def
+			}
+			return nil
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MRA{classes: map[uint16]*MRAClass{}}
+	for _, path := range files {
+		var f mraFile
+		if err := readJSON(path, &f); err != nil {
+			return nil, err
+		}
+		if f.Eoj == "" {
+			continue // e.g. metadata
+		}
+		code, err := parseHex(f.Eoj, 2)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid eoj %q", path, f.Eoj)
+		}
+		class := &MRAClass{
+			Class:      uint16(code),
+			Name:       f.ClassName.En,
+			ShortName:  f.ShortName,
+			Properties: map[byte]*MRAProperty{},
+		}
+		for _, p := range f.Properties {
+			if len(p.OneOf) > 0 {
+				p = p.OneOf[len(p.OneOf)-1] // the latest release
+			}
+			prop, err := p.compile(definitions)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			class.Properties[prop.EPC] = prop
+		}
+		m.classes[class.Class] = class
+	}
+	if len(m.classes) == 0 {
+		return nil, fmt.Errorf("%s: no classes", dir)
+	}
+	return m, nil
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+// parseHex parses "0x0130" of at most size bytes.
+func parseHex(s string, size int) (uint64, error) {
+	s = strings.TrimPrefix(strings.ToLower(s), "0x")
+	return strconv.ParseUint(s, 16, size*8)
+}
+
+func (p mraProperty) compile(definitions map[string]mraData) (*MRAProperty, error) {
+	epc, err := parseHex(p.Epc, 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid epc %q", p.Epc)
+	}
+	prop := &MRAProperty{
+		EPC:       byte(epc),
+		Name:      p.PropertyName.En,
+		ShortName: p.ShortName,
+		Get:       accessible(p.AccessRule["get"]),
+		Set:       accessible(p.AccessRule["set"]),
+		Inf:       accessible(p.AccessRule["inf"]),
+	}
+	if len(p.Data) > 0 {
+		var d mraData
+		if err := json.Unmarshal(p.Data, &d); err != nil {
+			return nil, fmt.Errorf("epc %s: %s", p.Epc, err)
+		}
+		prop.Data, err = d.compile(definitions, 0)
+		if err != nil {
+			return nil, fmt.Errorf("epc %s: %s", p.Epc, err)
+		}
+	}
+	return prop, nil
+}
+
+func accessible(rule string) bool {
+	return rule != "" && rule != "notApplicable"
+}
+
+func (d mraData) compile(definitions map[string]mraData, depth int) (*MRAData, error) {
+	if depth > 16 {
+		return nil, errors.New("definitions too deep")
+	}
+	if d.Ref != "" {
+		name := d.Ref[strings.LastIndex(d.Ref, "/")+1:]
+		def, ok := definitions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown definition %q", d.Ref)
+		}
+		return def.compile(definitions, depth+1)
+	}
+
+	data := &MRAData{
+		Type:       d.Type,
+		Format:     d.Format,
+		Size:       d.Size,
+		Minimum:    d.Minimum,
+		Maximum:    d.Maximum,
+		MultipleOf: d.MultipleOf,
+		Unit:       d.Unit,
+		ItemSize:   d.ItemSize,
+	}
+	if data.MultipleOf == 0 {
+		data.MultipleOf = d.Multiple
+	}
+	if data.Size == 0 && d.MinSize == d.MaxSize {
+		data.Size = d.MaxSize
+	}
+	if d.Base != "" {
+		base, err := parseHex(d.Base, 1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base %q", d.Base)
+		}
+		data.Base = int(base)
+	}
+	for _, e := range d.Enum {
+		edt, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(e.Edt), "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid edt %q", e.Edt)
+		}
+		data.Enum = append(data.Enum, MRAEnum{EDT: edt, Name: e.Name,
+			Description: e.Descriptions.En, Value: e.NumericValue})
+	}
+	for _, o := range d.OneOf {
+		od, err := o.compile(definitions, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		data.OneOf = append(data.OneOf, od)
+	}
+	if d.Items != nil {
+		items, err := d.Items.compile(definitions, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		data.Items = items
+	}
+	for _, e := range d.Properties {
+		ed, err := e.Element.compile(definitions, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		name := e.ShortName
+		if name == "" {
+			name = e.ElementName
+		}
+		data.Elements = append(data.Elements, MRAElement{Name: name, Data: ed})
+	}
+	return data, nil
+}
+
+// Class returns the class, nil if unknown.
+func (m *MRA) Class(class uint16) *MRAClass {
+	if m == nil {
+		return nil
+	}
+	return m.classes[class]
+}
+
+// Classes returns the number of classes.
+func (m *MRA) Classes() int {
+	return len(m.classes)
+}
+
+// Property returns the property of the class of the EOJ or of the
+// super class, nil if unknown.
+func (m *MRA) Property(eoj uint32, epc byte) *MRAProperty {
+	if c := m.Class(uint16(eoj >> 8)); c != nil {
+		if p, ok := c.Properties[epc]; ok {
+			return p
+		}
+	}
+	if c := m.Class(0x0000); c != nil {
+		return c.Properties[epc]
+	}
+	return nil
+}
+
+// PropertyByName returns the property of the EOJ by short name, nil if
+// unknown.
+func (m *MRA) PropertyByName(eoj uint32, name string) *MRAProperty {
+	for _, class := range []uint16{uint16(eoj >> 8), 0x0000} {
+		c := m.Class(class)
+		if c == nil {
+			continue
+		}
+		for _, p := range c.Properties {
+			if p.ShortName == name {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// Decode returns the value of the EDT, false if it does not match the
+// data type.
+func (p *MRAProperty) Decode(edt []byte) (string, bool) {
+	if p.Data == nil {
+		return hex.EncodeToString(edt), true
+	}
+	return p.Data.Decode(edt)
+}
+
+// Encode returns the EDT of the value.
+func (p *MRAProperty) Encode(value string) ([]byte, error) {
+	if p.Data == nil {
+		return decodeHexValue(value)
+	}
+	return p.Data.Encode(value)
+}
+
+// size returns the size of the data, 0 if variable.
+func (d *MRAData) size() int {
+	switch d.Type {
+	case "number":
+		return d.numberType().Size
+	case "level":
+		return 1
+	case "date-time":
+		return 7
+	}
+	if d.Size > 0 {
+		return d.Size
+	}
+	if len(d.Enum) > 0 {
+		return len(d.Enum[0].EDT)
+	}
+	if len(d.OneOf) > 0 {
+		size := d.OneOf[0].size()
+		for _, o := range d.OneOf[1:] {
+			if o.size() != size {
+				return 0
+			}
+		}
+		return size
+	}
+	return 0
+}
+
+func (d *MRAData) numberType() NumberType {
+	t := NumberType{Scale: d.MultipleOf}
+	switch strings.TrimPrefix(d.Format, "u") {
+	case "int8":
+		t.Size = 1
+	case "int16":
+		t.Size = 2
+	case "int32":
+		t.Size = 4
+	}
+	t.Signed = !strings.HasPrefix(d.Format, "u")
+	return t
+}
+
+// inRange returns true if the number is within minimum and maximum.
+func (d *MRAData) inRange(v float64) bool {
+	eps := d.MultipleOf / 2
+	return (d.Minimum == nil || v >= *d.Minimum-eps) &&
+		(d.Maximum == nil || v <= *d.Maximum+eps)
+}
+
+// Decode returns the value of the EDT, false if it does not match.
+func (d *MRAData) Decode(edt []byte) (string, bool) {
+	switch d.Type {
+	case "number":
+		n := d.numberType().Decode(edt)
+		if !n.Valid() {
+			return n.String(), n.Status != STATUS_INVALID
+		}
+		if !d.inRange(n.Value) {
+			return "", false
+		}
+		return strconv.FormatFloat(n.Value, 'f', -1, 64), true
+	case "state", "numericValue":
+		for _, e := range d.Enum {
+			if string(e.EDT) == string(edt) {
+				if d.Type == "numericValue" && e.Value != nil {
+					return strconv.FormatFloat(*e.Value, 'f', -1, 64), true
+				}
+				return e.Name, true
+			}
+		}
+		return "", false
+	case "level":
+		max := 8
+		if d.Maximum != nil {
+			max = int(*d.Maximum)
+		}
+		if len(edt) != 1 || int(edt[0]) < d.Base || int(edt[0]) >= d.Base+max {
+			return "", false
+		}
+		return strconv.Itoa(int(edt[0]) - d.Base + 1), true
+	case "date":
+		t, ok := DecodeDate(edt)
+		return t.Format("2006-01-02"), ok
+	case "time":
+		t, ok := DecodeTime(edt)
+		s := fmt.Sprintf("%02d:%02d", int(t.Hours()), int(t.Minutes())%60)
+		if len(edt) == 3 {
+			s += fmt.Sprintf(":%02d", int(t.Seconds())%60)
+		}
+		return s, ok
+	case "date-time":
+		if len(edt) != 7 {
+			return "", false
+		}
+		date, ok1 := DecodeDate(edt[:4])
+		t, ok2 := DecodeTime(edt[4:])
+		return date.Add(t).Format("2006-01-02T15:04:05"), ok1 && ok2
+	case "array":
+		size := d.ItemSize
+		if size == 0 && d.Items != nil {
+			size = d.Items.size()
+		}
+		if d.Items == nil || size == 0 || len(edt)%size != 0 {
+			break
+		}
+		var items []string
+		for i := 0; i < len(edt); i += size {
+			v, ok := d.Items.Decode(edt[i : i+size])
+			if !ok {
+				return "", false
+			}
+			items = append(items, v)
+		}
+		return "[" + strings.Join(items, ",") + "]", true
+	case "object":
+		var elems []string
+		for i, e := range d.Elements {
+			size := e.Data.size()
+			if size == 0 && i == len(d.Elements)-1 {
+				size = len(edt) // the rest
+			}
+			if size == 0 || size > len(edt) {
+				return hex.EncodeToString(edt), true
+			}
+			v, ok := e.Data.Decode(edt[:size])
+			if !ok {
+				v = hex.EncodeToString(edt[:size])
+			}
+			elems = append(elems, e.Name+"="+v)
+			edt = edt[size:]
+		}
+		return "{" + strings.Join(elems, ",") + "}", true
+	}
+	if len(d.OneOf) > 0 {
+		for _, o := range d.OneOf {
+			if v, ok := o.Decode(edt); ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+	// raw, bitmap and unknown types
+	if d.Size > 0 && len(edt) != d.Size {
+		return "", false
+	}
+	return hex.EncodeToString(edt), true
+}
+
+// Encode returns the EDT of the value: a number, the name or English
+// description of a state, a level from 1, or hex.
+func (d *MRAData) Encode(value string) ([]byte, error) {
+	switch d.Type {
+	case "number":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(v) {
+			return nil, fmt.Errorf("not a number: %s", value)
+		}
+		if !d.inRange(v) {
+			return nil, fmt.Errorf("out of range: %s", value)
+		}
+		return d.numberType().Encode(v)
+	case "state", "numericValue":
+		for _, e := range d.Enum {
+			if strings.EqualFold(value, e.Name) ||
+				strings.EqualFold(value, e.Description) {
+				return e.EDT, nil
+			}
+			if e.Value != nil && value == strconv.FormatFloat(*e.Value, 'f', -1, 64) {
+				return e.EDT, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown value: %s", value)
+	case "level":
+		max := 8
+		if d.Maximum != nil {
+			max = int(*d.Maximum)
+		}
+		level, err := strconv.Atoi(value)
+		if err != nil || level < 1 || level > max {
+			return nil, fmt.Errorf("invalid level: %s (must be 1-%d)", value, max)
+		}
+		return []byte{byte(d.Base + level - 1)}, nil
+	}
+	if len(d.OneOf) > 0 {
+		var errs []error
+		for _, o := range d.OneOf {
+			edt, err := o.Encode(value)
+			if err == nil {
+				return edt, nil
+			}
+			errs = append(errs, err)
+		}
+		return nil, errors.Join(errs...)
+	}
+	edt, err := decodeHexValue(value)
+	if err == nil && d.Size > 0 && len(edt) != d.Size {
+		err = fmt.Errorf("size %d expect %d", len(edt), d.Size)
+	}
+	return edt, err
+}
+
+// decodeHexValue decodes the EDT given in hex, with optional 0x.
+func decodeHexValue(value string) ([]byte, error) {
+	edt, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(value), "0x"))
+	if err != nil || len(edt) == 0 {
+		return nil, fmt.Errorf("invalid hex: %s", value)
+	}
+	return edt, nil
+}
@@ -0,0 +1,129 @@
+package echonet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTestMRA(t *testing.T) *MRA {
+	m, err := LoadMRA("testdata/mra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseMRA(m)
+	t.Cleanup(func() { UseMRA(nil) })
+	return m
+}
+
+func TestMRA(t *testing.T) {
+	m := loadTestMRA(t)
+	if c := m.Class(0x0130); c == nil || c.ShortName != "homeAirConditioner" {
+		t.Fatalf("class %+v", c)
+	}
+
+	for _, tc := range []struct {
+		epc   byte
+		edt   []byte
+		value string
+		ok    bool
+	}{
+		{EPC_POWER, []byte{0x30}, "true", true},
+		{EPC_POWER, []byte{0x32}, "", false},
+		{EPC_WATT, []byte{0x01, 0xf4}, "500", true},
+		{EPC_MODE, []byte{0x42}, "cooling", true}, // the latest release
+		{EPC_TARGET_TEMP, []byte{26}, "26", true},
+		{EPC_TARGET_TEMP, []byte{0xfd}, "undefined", true},
+		{EPC_TARGET_TEMP, []byte{51}, "", false},
+		{EPC_ROOM_TEMP, []byte{0xfb}, "-5", true},
+		{EPC_ROOM_TEMP, []byte{0x7e}, "unmeasurable", true},
+		{EPC_FAN, []byte{0x33}, "3", true},
+		{EPC_FAN, []byte{0x41}, "auto", true},
+		{0x98, []byte{0x07, 0xea, 10, 18}, "2026-10-18", true},
+	} {
+		p := m.Property(0x013001, tc.epc)
+		if p == nil {
+			t.Fatalf("no property %02x", tc.epc)
+		}
+		v, ok := p.Decode(tc.edt)
+		if ok != tc.ok || (ok && v != tc.value) {
+			t.Errorf("%s % x: %q %v expect %q", p.ShortName, tc.edt, v, ok,
+				tc.value)
+			continue
+		}
+		if !ok || p.Data.Type == "date" {
+			continue
+		}
+		edt, err := p.Encode(tc.value)
+		if err != nil || !bytes.Equal(edt, tc.edt) {
+			t.Errorf("%s %q: % x %v expect % x", p.ShortName, tc.value, edt,
+				err, tc.edt)
+		}
+	}
+
+	p := m.PropertyByName(0x013001, "targetTemperature")
+	if p == nil || p.EPC != EPC_TARGET_TEMP || !p.Set {
+		t.Fatalf("targetTemperature %+v", p)
+	}
+	if _, err := p.Encode("60"); err == nil {
+		t.Error("encode 60: expect error")
+	}
+	if edt, err := m.Property(0x013001, EPC_POWER).Encode("ON"); err != nil ||
+		edt[0] != EDT_ON {
+		t.Errorf("encode ON: % x %v", edt, err)
+	}
+	if p := m.Property(0x029001, EPC_POWER); p == nil {
+		t.Error("no super class property for 0290")
+	}
+
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(0x013001)
+	pkt.SetDeoj(ECHONET_EOJ_NODE)
+	pkt.SetEsv(ESV_GET_RES)
+	pkt.AddProperty(EPC_MODE, 0x43)
+	pkt.AddProperty(EPC_ROOM_TEMP, 0x1a)
+	pkt.AddProperty(0xf0, 0x01)
+	if s := pkt.Decode(); !strings.HasSuffix(s,
+		"Get_Res OperationMode=heat RoomTemp=26°C 0xf0=01") {
+		t.Errorf("packet %s", s)
+	}
+}
+
+func TestEchonetGeneric(t *testing.T) {
+	loadTestMRA(t)
+	en, sim := startEchonet(t, NewSimAircon(1))
+	obj, err := en.NewObject(Config{Type: "generic", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the property maps, then the properties in them
+	if err := obj.State(); err != nil {
+		t.Fatal(err)
+	}
+	var props map[byte][]byte
+	for i := 0; i < 50; i++ {
+		props = obj.GetProperties()
+		if props[EPC_ROOM_TEMP] != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if edt := props[EPC_ROOM_TEMP]; len(edt) != 1 || edt[0] != 28 {
+		t.Fatalf("room temperature % x", edt)
+	}
+
+	p := DefaultMRA().PropertyByName(obj.GetEoj(), "operationMode")
+	edt, err := p.Encode("heating")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetProperty(p.EPC, edt); err != nil {
+		t.Fatal(err)
+	}
+	if edt := sim.Get(0x013001, EPC_MODE); edt[0] != 0x43 {
+		t.Errorf("mode %x expect 43", edt)
+	}
+}
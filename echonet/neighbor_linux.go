@@ -0,0 +1,64 @@
+//go:build linux
+
+package echonet
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// lookupMac returns the MAC address of the neighbor from the ARP
+// table, or the IPv6 neighbor table, nil if unknown. Replaced in tests.
+var lookupMac = func(ip net.IP) net.HardwareAddr {
+	if ip.To4() == nil {
+		out, err := exec.Command("ip", "-6", "neigh", "show", ip.String()).Output()
+		if err != nil {
+			return nil
+		}
+		return parseNeighbor(string(out))
+	}
+
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	// IP address, HW type, Flags, HW address, Mask, Device
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !ip.Equal(net.ParseIP(fields[0])) {
+			continue
+		}
+		if fields[2] == "0x0" {
+			return nil // incomplete
+		}
+		mac, err := net.ParseMAC(fields[3])
+		if err != nil {
+			return nil
+		}
+		return mac
+	}
+	return nil
+}
+
+// parseNeighbor returns the link layer address of the output of ip
+// neigh, e.g. "fe80::2 dev eth0 lladdr 02:00:00:00:00:02 REACHABLE".
+func parseNeighbor(out string) net.HardwareAddr {
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] != "lladdr" {
+				continue
+			}
+			if mac, err := net.ParseMAC(fields[i+1]); err == nil {
+				return mac
+			}
+		}
+	}
+	return nil
+}
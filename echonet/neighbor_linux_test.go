@@ -0,0 +1,18 @@
+//go:build linux
+
+package echonet
+
+import "testing"
+
+func TestParseNeighbor(t *testing.T) {
+	for out, expect := range map[string]string{
+		"fe80::2 dev eth0 lladdr 02:00:00:00:00:02 router REACHABLE\n": "02:00:00:00:00:02",
+		"fe80::2 dev eth0 lladdr 02:00:00:00:00:02 STALE\n":            "02:00:00:00:00:02",
+		"fe80::2 dev eth0 FAILED\n":                                    "",
+		"":                                                             "",
+	} {
+		if mac := parseNeighbor(out); mac.String() != expect {
+			t.Errorf("%q: %s expect %s", out, mac, expect)
+		}
+	}
+}
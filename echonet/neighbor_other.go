@@ -0,0 +1,10 @@
+//go:build !linux
+
+package echonet
+
+import "net"
+
+// lookupMac is not supported, devices are found by id only.
+var lookupMac = func(ip net.IP) net.HardwareAddr {
+	return nil
+}
@@ -0,0 +1,131 @@
+package echonet
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// node on the network and the objects it hosts
+type node struct {
+	addr    *net.UDPAddr
+	id      []byte // identification number of the node profile
+	maker   []byte // manufacturer code
+	serial  []byte // serial number, if the node has it
+	mac     net.HardwareAddr
+	no_mac  time.Time // last lookup of the MAC address failed
+	objects []*EchonetObject
+}
+
+// isGroup returns whether the EOJ addresses all instances of the class
+// (instance code 0x00).
+func isGroup(eoj uint32) bool {
+	return eoj&0xff == 0
+}
+
+// node returns the node at the address, created if unknown. The caller
+// holds node_mutex.
+func (en *Echonet) node(addr *net.UDPAddr) *node {
+	key := addr.IP.String()
+	n, ok := en.nodes[key]
+	if !ok {
+		n = &node{addr: &net.UDPAddr{IP: addr.IP, Port: ECHONET_PORT,
+			Zone: addr.Zone}}
+		en.nodes[key] = n
+	}
+	return n
+}
+
+// attach moves the object to the node at the address.
+func (en *Echonet) attach(obj *EchonetObject, addr *net.UDPAddr) {
+	en.node_mutex.Lock()
+	defer en.node_mutex.Unlock()
+
+	en.detachLocked(obj)
+	n := en.node(addr)
+	n.objects = append(n.objects, obj)
+	obj.addr.Store(addr)
+}
+
+// detach removes the object from its node.
+func (en *Echonet) detach(obj *EchonetObject) {
+	en.node_mutex.Lock()
+	en.detachLocked(obj)
+	en.node_mutex.Unlock()
+}
+
+func (en *Echonet) detachLocked(obj *EchonetObject) {
+	old := obj.addr.Load()
+	if old == nil {
+		return
+	}
+	key := old.IP.String()
+	n, ok := en.nodes[key]
+	if !ok {
+		return
+	}
+	n.objects = slices.DeleteFunc(n.objects,
+		func(o *EchonetObject) bool { return o == obj })
+	if len(n.objects) == 0 && n.id == nil {
+		delete(en.nodes, key) // nothing left to know
+	}
+}
+
+// dispatch returns the objects of the node at the address that receive
+// a packet from the object seoj: the instance itself, and the objects
+// addressing all instances of its class.
+func (en *Echonet) dispatch(ip net.IP, seoj uint32) (objs, groups []*EchonetObject) {
+	en.node_mutex.Lock()
+	defer en.node_mutex.Unlock()
+
+	n, ok := en.nodes[ip.String()]
+	if !ok {
+		return nil, nil
+	}
+	for _, obj := range n.objects {
+		switch {
+		case obj.eoj == seoj:
+			objs = append(objs, obj)
+		case isGroup(obj.eoj) && obj.eoj>>8 == seoj>>8:
+			groups = append(groups, obj)
+		}
+	}
+	return objs, groups
+}
+
+// nodeObjects returns the objects of the node at the address.
+func (en *Echonet) nodeObjects(ip net.IP) []*EchonetObject {
+	en.node_mutex.Lock()
+	defer en.node_mutex.Unlock()
+
+	if n, ok := en.nodes[ip.String()]; ok {
+		return slices.Clone(n.objects)
+	}
+	return nil
+}
+
+// nodeAnnounced gets the state of the objects of a node announcing its
+// instance list, as it does after starting up.
+func (en *Echonet) nodeAnnounced(addr *net.UDPAddr, pkt *EchonetPacket) {
+	if pkt.GetSeoj()>>8 != ECHONET_EOJ_NODE>>8 ||
+		(pkt.GetEsv() != ESV_INF && pkt.GetEsv() != ESV_INFC) ||
+		!slices.ContainsFunc(pkt.Props, func(p EchonetProperty) bool {
+			return p.EPC == EPC_NODE_INS_INF
+		}) {
+		return
+	}
+	objs := en.nodeObjects(addr.IP)
+	if len(objs) == 0 {
+		return
+	}
+
+	en.Logger.Info("node announced", "addr", addr.IP.String(),
+		"objects", len(objs))
+	go func() {
+		for _, obj := range objs {
+			if err := obj.State(); err != nil {
+				obj.logger.Warn("state failed", "err", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,317 @@
+package echonet
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder writes the frames sent and received to a JSONL or pcapng
+// file. The first write error stops the recording.
+type Recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+	pcap  *PcapngWriter // nil for JSONL
+	err   error
+}
+
+// line of a JSONL recording
+type recordLine struct {
+	Time   time.Time `json:"time"`
+	Dir    string    `json:"dir"` // "send" or "recv"
+	Src    string    `json:"src"`
+	Dst    string    `json:"dst"`
+	Data   string    `json:"data"`             // hex
+	Packet string    `json:"packet,omitempty"` // decoded, for humans
+}
+
+// RecordFormat returns the format of the recording by the extension
+// of the file: "pcapng" for .pcapng, "pcap" for .pcap, which is only
+// read, and "jsonl" otherwise.
+func RecordFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pcapng":
+		return "pcapng"
+	case ".pcap":
+		return "pcap"
+	}
+	return "jsonl"
+}
+
+// ErrPcapRecord is returned for recording to a .pcap file: its single
+// header cannot be repeated when the recording is appended to.
+var ErrPcapRecord = errors.New("pcap cannot be appended to, record to .pcapng instead")
+
+// NewRecorder records to w in the format, "jsonl" or "pcapng".
+func NewRecorder(w io.Writer, format string) (*Recorder, error) {
+	r := &Recorder{w: w}
+	switch format {
+	case "jsonl":
+	case "pcap":
+		return nil, ErrPcapRecord
+	case "pcapng":
+		var err error
+		r.pcap, err = NewPcapngWriter(w)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown recording format: %s", format)
+	}
+	return r, nil
+}
+
+// Record writes the frame.
+func (r *Recorder) Record(f RecordedFrame) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err != nil {
+		return
+	}
+	if r.pcap != nil {
+		r.err = r.pcap.Write(f)
+		return
+	}
+
+	line := recordLine{
+		Time: f.Time,
+		Dir:  "recv",
+		Src:  f.Src.String(),
+		Dst:  f.Dst.String(),
+		Data: hex.EncodeToString(f.Data),
+	}
+	if f.Outbound {
+		line.Dir = "send"
+	}
+	pkt := NewEchonetPacket()
+	if pkt.Parse(f.Data) == nil {
+		line.Packet = pkt.Decode()
+	}
+	b, err := json.Marshal(line)
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	r.err = err
+}
+
+// Err returns the error that stopped the recording, nil if none.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// Wrap returns the transport recording the frames of t.
+func (r *Recorder) Wrap(t Transport) Transport {
+	return &recordingTransport{Transport: t, rec: r}
+}
+
+type recordingTransport struct {
+	Transport
+	rec *Recorder
+}
+
+func (t *recordingTransport) local() *net.UDPAddr {
+	if a, ok := t.LocalAddr().(*net.UDPAddr); ok {
+		return a
+	}
+	return &net.UDPAddr{IP: net.IPv4zero, Port: ECHONET_PORT}
+}
+
+func (t *recordingTransport) Send(b []byte, dst *net.UDPAddr) error {
+	err := t.Transport.Send(b, dst)
+	if err == nil {
+		t.rec.Record(RecordedFrame{Time: time.Now(), Outbound: true,
+			Src: t.local(), Dst: dst, Data: slices.Clone(b)})
+	}
+	return err
+}
+
+func (t *recordingTransport) Multicast(b []byte) error {
+	err := t.Transport.Multicast(b)
+	if err == nil {
+		group := &net.UDPAddr{IP: net.ParseIP(ECHONET_MULTICAST),
+			Port: ECHONET_PORT}
+		t.rec.Record(RecordedFrame{Time: time.Now(), Outbound: true,
+			Src: t.local(), Dst: group, Data: slices.Clone(b)})
+	}
+	return err
+}
+
+func (t *recordingTransport) Recv(buf []byte, deadline time.Time) (int, *net.UDPAddr, net.IP, error) {
+	n, src, dst, err := t.Transport.Recv(buf, deadline)
+	if err == nil {
+		to := *t.local()
+		if dst != nil {
+			to.IP = dst
+		}
+		t.rec.Record(RecordedFrame{Time: time.Now(), Src: src, Dst: &to,
+			Data: slices.Clone(buf[:n])})
+	}
+	return n, src, dst, err
+}
+
+// LoadRecording reads a JSONL recording or a pcap or pcapng file.
+func LoadRecording(path string) ([]RecordedFrame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	var frames []RecordedFrame
+	if b, err := br.Peek(1); err == nil && b[0] == '{' {
+		dec := json.NewDecoder(br)
+		for dec.More() {
+			var line recordLine
+			if err := dec.Decode(&line); err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			frame, err := line.frame()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err)
+			}
+			frames = append(frames, frame)
+		}
+		return frames, nil
+	}
+
+	err = ReadCapture(br, func(f RecordedFrame) error {
+		if f.Src.Port == ECHONET_PORT || f.Dst.Port == ECHONET_PORT {
+			frames = append(frames, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return frames, nil
+}
+
+func (line recordLine) frame() (RecordedFrame, error) {
+	f := RecordedFrame{Time: line.Time, Outbound: line.Dir == "send"}
+	var err error
+	if f.Src, err = net.ResolveUDPAddr("udp", line.Src); err != nil {
+		return f, err
+	}
+	if f.Dst, err = net.ResolveUDPAddr("udp", line.Dst); err != nil {
+		return f, err
+	}
+	if f.Data, err = hex.DecodeString(line.Data); err != nil {
+		return f, fmt.Errorf("invalid data: %s", err)
+	}
+	return f, nil
+}
+
+// ReplayTransport delivers the frames received in a recording, without
+// a network. The frames sent are dropped.
+type ReplayTransport struct {
+	Speed  float64 // 1 for the recorded pace, 0 as fast as possible
+	frames []RecordedFrame
+	local  *net.UDPAddr
+	mutex  sync.Mutex
+	next   int
+	start  time.Time // of the replay
+	closed chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	last   sync.Once
+}
+
+// NewReplayTransport replays the frames not sent by the recording
+// node.
+func NewReplayTransport(frames []RecordedFrame) *ReplayTransport {
+	t := &ReplayTransport{
+		local:  &net.UDPAddr{IP: net.IPv4zero, Port: ECHONET_PORT},
+		closed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, f := range frames {
+		if f.Outbound {
+			t.local = f.Src
+			continue
+		}
+		t.frames = append(t.frames, f)
+	}
+	return t
+}
+
+// Done is closed when the frames have been received and handled, i.e.
+// Recv is called after the last one.
+func (t *ReplayTransport) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *ReplayTransport) Send(b []byte, dst *net.UDPAddr) error {
+	return nil
+}
+
+func (t *ReplayTransport) Multicast(b []byte) error {
+	return nil
+}
+
+func (t *ReplayTransport) Recv(buf []byte, deadline time.Time) (int, *net.UDPAddr, net.IP, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	if t.next == len(t.frames) {
+		t.last.Do(func() { close(t.done) })
+		select {
+		case <-timeout:
+			return 0, nil, nil, os.ErrDeadlineExceeded
+		case <-t.closed:
+			return 0, nil, nil, net.ErrClosed
+		}
+	}
+
+	f := t.frames[t.next]
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if t.Speed > 0 {
+		offset := f.Time.Sub(t.frames[0].Time)
+		wait := time.Until(t.start.Add(time.Duration(float64(offset) / t.Speed)))
+		if wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-timeout:
+				return 0, nil, nil, os.ErrDeadlineExceeded
+			case <-t.closed:
+				return 0, nil, nil, net.ErrClosed
+			}
+		}
+	}
+	select {
+	case <-t.closed:
+		return 0, nil, nil, net.ErrClosed
+	default:
+	}
+	t.next += 1
+	return copy(buf, f.Data), f.Src, f.Dst.IP, nil
+}
+
+func (t *ReplayTransport) LocalAddr() net.Addr {
+	return t.local
+}
+
+func (t *ReplayTransport) Close() error {
+	t.once.Do(func() { close(t.closed) })
+	return nil
+}
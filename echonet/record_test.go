@@ -0,0 +1,146 @@
+package echonet
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordReplay(t *testing.T) {
+	for _, name := range []string{"traffic.jsonl", "traffic.pcapng"} {
+		path := filepath.Join(t.TempDir(), name)
+		record(t, path)
+
+		frames, err := LoadRecording(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sent := 0
+		for _, f := range frames {
+			if f.Outbound {
+				sent += 1
+			}
+		}
+		// Set, Get and Get of the optional properties, answered, and
+		// the change of the mode announced
+		if sent != 3 || len(frames) != 7 {
+			t.Fatalf("%s: %d frames, %d sent", name, len(frames), sent)
+		}
+
+		tr := NewReplayTransport(frames)
+		en, err := NewEchonetOptions(Options{Transport: tr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		en.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		en.Interval = 0
+		go func() {
+			for range en.RecvChan {
+			}
+		}()
+		obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+			Addr: "10.0.0.2", Eoj: "013001"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := en.Start(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-tr.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("%s: replay not done", name)
+		}
+		en.Close()
+
+		if m := obj.GetMode(); m != "heat" {
+			t.Errorf("%s: mode %s expect heat", name, m)
+		}
+		if v, ok := obj.GetRoomTemp(); !ok || v != 28 {
+			t.Errorf("%s: room temperature %d %v", name, v, ok)
+		}
+	}
+}
+
+// record records setting the mode of the aircon of a simulator.
+func record(t *testing.T, path string) {
+	var buf bytes.Buffer
+	rec, err := NewRecorder(&buf, RecordFormat(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := NewMemoryNetwork()
+	sim := NewSimulator("10.0.0.2:3610")
+	sim.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	sim.Transport, err = network.Attach(sim.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim.Add(NewSimAircon(1))
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	tr, err := network.Attach("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	en, err := NewEchonetOptions(Options{Transport: tr, Recorder: rec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	en.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	en.Interval = 0
+	go func() {
+		for range en.RecvChan {
+		}
+	}()
+	if err := en.Start(); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := en.NewObject(Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.SetMode("heat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	// the optional properties, read once the property maps are known
+	for i := 0; i < 50 && obj.GetAirconProperties()["energy"] == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	en.Close()
+
+	if err := rec.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordFormat(t *testing.T) {
+	for path, expect := range map[string]string{
+		"a.jsonl":  "jsonl",
+		"a.log":    "jsonl",
+		"a.pcapng": "pcapng",
+		"A.PCAP":   "pcap",
+	} {
+		if f := RecordFormat(path); f != expect {
+			t.Errorf("%s: format %s expect %s", path, f, expect)
+		}
+	}
+	if _, err := NewRecorder(io.Discard, "pcap"); err != ErrPcapRecord {
+		t.Errorf("pcap recorder error %v", err)
+	}
+}
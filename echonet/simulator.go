@@ -0,0 +1,479 @@
+package echonet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math/rand"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SIM_TICK  = 10 * time.Second // default interval of state transitions
+	SIM_MAKER = 0xffffff         // manufacturer code, unregistered
+)
+
+// Faults injected by the simulator.
+type Faults struct {
+	Drop      float64       // probability to ignore a request
+	Delay     time.Duration // delay of responses
+	SNA       []byte        // EPCs always denied
+	Malformed float64       // probability to truncate a response
+}
+
+// SimObject is a virtual ECHONET object hosted by the simulator.
+type SimObject struct {
+	Eoj    uint32
+	props  map[byte][]byte       // EDT by EPC
+	set    []byte                // settable EPCs
+	inf    []byte                // EPCs announced on change
+	ranges map[byte][][2]byte    // valid values of 1 byte EDTs
+	update func(epc byte)        // called after a property is set
+	tick   func(d time.Duration) // state transitions over time
+}
+
+// Simulator hosts virtual ECHONET objects on an address.
+type Simulator struct {
+	Addr   string        // listen address, e.g. "127.0.0.2:3610"
+	Peer   string        // send responses and INF here, e.g. on loopback
+	Tick   time.Duration // interval of state transitions
+	Logger *slog.Logger  // packets are logged at debug level
+	// Transport overrides UDP on Addr, e.g. in tests.
+	Transport Transport
+	mutex     sync.Mutex
+	node      *SimObject // node profile
+	objects   []*SimObject
+	faults    Faults
+	tid       uint16
+	rand      *rand.Rand
+	done      chan struct{}
+}
+
+func NewSimulator(addr string) *Simulator {
+	sim := &Simulator{
+		Addr:   addr,
+		Tick:   SIM_TICK,
+		Logger: slog.Default().With("subsystem", "simulator"),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		done:   make(chan struct{}),
+	}
+	sim.node = newSimNode()
+	sim.objects = []*SimObject{sim.node}
+	return sim
+}
+
+// Add hosts the object; the instance must be unique within the class.
+func (sim *Simulator) Add(obj *SimObject) error {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	for _, o := range sim.objects {
+		if o.Eoj == obj.Eoj {
+			return fmt.Errorf("duplicate EOJ %06x", obj.Eoj)
+		}
+	}
+	obj.props[EPC_ID] = simId(sim.Addr, obj.Eoj)
+	sim.objects = append(sim.objects, obj)
+	sim.updateNode()
+	return nil
+}
+
+// SetFaults changes the injected faults.
+func (sim *Simulator) SetFaults(f Faults) {
+	sim.mutex.Lock()
+	sim.faults = f
+	sim.mutex.Unlock()
+}
+
+// Get returns the EDT of the property, or nil.
+func (sim *Simulator) Get(eoj uint32, epc byte) []byte {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	for _, obj := range sim.objects {
+		if obj.Eoj == eoj {
+			return slices.Clone(obj.props[epc])
+		}
+	}
+	return nil
+}
+
+// Set changes the property as if operated on the device itself, and
+// announces the change.
+func (sim *Simulator) Set(eoj uint32, epc byte, edt ...byte) error {
+	sim.mutex.Lock()
+	var obj *SimObject
+	for _, o := range sim.objects {
+		if o.Eoj == eoj {
+			obj = o
+		}
+	}
+	if obj == nil {
+		sim.mutex.Unlock()
+		return fmt.Errorf("unknown EOJ %06x", eoj)
+	}
+	var inf []byte
+	if obj.store(epc, edt) {
+		inf = sim.announcement(obj, []byte{epc})
+	}
+	sim.mutex.Unlock()
+
+	sim.send(inf, nil)
+	return nil
+}
+
+// Start listens on the address. If the host is unspecified, the
+// simulator joins the multicast group to be discovered.
+func (sim *Simulator) Start() error {
+	if sim.Transport == nil {
+		host, port, err := net.SplitHostPort(sim.Addr)
+		if err != nil {
+			return err
+		}
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return fmt.Errorf("invalid port: %s", port)
+		}
+		sim.Transport, err = NewUDPTransport(host, p, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	go sim.receiver()
+	go sim.ticker()
+
+	sim.mutex.Lock()
+	inf := sim.announcement(sim.node, []byte{EPC_NODE_INS_INF})
+	sim.mutex.Unlock()
+	sim.send(inf, nil)
+
+	return nil
+}
+
+// LocalAddr returns the address the simulator listens on.
+func (sim *Simulator) LocalAddr() net.Addr {
+	return sim.Transport.LocalAddr()
+}
+
+func (sim *Simulator) Close() error {
+	select {
+	case <-sim.done:
+	default:
+		close(sim.done)
+	}
+	return sim.Transport.Close()
+}
+
+func (sim *Simulator) receiver() {
+	buf := make([]byte, 1500)
+	for {
+		length, src, _, err := sim.Transport.Recv(buf, time.Time{})
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			select {
+			case <-sim.done:
+				return
+			default:
+				sim.Logger.Warn("receive failed", "err", err)
+				continue
+			}
+		}
+		sim.receive(buf[:length], src)
+	}
+}
+
+func (sim *Simulator) ticker() {
+	t := time.NewTicker(sim.Tick)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-sim.done:
+			return
+		case <-t.C:
+		}
+
+		var infs [][]byte
+		sim.mutex.Lock()
+		for _, obj := range sim.objects {
+			if obj.tick == nil {
+				continue
+			}
+			before := obj.snapshot()
+			obj.tick(sim.Tick)
+			if inf := sim.announcement(obj, obj.changed(before)); inf != nil {
+				infs = append(infs, inf)
+			}
+		}
+		sim.mutex.Unlock()
+
+		for _, inf := range infs {
+			sim.send(inf, nil)
+		}
+	}
+}
+
+// receive answers the request from src.
+func (sim *Simulator) receive(data []byte, src *net.UDPAddr) {
+	req := NewEchonetPacket()
+	if err := req.Parse(data); err != nil {
+		sim.Logger.Debug("invalid packet", "src", src, "err", err)
+		return
+	}
+	esv := req.GetEsv()
+	if esv != ESV_SETI && esv != ESV_SETC && esv != ESV_GET &&
+		esv != ESV_INF_REQ && esv != ESV_SETGET {
+		return // not a request
+	}
+	sim.Logger.Debug("recv", "src", src, "packet", req.String())
+
+	set, get := req.Props, req.Props
+	if esv == ESV_SETGET {
+		get = req.GetProps
+	}
+
+	sim.mutex.Lock()
+	faults := sim.faults
+	if sim.rand.Float64() < faults.Drop {
+		sim.mutex.Unlock()
+		sim.Logger.Debug("drop", "src", src, "packet", req.String())
+		return
+	}
+	var responses, infs [][]byte
+	for _, obj := range sim.match(req.GetDeoj()) {
+		res, changed := obj.handle(req, set, get, faults.SNA)
+		if res != nil {
+			if sim.rand.Float64() < faults.Malformed {
+				res = res[:len(res)-1]
+			}
+			responses = append(responses, res)
+		}
+		if inf := sim.announcement(obj, changed); inf != nil {
+			infs = append(infs, inf)
+		}
+	}
+	sim.mutex.Unlock()
+
+	dst := &net.UDPAddr{IP: src.IP, Port: ECHONET_PORT, Zone: src.Zone}
+	send := func() {
+		for _, res := range responses {
+			sim.send(res, dst)
+		}
+		for _, inf := range infs {
+			sim.send(inf, nil)
+		}
+	}
+	if faults.Delay > 0 {
+		time.AfterFunc(faults.Delay, send)
+	} else {
+		send()
+	}
+}
+
+// match returns the objects addressed by the EOJ; instance 0 means
+// all instances of the class.
+func (sim *Simulator) match(deoj uint32) []*SimObject {
+	var objs []*SimObject
+	for _, obj := range sim.objects {
+		if obj.Eoj == deoj ||
+			(deoj&0xff == 0 && obj.Eoj&0xffff00 == deoj) {
+			objs = append(objs, obj)
+		}
+	}
+	return objs
+}
+
+// announcement returns the INF of the changed properties to announce.
+func (sim *Simulator) announcement(obj *SimObject, changed []byte) []byte {
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(obj.Eoj)
+	pkt.SetDeoj(ECHONET_EOJ_NODE)
+	pkt.SetEsv(ESV_INF)
+	for _, epc := range changed {
+		if slices.Contains(obj.inf, epc) {
+			pkt.AddProperty(epc, obj.props[epc]...)
+		}
+	}
+	if pkt.OPC == 0 {
+		return nil
+	}
+	sim.tid += 1
+	pkt.SetTid(sim.tid)
+	return pkt.Bytes()
+}
+
+// send writes the packet to dst, or to the multicast group if nil;
+// Peer overrides both.
+func (sim *Simulator) send(b []byte, dst *net.UDPAddr) {
+	if b == nil {
+		return
+	}
+	var err error
+	if sim.Peer != "" {
+		dst, err = net.ResolveUDPAddr("udp", sim.Peer)
+		if err != nil {
+			sim.Logger.Warn("send failed", "err", err)
+			return
+		}
+	}
+	to := ECHONET_MULTICAST
+	if dst != nil {
+		to = dst.String()
+		err = sim.Transport.Send(b, dst)
+	} else {
+		err = sim.Transport.Multicast(b)
+	}
+	if err != nil {
+		sim.Logger.Warn("send failed", "dst", to, "err", err)
+		return
+	}
+	if sim.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		pkt := NewEchonetPacket()
+		if pkt.Parse(b) == nil {
+			sim.Logger.Debug("send", "dst", to, "packet", pkt.String())
+		} else {
+			sim.Logger.Debug("send", "dst", to, "data", fmt.Sprintf("%x", b))
+		}
+	}
+}
+
+// handle processes the request and returns the response, nil if none
+// is due, and the properties changed.
+func (obj *SimObject) handle(req *EchonetPacket, set, get []EchonetProperty,
+	sna []byte) ([]byte, []byte) {
+
+	res := NewEchonetPacket()
+	res.SetTid(req.GetTid())
+	res.SetSeoj(obj.Eoj)
+	res.SetDeoj(req.GetSeoj())
+
+	var changed []byte
+	ok := true
+	write := func() []EchonetProperty {
+		var props []EchonetProperty
+		for _, prop := range set {
+			if slices.Contains(sna, prop.EPC) || !obj.valid(prop.EPC, prop.EDT) {
+				props = append(props, prop) // denied, echo the EDT
+				ok = false
+				continue
+			}
+			if obj.store(prop.EPC, prop.EDT) {
+				changed = append(changed, prop.EPC)
+			}
+			if obj.update != nil {
+				obj.update(prop.EPC)
+			}
+			props = append(props, EchonetProperty{EPC: prop.EPC})
+		}
+		return props
+	}
+	read := func() []EchonetProperty {
+		var props []EchonetProperty
+		for _, prop := range get {
+			edt, found := obj.props[prop.EPC]
+			if slices.Contains(sna, prop.EPC) || !found {
+				props = append(props, EchonetProperty{EPC: prop.EPC})
+				ok = false
+				continue
+			}
+			props = append(props, EchonetProperty{EPC: prop.EPC,
+				PDC: byte(len(edt)), EDT: slices.Clone(edt)})
+		}
+		return props
+	}
+	reply := func(props []EchonetProperty, esv, sna byte) {
+		res.SetEsv(esv)
+		if !ok {
+			res.SetEsv(sna)
+		}
+		for _, prop := range props {
+			res.AddProperty(prop.EPC, prop.EDT...)
+		}
+	}
+
+	switch req.GetEsv() {
+	case ESV_GET:
+		reply(read(), ESV_GET_RES, ESV_GET_SNA)
+	case ESV_INF_REQ:
+		reply(read(), ESV_INF, ESV_INF_SNA)
+	case ESV_SETC:
+		reply(write(), ESV_SET_RES, ESV_SETC_SNA)
+	case ESV_SETI:
+		reply(write(), 0, ESV_SETI_SNA)
+		if ok {
+			return nil, changed
+		}
+	case ESV_SETGET:
+		setProps, getProps := write(), read()
+		reply(setProps, ESV_SETGET_RES, ESV_SETGET_SNA)
+		for _, prop := range getProps {
+			res.AddGetProperty(prop.EPC, prop.EDT...)
+		}
+	}
+	return res.Bytes(), changed
+}
+
+// valid reports whether the property can be set to the EDT.
+func (obj *SimObject) valid(epc byte, edt []byte) bool {
+	cur, found := obj.props[epc]
+	if !found || !slices.Contains(obj.set, epc) || len(edt) != len(cur) {
+		return false
+	}
+	ranges, ok := obj.ranges[epc]
+	if !ok {
+		return true
+	}
+	for _, r := range ranges {
+		if edt[0] >= r[0] && edt[0] <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// store sets the property and returns true if it changed.
+func (obj *SimObject) store(epc byte, edt []byte) bool {
+	if slices.Equal(obj.props[epc], edt) {
+		return false
+	}
+	obj.props[epc] = slices.Clone(edt)
+	return true
+}
+
+func (obj *SimObject) snapshot() map[byte][]byte {
+	m := map[byte][]byte{}
+	for epc, edt := range obj.props {
+		m[epc] = slices.Clone(edt)
+	}
+	return m
+}
+
+func (obj *SimObject) changed(before map[byte][]byte) []byte {
+	var epcs []byte
+	for epc, edt := range obj.props {
+		if !slices.Equal(before[epc], edt) {
+			epcs = append(epcs, epc)
+		}
+	}
+	slices.Sort(epcs)
+	return epcs
+}
+
+// simId returns the identification number (0x83) of the object.
+func simId(addr string, eoj uint32) []byte {
+	h := fnv.New64a()
+	h.Write([]byte(addr))
+	id := []byte{0xfe, SIM_MAKER >> 16, (SIM_MAKER >> 8) & 0xff, SIM_MAKER & 0xff}
+	id = h.Sum(id)
+	id = append(id, 0x00, 0x00)
+	return append(id, byte(eoj>>16), byte(eoj>>8), byte(eoj))
+}
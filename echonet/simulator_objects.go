@@ -0,0 +1,262 @@
+package echonet
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// common properties of the device objects
+var sim_device_props = map[byte][]byte{
+	EPC_POWER:   {EDT_OFF},
+	EPC_PLACE:   {0x00},                  // not set
+	EPC_VERSION: {0x00, 0x00, 'J', 0x01}, // appendix J rev.1
+	EPC_ID:      make([]byte, 17),        // set by Add
+	EPC_FAULT:   {0x42},                  // no fault
+	EPC_MAKER:   {0xff, 0xff, 0xff},      // SIM_MAKER
+}
+
+var sim_switch = [][2]byte{{EDT_ON, EDT_OFF}}
+
+func newSimObject(eoj uint32, props map[byte][]byte, set, inf []byte) *SimObject {
+	obj := &SimObject{
+		Eoj:    eoj,
+		props:  map[byte][]byte{},
+		set:    set,
+		inf:    inf,
+		ranges: map[byte][][2]byte{},
+	}
+	for epc, edt := range props {
+		obj.props[epc] = slices.Clone(edt)
+	}
+
+	var get []byte
+	for epc := range obj.props {
+		get = append(get, epc)
+	}
+	get = append(get, EPC_INF_PROPMAP, EPC_SET_PROPMAP, EPC_GET_PROPMAP)
+	slices.Sort(get)
+	obj.props[EPC_INF_PROPMAP] = makePropertyMap(inf)
+	obj.props[EPC_SET_PROPMAP] = makePropertyMap(set)
+	obj.props[EPC_GET_PROPMAP] = makePropertyMap(get)
+	return obj
+}
+
+func deviceProps(props map[byte][]byte) map[byte][]byte {
+	m := map[byte][]byte{}
+	for epc, edt := range sim_device_props {
+		m[epc] = edt
+	}
+	for epc, edt := range props {
+		m[epc] = edt
+	}
+	return m
+}
+
+// newSimNode returns the node profile object.
+func newSimNode() *SimObject {
+	props := map[byte][]byte{
+		EPC_POWER:           {EDT_ON},
+		EPC_VERSION:         {0x01, 0x0d, 0x01, 0x00}, // ver 1.13
+		EPC_ID:              simId("", ECHONET_EOJ_NODE),
+		EPC_MAKER:           {0xff, 0xff, 0xff},
+		EPC_NODE_INS_NUM:    {0, 0, 0},
+		EPC_NODE_CLASS_NUM:  {0, 1},
+		EPC_NODE_INS_INF:    {0},
+		EPC_NODE_INS_LIST:   {0},
+		EPC_NODE_CLASS_LIST: {0},
+	}
+	return newSimObject(ECHONET_EOJ_NODE, props, nil,
+		[]byte{EPC_POWER, EPC_NODE_INS_INF})
+}
+
+// updateNode updates the instance and class lists of the node profile.
+func (sim *Simulator) updateNode() {
+	var instances []byte
+	var classes []uint32
+	for _, obj := range sim.objects[1:] {
+		instances = append(instances,
+			byte(obj.Eoj>>16), byte(obj.Eoj>>8), byte(obj.Eoj))
+		if !slices.Contains(classes, obj.Eoj>>8) {
+			classes = append(classes, obj.Eoj>>8)
+		}
+	}
+	n := len(instances) / 3
+
+	props := sim.node.props
+	props[EPC_ID] = simId(sim.Addr, ECHONET_EOJ_NODE)
+	props[EPC_NODE_INS_NUM] = []byte{byte(n >> 16), byte(n >> 8), byte(n)}
+	props[EPC_NODE_CLASS_NUM] = []byte{byte((len(classes) + 1) >> 8),
+		byte(len(classes) + 1)} // with the node profile
+	props[EPC_NODE_INS_INF] = append([]byte{byte(min(n, 84))},
+		instances[:min(n, 84)*3]...)
+	props[EPC_NODE_INS_LIST] = props[EPC_NODE_INS_INF]
+	list := []byte{byte(min(len(classes), 8))}
+	for _, c := range classes[:min(len(classes), 8)] {
+		list = append(list, byte(c>>8), byte(c))
+	}
+	props[EPC_NODE_CLASS_LIST] = list
+}
+
+// NewSimAircon returns a home air conditioner (0x0130). While running,
+// the room temperature approaches the target and the power consumption
+// follows the load.
+func NewSimAircon(instance byte) *SimObject {
+	props := deviceProps(map[byte][]byte{
+		EPC_WATT:            {0x00, 0x05},
+		EPC_WATT_INTEGRATE:  {0, 0, 0, 0},
+		EPC_POWER_SAVE:      {0x42},
+		EPC_MODE:            {0x42}, // cool
+		EPC_TARGET_TEMP:     {26},
+		EPC_TARGET_HUMIDITY: {50},
+		EPC_ROOM_HUMIDITY:   {55},
+		EPC_ROOM_TEMP:       {28},
+		EPC_OUTDOOR_TEMP:    {30},
+		EPC_FAN:             {EDT_AUTO},
+		EPC_SWING:           {EDT_OFF},
+		EPC_AUTO_DIRECTION:  {0x42}, // manual
+		EPC_DIRECTION_V:     {0x43}, // center
+		EPC_SPECIAL_STATE:   {0x40}, // normal
+		EPC_OFF_TIMER:       {0x42},
+		EPC_OFF_TIMER_TIME:  {0, 0},
+		EPC_HUMIDIFY:        {0x42},
+		EPC_HUMIDIFY_LEVEL:  {EDT_AUTO},
+	})
+	set := []byte{EPC_POWER, EPC_PLACE, EPC_POWER_SAVE, EPC_FAN, EPC_SWING,
+		EPC_AUTO_DIRECTION, EPC_DIRECTION_V, EPC_OFF_TIMER, EPC_OFF_TIMER_TIME, EPC_MODE,
+		EPC_TARGET_TEMP, EPC_TARGET_HUMIDITY, EPC_HUMIDIFY,
+		EPC_HUMIDIFY_LEVEL}
+	inf := []byte{EPC_POWER, EPC_PLACE, EPC_FAULT, EPC_MODE}
+	obj := newSimObject(0x013000|uint32(instance), props, set, inf)
+	obj.ranges = map[byte][][2]byte{
+		EPC_POWER:           sim_switch,
+		EPC_POWER_SAVE:      {{0x41, 0x42}},
+		EPC_FAN:             {{0x31, 0x38}, {EDT_AUTO, EDT_AUTO}},
+		EPC_SWING:           {{EDT_OFF, EDT_OFF}, {0x41, 0x43}},
+		EPC_AUTO_DIRECTION:  {{0x41, 0x44}},
+		EPC_DIRECTION_V:     {{0x41, 0x45}},
+		EPC_OFF_TIMER:       {{0x41, 0x42}},
+		EPC_MODE:            {{0x41, 0x45}},
+		EPC_TARGET_TEMP:     {{0, 50}, {0xfd, 0xfd}},
+		EPC_TARGET_HUMIDITY: {{0, 100}},
+		EPC_HUMIDIFY:        {{0x41, 0x42}},
+		EPC_HUMIDIFY_LEVEL:  {{0x31, 0x38}, {EDT_AUTO, EDT_AUTO}},
+	}
+
+	energy := 0.0 // Wh
+	towards := func(v, to int) int {
+		switch {
+		case v < to:
+			return v + 1
+		case v > to:
+			return v - 1
+		}
+		return v
+	}
+	watt := func() {
+		p := obj.props
+		w := 5 // standby
+		if p[EPC_POWER][0] == EDT_ON {
+			target := int(p[EPC_TARGET_TEMP][0])
+			if target == 0xfd {
+				target = 25 // auto
+			}
+			diff := int(int8(p[EPC_ROOM_TEMP][0])) - target
+			w = min(200+150*max(diff, -diff), 1500)
+		}
+		binary.BigEndian.PutUint16(p[EPC_WATT], uint16(w))
+	}
+	obj.update = func(epc byte) {
+		watt()
+	}
+	obj.tick = func(d time.Duration) {
+		p := obj.props
+		room := int(int8(p[EPC_ROOM_TEMP][0]))
+		outdoor := int(int8(p[EPC_OUTDOOR_TEMP][0]))
+		target := int(p[EPC_TARGET_TEMP][0])
+		if target == 0xfd {
+			target = 25 // auto
+		}
+		humidity := int(p[EPC_ROOM_HUMIDITY][0])
+
+		if rand.Intn(10) == 0 {
+			outdoor = min(max(outdoor+rand.Intn(3)-1, 15), 35)
+		}
+		if p[EPC_POWER][0] == EDT_ON {
+			switch p[EPC_MODE][0] {
+			case 0x41: // auto
+				room = towards(room, target)
+			case 0x42: // cool
+				room = towards(room, min(room, target))
+			case 0x43: // heat
+				room = towards(room, max(room, target))
+				humidity = towards(humidity, 40)
+			case 0x44: // dry
+				humidity = towards(humidity, 40)
+			case 0x45: // fan
+				room = towards(room, outdoor)
+			}
+		} else if rand.Intn(3) == 0 {
+			room = towards(room, outdoor)
+			humidity = towards(humidity, 55)
+		}
+
+		p[EPC_ROOM_TEMP] = []byte{byte(room)}
+		p[EPC_OUTDOOR_TEMP] = []byte{byte(outdoor)}
+		p[EPC_ROOM_HUMIDITY] = []byte{byte(humidity)}
+		watt()
+		energy += float64(binary.BigEndian.Uint16(p[EPC_WATT])) * d.Hours()
+		binary.BigEndian.PutUint32(p[EPC_WATT_INTEGRATE], uint32(energy))
+	}
+	return obj
+}
+
+// NewSimLight returns a general lighting (0x0290) with brightness.
+func NewSimLight(instance byte) *SimObject {
+	props := deviceProps(map[byte][]byte{
+		EPC_BRIGHTNESS: {80},
+		EPC_LIGHT_MODE: {0x42}, // normal
+	})
+	set := []byte{EPC_POWER, EPC_PLACE, EPC_BRIGHTNESS, EPC_LIGHT_MODE}
+	inf := []byte{EPC_POWER, EPC_PLACE, EPC_FAULT}
+	obj := newSimObject(0x029000|uint32(instance), props, set, inf)
+	obj.ranges = map[byte][][2]byte{
+		EPC_POWER:      sim_switch,
+		EPC_BRIGHTNESS: {{0, 100}},
+		EPC_LIGHT_MODE: {{0x42, 0x45}},
+	}
+	return obj
+}
+
+// NewSimMeter returns a low voltage smart electric energy meter
+// (0x0288) measuring a fluctuating load.
+func NewSimMeter(instance byte) *SimObject {
+	props := deviceProps(map[byte][]byte{
+		EPC_POWER:             {EDT_ON},
+		EPC_METER_COEFFICIENT: {0, 0, 0, 1},
+		EPC_METER_DIGITS:      {0x06},
+		EPC_METER_ENERGY:      {0, 0, 0, 0},
+		EPC_METER_UNIT:        {0x01}, // 0.1 kWh
+		EPC_METER_POWER:       {0, 0, 0x01, 0xf4},
+		EPC_METER_CURRENT:     {0, 0x19, 0, 0x19}, // R and T phase, 0.1 A
+	})
+	set := []byte{EPC_PLACE}
+	inf := []byte{EPC_POWER, EPC_PLACE, EPC_FAULT}
+	obj := newSimObject(0x028800|uint32(instance), props, set, inf)
+
+	power := 500.0 // W
+	energy := 0.0  // Wh
+	obj.tick = func(d time.Duration) {
+		power = min(max(power*(0.9+rand.Float64()*0.2), 100), 4000)
+		energy += power * d.Hours()
+
+		p := obj.props
+		binary.BigEndian.PutUint32(p[EPC_METER_POWER], uint32(power))
+		binary.BigEndian.PutUint32(p[EPC_METER_ENERGY], uint32(energy/100))
+		current := uint16(power / 20) // 0.1 A per phase at 100 V
+		binary.BigEndian.PutUint16(p[EPC_METER_CURRENT][0:], current)
+		binary.BigEndian.PutUint16(p[EPC_METER_CURRENT][2:], current)
+	}
+	return obj
+}
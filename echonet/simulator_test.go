@@ -0,0 +1,143 @@
+package echonet
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// startSim starts a simulator at 10.0.0.2 on a memory network and
+// returns the transport of a controller at 10.0.0.1.
+func startSim(t *testing.T, objs ...*SimObject) (*Simulator, Transport) {
+	network := NewMemoryNetwork()
+	conn, err := network.Attach("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	sim := NewSimulator("10.0.0.2:3610")
+	sim.Transport, err = network.Attach(sim.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range objs {
+		if err := sim.Add(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sim.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sim.Close() })
+
+	recv(t, conn) // node announcement
+	return sim, conn
+}
+
+func request(t *testing.T, sim *Simulator, conn Transport, deoj uint32,
+	esv byte, props ...EchonetProperty) {
+
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(deoj)
+	pkt.SetEsv(esv)
+	for _, prop := range props {
+		pkt.AddProperty(prop.EPC, prop.EDT...)
+	}
+	err := conn.Send(pkt.Bytes(), sim.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recv returns the next packet, or nil on timeout.
+func recv(t *testing.T, conn Transport) *EchonetPacket {
+	buf := make([]byte, 1500)
+	n, _, _, err := conn.Recv(buf, time.Now().Add(200*time.Millisecond))
+	if err != nil {
+		return nil
+	}
+	pkt := NewEchonetPacket()
+	if err := pkt.Parse(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+func TestSimulatorGetSet(t *testing.T) {
+	sim, conn := startSim(t, NewSimAircon(1))
+
+	request(t, sim, conn, 0x013001, ESV_GET,
+		EchonetProperty{EPC: EPC_POWER}, EchonetProperty{EPC: EPC_TARGET_TEMP})
+	res := recv(t, conn)
+	if res == nil || res.ESV != ESV_GET_RES || res.SEOJ != 0x013001 ||
+		res.Props[0].EDT[0] != EDT_OFF || res.Props[1].EDT[0] != 26 {
+		t.Fatalf("Get result %v", res)
+	}
+
+	// invalid mode is denied
+	request(t, sim, conn, 0x013001, ESV_SETC,
+		EchonetProperty{EPC: EPC_TARGET_TEMP, EDT: []byte{24}},
+		EchonetProperty{EPC: EPC_MODE, EDT: []byte{0x50}})
+	res = recv(t, conn)
+	if res == nil || res.ESV != ESV_SETC_SNA ||
+		res.Props[0].PDC != 0 || res.Props[1].PDC != 1 {
+		t.Fatalf("SetC result %v", res)
+	}
+
+	// power on is announced
+	request(t, sim, conn, 0x013001, ESV_SETC,
+		EchonetProperty{EPC: EPC_POWER, EDT: []byte{EDT_ON}})
+	res = recv(t, conn)
+	if res == nil || res.ESV != ESV_SET_RES {
+		t.Fatalf("SetC result %v", res)
+	}
+	inf := recv(t, conn)
+	if inf == nil || inf.ESV != ESV_INF || inf.Props[0].EPC != EPC_POWER {
+		t.Fatalf("INF %v", inf)
+	}
+	if edt := sim.Get(0x013001, EPC_TARGET_TEMP); edt[0] != 24 {
+		t.Errorf("target temperature %v expect 24", edt)
+	}
+}
+
+func TestSimulatorSetGet(t *testing.T) {
+	sim, conn := startSim(t, NewSimLight(1))
+
+	pkt := NewEchonetPacket()
+	pkt.SetSeoj(ECHONET_EOJ_NODE)
+	pkt.SetDeoj(0x029001)
+	pkt.SetEsv(ESV_SETGET)
+	pkt.AddProperty(EPC_BRIGHTNESS, 30)
+	pkt.AddGetProperty(EPC_BRIGHTNESS)
+	conn.Send(pkt.Bytes(), sim.LocalAddr().(*net.UDPAddr))
+
+	res := recv(t, conn)
+	if res == nil || res.ESV != ESV_SETGET_RES || len(res.Props) != 1 ||
+		len(res.GetProps) != 1 || res.GetProps[0].EDT[0] != 30 {
+		t.Fatalf("SetGet result %v", res)
+	}
+}
+
+func TestSimulatorFaults(t *testing.T) {
+	sim, conn := startSim(t, NewSimLight(1), NewSimLight(2))
+
+	// instance 0 addresses both
+	request(t, sim, conn, 0x029000, ESV_GET, EchonetProperty{EPC: EPC_POWER})
+	if recv(t, conn) == nil || recv(t, conn) == nil {
+		t.Fatal("no response from all instances")
+	}
+
+	sim.SetFaults(Faults{SNA: []byte{EPC_POWER}})
+	request(t, sim, conn, 0x029001, ESV_GET, EchonetProperty{EPC: EPC_POWER})
+	if res := recv(t, conn); res == nil || res.ESV != ESV_GET_SNA {
+		t.Fatalf("Get result %v expect Get_SNA", res)
+	}
+
+	sim.SetFaults(Faults{Drop: 1})
+	request(t, sim, conn, 0x029001, ESV_GET, EchonetProperty{EPC: EPC_POWER})
+	if res := recv(t, conn); res != nil {
+		t.Fatalf("Get result %v expect none", res)
+	}
+}
@@ -7,48 +7,141 @@ import (
 	"strings"
 
 	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 )
 
-func multicastSocket(ip string, port int) (*ipv4.PacketConn, error) {
+// udpConn is the socket of one IP version.
+type udpConn interface {
+	JoinGroup(ifi *net.Interface, group net.Addr) error
+	LeaveGroup(ifi *net.Interface, group net.Addr) error
+	SetMulticastInterface(ifi *net.Interface) error
+	LocalAddr() net.Addr
+	Close() error
+	// writeTo sends from the interface, or by the route if ifindex is 0.
+	writeTo(b []byte, dst net.Addr, ifindex int) error
+	// readFrom returns the length, source and destination address.
+	readFrom(b []byte) (int, net.Addr, net.IP, error)
+}
+
+type conn4 struct{ *ipv4.PacketConn }
+
+func (c conn4) writeTo(b []byte, dst net.Addr, ifindex int) error {
+	var cm *ipv4.ControlMessage
+	if ifindex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifindex}
+	}
+	_, err := c.WriteTo(b, cm, dst)
+	return err
+}
+
+func (c conn4) readFrom(b []byte) (int, net.Addr, net.IP, error) {
+	n, cm, src, err := c.ReadFrom(b)
+	var dst net.IP
+	if cm != nil {
+		dst = cm.Dst
+	}
+	return n, src, dst, err
+}
+
+type conn6 struct{ *ipv6.PacketConn }
+
+func (c conn6) writeTo(b []byte, dst net.Addr, ifindex int) error {
+	var cm *ipv6.ControlMessage
+	if ifindex != 0 {
+		cm = &ipv6.ControlMessage{IfIndex: ifindex}
+	}
+	_, err := c.WriteTo(b, cm, dst)
+	return err
+}
+
+func (c conn6) readFrom(b []byte) (int, net.Addr, net.IP, error) {
+	n, cm, src, err := c.ReadFrom(b)
+	var dst net.IP
+	if cm != nil {
+		dst = cm.Dst
+	}
+	return n, src, dst, err
+}
+
+// udpSocket listens on the bind address (empty for all) and the port,
+// network is udp4 or udp6.
+func udpSocket(network, bind string, port int) (udpConn, error) {
 	lc := &net.ListenConfig{
 		Control: listenControl,
 	}
 
-	l, err := lc.ListenPacket(context.Background(), "udp4",
-		":"+strconv.Itoa(ECHONET_PORT))
+	l, err := lc.ListenPacket(context.Background(), network,
+		net.JoinHostPort(bind, strconv.Itoa(port)))
 	if err != nil {
 		return nil, err
 	}
 
-	conn := ipv4.NewPacketConn(l)
-
-	err = conn.SetControlMessage(ipv4.FlagDst, true)
+	var conn udpConn
+	if network == "udp6" {
+		c := ipv6.NewPacketConn(l)
+		err = c.SetControlMessage(ipv6.FlagDst, true)
+		conn = conn6{c}
+	} else {
+		c := ipv4.NewPacketConn(l)
+		err = c.SetControlMessage(ipv4.FlagDst, true)
+		conn = conn4{c}
+	}
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
+	return conn, nil
+}
 
-	udpAddr := &net.UDPAddr{
-		IP:   net.ParseIP(ip),
-		Port: port,
+// selectInterfaces returns the interfaces matching the specs, each an
+// interface name or a CIDR containing one of its addresses. Without
+// specs, it guesses the interfaces connected to the home network.
+func selectInterfaces(specs []string) ([]*net.Interface, error) {
+	if len(specs) == 0 {
+		return getInterfaces()
 	}
 
-	ifaces, err := getInterfaces()
+	allifs, err := net.Interfaces()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, iface := range ifaces {
-		addrs, err := getAddresses(iface)
-		if err != nil || len(addrs) == 0 {
+	var ifs []*net.Interface
+	for _, iface := range allifs {
+		if iface.Flags&net.FlagUp == 0 ||
+			iface.Flags&net.FlagMulticast == 0 {
 			continue
 		}
-		err = conn.JoinGroup(iface, udpAddr)
-		if err != nil {
-			return nil, err
+		nets, err := getNetworks(&iface)
+		if err != nil || len(nets) == 0 {
+			continue // no addresses
+		}
+		if matchInterface(specs, iface.Name, nets) {
+			ifs = append(ifs, &iface)
 		}
 	}
+	return ifs, nil
+}
 
-	return conn, err
+func matchInterface(specs []string, name string, nets []*net.IPNet) bool {
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			if spec == name {
+				return true
+			}
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(spec)
+		if err != nil {
+			continue
+		}
+		for _, n := range nets {
+			if cidr.Contains(n.IP) {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func getInterfaces() ([]*net.Interface, error) {
@@ -77,9 +170,9 @@ func getInterfaces() ([]*net.Interface, error) {
 			continue
 		}
 
-		addrs, err := getAddresses(&iface)
-		if err != nil || len(addrs) == 0 {
-			continue // no ipv4 addresses
+		nets, err := getNetworks(&iface)
+		if err != nil || len(nets) == 0 {
+			continue // no addresses
 		}
 
 		ifs = append(ifs, &iface)
@@ -87,25 +180,29 @@ func getInterfaces() ([]*net.Interface, error) {
 	return ifs, nil
 }
 
-func getAddresses(iface *net.Interface) ([]string, error) {
+// getNetworks returns the IPv4 and IPv6 networks of the interface.
+func getNetworks(iface *net.Interface) ([]*net.IPNet, error) {
 	addrs, err := iface.Addrs()
-	if err != nil || len(addrs) == 0 {
+	if err != nil {
 		return nil, err
 	}
-	if len(addrs) == 0 {
-		return nil, nil
-	}
 
-	var retval []string
+	var nets []*net.IPNet
 	for _, addr := range addrs {
-		ss := strings.Split(addr.String(), "/")
-		if len(ss) == 0 || len(ss[0]) == 0 {
-			continue
+		if n, ok := addr.(*net.IPNet); ok {
+			nets = append(nets, n)
 		}
-		if strings.Index(ss[0], ":") >= 0 {
-			continue // skip ipv6
+	}
+	return nets, nil
+}
+
+// familyNetworks returns the IPv6 or the IPv4 networks.
+func familyNetworks(nets []*net.IPNet, v6 bool) []*net.IPNet {
+	var r []*net.IPNet
+	for _, n := range nets {
+		if (n.IP.To4() == nil) == v6 {
+			r = append(r, n)
 		}
-		retval = append(retval, ss[0])
 	}
-	return retval, nil
+	return r
 }
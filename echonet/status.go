@@ -0,0 +1,47 @@
+package echonet
+
+import (
+	"time"
+)
+
+const (
+	RECV_HEARTBEAT = 10 * time.Second // receiver wakes up at least this often
+	IFACE_POLL     = 30 * time.Second // interval to look for interface changes
+)
+
+// Status of the sockets and goroutines of Echonet
+type Status struct {
+	Receiving   bool      // receiver goroutine is running
+	RecvError   error     // last receive error, not fatal
+	Heartbeat   time.Time // last loop of the receiver
+	LastRecv    time.Time // last packet received
+	SendStarted time.Time // start of the current send, zero if idle
+	QueueDepth  int       // packets waiting to be sent
+	Interfaces  []string  // interfaces in the multicast group
+}
+
+// Status returns the current status.
+func (en *Echonet) Status() Status {
+	en.stat_mutex.Lock()
+	st := en.status
+	en.stat_mutex.Unlock()
+
+	st.QueueDepth = en.QueueDepth()
+	return st
+}
+
+func (en *Echonet) updateStatus(fn func(st *Status)) {
+	en.stat_mutex.Lock()
+	fn(&en.status)
+	en.stat_mutex.Unlock()
+}
+
+// LastSeen returns the time of the last packet from the object,
+// zero if it has never answered.
+func (obj *EchonetObject) LastSeen() time.Time {
+	ns := obj.last_seen.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
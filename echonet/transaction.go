@@ -0,0 +1,201 @@
+package echonet
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ECHONET_TIMEOUT = 3 * time.Second // response timeout
+	ECHONET_RETRY   = 2               // number of retries
+
+	// Slow devices drop packets sent back to back.
+	ECHONET_INTERVAL = 800 * time.Millisecond
+)
+
+// request waiting for the response
+type transaction struct {
+	obj   *EchonetObject
+	pkt   *EchonetPacket
+	sent  time.Time
+	retry int
+	timer *time.Timer
+	done  chan *EchonetPacket // response, closed on timeout
+}
+
+type transactionKey struct {
+	obj *EchonetObject
+	tid uint16
+}
+
+// needResponse returns true if the ESV expects a response.
+func needResponse(esv byte) bool {
+	switch esv {
+	case ESV_SETC, ESV_GET, ESV_INF_REQ, ESV_SETGET:
+		return true
+	}
+	return false
+}
+
+// isResponse returns true if the ESV answers a request: the SNAs and
+// the responses, but not the notifications.
+func isResponse(esv byte) bool {
+	switch esv & 0xf0 {
+	case 0x50:
+		return true
+	case 0x70:
+		return esv != ESV_INF && esv != ESV_INFC
+	}
+	return false
+}
+
+// ErrTimeout is returned when the device does not respond.
+var ErrTimeout = errors.New("no response")
+
+// SNAError is returned when the device denies the request.
+type SNAError struct {
+	Esv  byte
+	Eoj  uint32 // of the device
+	Epcs []byte // denied properties
+}
+
+func (e *SNAError) Error() string {
+	var ss []string
+	for _, epc := range e.Epcs {
+		s := fmt.Sprintf("0x%02x", epc)
+		if name := PropertyName(e.Eoj, epc); name != "" {
+			s += "(" + name + ")"
+		}
+		ss = append(ss, s)
+	}
+	return fmt.Sprintf("%s: %s", EsvName(e.Esv), strings.Join(ss, " "))
+}
+
+// begin registers the request sent by the object. The timeout starts
+// by start once the request is written.
+func (en *Echonet) begin(obj *EchonetObject, pkt *EchonetPacket) *transaction {
+	key := transactionKey{obj, pkt.GetTid()}
+	t := &transaction{
+		obj:  obj,
+		pkt:  pkt,
+		done: make(chan *EchonetPacket, 1),
+	}
+	t.timer = time.AfterFunc(en.Timeout, func() { en.expire(key) })
+	t.timer.Stop()
+
+	en.trans_mutex.Lock()
+	en.pending[key] = t
+	en.trans_mutex.Unlock()
+	return t
+}
+
+// start starts the timeout of the written request; the write may have
+// waited for the interval of the other requests.
+func (en *Echonet) start(t *transaction) {
+	en.trans_mutex.Lock()
+	t.sent = time.Now()
+	en.trans_mutex.Unlock()
+	t.timer.Reset(en.Timeout)
+}
+
+// expire retries the request or gives up.
+func (en *Echonet) expire(key transactionKey) {
+	en.trans_mutex.Lock()
+	t, ok := en.pending[key]
+	giveup := ok && t.retry >= en.Retry
+	if giveup {
+		delete(en.pending, key)
+	} else if ok {
+		t.retry += 1
+		t.sent = time.Now()
+	}
+	en.trans_mutex.Unlock()
+	if !ok {
+		return
+	}
+
+	addr := t.obj.GetAddr()
+	if giveup {
+		close(t.done)
+		if t.obj.id != nil || t.obj.mac != nil {
+			en.rediscover() // may have moved
+		}
+		t.obj.logger.Warn("timeout", "dst", addr, "packet", t.pkt.Decode())
+		en.emit(&Event{Kind: EVENT_TIMEOUT, Addr: addr,
+			Packet: t.pkt, Object: t.obj})
+		return
+	}
+
+	en.emit(&Event{Kind: EVENT_RETRY, Addr: addr,
+		Packet: t.pkt, Object: t.obj})
+	t.obj.logger.Info("retry", "dst", addr, "packet", t.pkt.Decode())
+	err := t.obj.write(t.pkt)
+	if err != nil {
+		t.obj.logger.Warn("retry failed", "err", err)
+	}
+	t.timer.Reset(en.Timeout)
+}
+
+// complete finishes the request answered by the packet and returns
+// the round trip time, or false if no request is waiting for it.
+func (en *Echonet) complete(obj *EchonetObject, pkt *EchonetPacket) (time.Duration, bool) {
+	key := transactionKey{obj, pkt.GetTid()}
+
+	en.trans_mutex.Lock()
+	t, ok := en.pending[key]
+	var rtt time.Duration
+	if ok {
+		delete(en.pending, key)
+		rtt = time.Since(t.sent)
+	}
+	en.trans_mutex.Unlock()
+	if !ok {
+		return 0, false
+	}
+
+	t.timer.Stop()
+	t.done <- pkt
+	return rtt, true
+}
+
+// cancel drops all requests of the object.
+func (en *Echonet) cancel(obj *EchonetObject) {
+	en.trans_mutex.Lock()
+	for key, t := range en.pending {
+		if key.obj == obj {
+			t.timer.Stop()
+			close(t.done)
+			delete(en.pending, key)
+		}
+	}
+	en.trans_mutex.Unlock()
+}
+
+// wait returns the response of the request, or an error if the device
+// denied it or did not respond.
+func (t *transaction) wait() (*EchonetPacket, error) {
+	pkt, ok := <-t.done
+	if !ok {
+		return nil, ErrTimeout
+	}
+	if esv := pkt.GetEsv(); esv&0xf0 == 0x50 {
+		e := &SNAError{Esv: esv, Eoj: pkt.SEOJ}
+		for _, prop := range pkt.Props {
+			// SetC_SNA returns the denied properties with their EDT,
+			// Get_SNA and INF_SNA without it.
+			noedt := esv == ESV_GET_SNA || esv == ESV_INF_SNA
+			if noedt == (len(prop.EDT) == 0) {
+				e.Epcs = append(e.Epcs, prop.EPC)
+			}
+		}
+		for _, prop := range pkt.GetProps {
+			if len(prop.EDT) == 0 { // get section of SetGet_SNA
+				e.Epcs = append(e.Epcs, prop.EPC)
+			}
+		}
+		return pkt, e
+	}
+	return pkt, nil
+}
@@ -0,0 +1,356 @@
+package echonet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Transport carries ECHONET Lite frames between nodes.
+type Transport interface {
+	// Send sends the frame to the node.
+	Send(b []byte, dst *net.UDPAddr) error
+	// Multicast sends the frame to all nodes.
+	Multicast(b []byte) error
+	// Recv waits for a frame until the deadline (zero for none) and
+	// returns its length, source and destination address. A timeout
+	// returns an error with Timeout() true.
+	Recv(buf []byte, deadline time.Time) (int, *net.UDPAddr, net.IP, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// wait after a read error before reading the socket again
+const UDP_ERROR_PAUSE = 1 * time.Second
+
+// UDPTransport is the transport over UDP, on IPv4 and IPv6.
+type UDPTransport struct {
+	families []*udpFamily
+	specs    []string // interface names or CIDRs, empty to guess
+	unicast  bool     // bound to a unicast address, not in the groups
+	mutex    sync.Mutex
+	frames   chan udpFrame
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// udpFamily is the socket and the multicast group of one IP version.
+type udpFamily struct {
+	conn   udpConn
+	group  *net.UDPAddr
+	v6     bool
+	joined []*net.Interface
+	nets   map[int][]*net.IPNet // networks by interface index
+}
+
+// frame or error read by a reader
+type udpFrame struct {
+	data []byte
+	src  *net.UDPAddr
+	dst  net.IP
+	err  error
+}
+
+// NewUDPTransport listens on the bind address and the port, on both
+// IPv4 and IPv6 if the address is empty. IPv6 is skipped if the host
+// does not support it. Unless bound to a unicast address, it joins the
+// multicast groups on the interfaces matching the specs, see
+// UpdateInterfaces.
+func NewUDPTransport(bind string, port int, specs []string) (*UDPTransport, error) {
+	t := &UDPTransport{
+		specs:  specs,
+		frames: make(chan udpFrame, 64),
+		closed: make(chan struct{}),
+	}
+
+	var ip net.IP // with the zone removed
+	if a, err := netip.ParseAddr(bind); err == nil {
+		ip = a.AsSlice()
+	}
+	for _, v6 := range []bool{false, true} {
+		if ip != nil && (ip.To4() == nil) != v6 {
+			continue // the other version
+		}
+		network, group := "udp4", ECHONET_MULTICAST
+		if v6 {
+			network, group = "udp6", ECHONET_MULTICAST6
+		}
+		conn, err := udpSocket(network, bind, port)
+		if err != nil && v6 && ip == nil {
+			continue // no IPv6
+		}
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.families = append(t.families, &udpFamily{
+			conn: conn,
+			group: &net.UDPAddr{
+				IP:   net.ParseIP(group),
+				Port: ECHONET_PORT,
+			},
+			v6:   v6,
+			nets: map[int][]*net.IPNet{},
+		})
+	}
+
+	if ip != nil && !ip.IsUnspecified() {
+		t.unicast = true
+	} else if _, _, err := t.UpdateInterfaces(); err != nil {
+		t.Close()
+		return nil, err
+	}
+	for _, f := range t.families {
+		go t.reader(f)
+	}
+	return t, nil
+}
+
+// UpdateInterfaces joins the multicast groups on the interfaces that
+// appeared and leaves them on those that disappeared since the last
+// call. The changes are reported as group%interface.
+func (t *UDPTransport) UpdateInterfaces() (joined, left []string, err error) {
+	if t.unicast {
+		return nil, nil, nil
+	}
+	ifs, err := selectInterfaces(t.specs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	has := func(list []*net.Interface, iface *net.Interface) bool {
+		return slices.ContainsFunc(list, func(i *net.Interface) bool {
+			return i.Index == iface.Index && i.Name == iface.Name
+		})
+	}
+	var errs []error
+	for _, f := range t.families {
+		var current []*net.Interface
+		for _, iface := range ifs {
+			all, _ := getNetworks(iface)
+			nets := familyNetworks(all, f.v6)
+			if len(nets) == 0 {
+				continue // no address of the version
+			}
+			name := f.group.IP.String() + "%" + iface.Name
+			if !has(f.joined, iface) {
+				err := f.conn.JoinGroup(iface, f.group)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", name, err))
+					continue
+				}
+				joined = append(joined, name)
+			}
+			current = append(current, iface)
+			f.nets[iface.Index] = nets
+		}
+		for _, iface := range f.joined {
+			if !has(current, iface) {
+				// fails if the interface is gone, nothing to leave then
+				f.conn.LeaveGroup(iface, f.group)
+				delete(f.nets, iface.Index)
+				left = append(left, f.group.IP.String()+"%"+iface.Name)
+			}
+		}
+		f.joined = current
+	}
+	return joined, left, errors.Join(errs...)
+}
+
+// Interfaces returns the names of the interfaces in a multicast group.
+func (t *UDPTransport) Interfaces() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var names []string
+	for _, f := range t.families {
+		for _, iface := range f.joined {
+			if !slices.Contains(names, iface.Name) {
+				names = append(names, iface.Name)
+			}
+		}
+	}
+	return names
+}
+
+func (t *UDPTransport) family(ip net.IP) *udpFamily {
+	for _, f := range t.families {
+		if (ip.To4() == nil) == f.v6 {
+			return f
+		}
+	}
+	return nil
+}
+
+// Send sends from the selected interface on the network of the node,
+// if any; otherwise the route of the kernel decides. Link-local IPv6
+// addresses need the zone.
+func (t *UDPTransport) Send(b []byte, dst *net.UDPAddr) error {
+	f := t.family(dst.IP)
+	if f == nil {
+		return fmt.Errorf("no socket for %s", dst.IP)
+	}
+
+	ifindex := 0
+	if len(t.specs) > 0 {
+		t.mutex.Lock()
+		for _, iface := range f.joined {
+			if slices.ContainsFunc(f.nets[iface.Index],
+				func(n *net.IPNet) bool { return n.Contains(dst.IP) }) {
+				ifindex = iface.Index
+				break
+			}
+		}
+		t.mutex.Unlock()
+	}
+	return f.conn.writeTo(b, dst, ifindex)
+}
+
+// Multicast sends the frame to each group on each interface in the
+// group (IP_MULTICAST_IF). Without interfaces, IPv4 falls back to the
+// route of the kernel.
+func (t *UDPTransport) Multicast(b []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var errs []error
+	for _, f := range t.families {
+		if len(f.joined) == 0 {
+			if !f.v6 {
+				errs = append(errs, f.conn.writeTo(b, f.group, 0))
+			}
+			continue
+		}
+		for _, iface := range f.joined {
+			err := f.conn.SetMulticastInterface(iface)
+			if err == nil {
+				err = f.conn.writeTo(b, f.group, 0)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s%%%s: %w",
+					f.group.IP, iface.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reader passes the frames and the errors of the socket to Recv until
+// it is closed. Other errors, e.g. of an interface going down, are
+// transient and do not stop the other family.
+func (t *UDPTransport) reader(f *udpFamily) {
+	for {
+		buf := make([]byte, 1500)
+		n, src, dst, err := f.conn.readFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		frame := udpFrame{data: buf[:n], dst: dst, err: err}
+		if addr, ok := src.(*net.UDPAddr); ok {
+			frame.src = addr
+		}
+		select {
+		case t.frames <- frame:
+		case <-t.closed:
+			return
+		}
+		if err != nil {
+			select {
+			case <-time.After(UDP_ERROR_PAUSE): // not to spin on the error
+			case <-t.closed:
+				return
+			}
+		}
+	}
+}
+
+func (t *UDPTransport) Recv(buf []byte, deadline time.Time) (int, *net.UDPAddr, net.IP, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case f := <-t.frames:
+		if f.err != nil {
+			return 0, nil, nil, f.err
+		}
+		return copy(buf, f.data), f.src, f.dst, nil
+	case <-timeout:
+		return 0, nil, nil, os.ErrDeadlineExceeded
+	case <-t.closed:
+		return 0, nil, nil, net.ErrClosed
+	}
+}
+
+// LocalAddr returns the IPv4 address, or the IPv6 address if only
+// bound to that.
+func (t *UDPTransport) LocalAddr() net.Addr {
+	return t.families[0].conn.LocalAddr()
+}
+
+func (t *UDPTransport) Close() error {
+	var errs []error
+	t.once.Do(func() {
+		close(t.closed)
+		for _, f := range t.families {
+			errs = append(errs, f.conn.Close())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// deviceAddr returns the address as host:port, with the ECHONET port
+// by default.
+func deviceAddr(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(ECHONET_PORT))
+}
+
+// watchInterfaces follows the interfaces appearing and disappearing
+// until Close.
+func (en *Echonet) watchInterfaces(t *UDPTransport) {
+	ifs := t.Interfaces()
+	en.Logger.Info("multicast interfaces", "interfaces", ifs)
+	for {
+		if len(ifs) == 0 {
+			en.Logger.Warn("no multicast interface", "interfaces", t.specs)
+		}
+		en.updateStatus(func(st *Status) {
+			st.Interfaces = ifs
+		})
+
+		var joined, left []string
+		for len(joined) == 0 && len(left) == 0 {
+			select {
+			case <-en.done:
+				return
+			case <-time.After(IFACE_POLL):
+			}
+			var err error
+			joined, left, err = t.UpdateInterfaces()
+			if err != nil {
+				en.Logger.Warn("multicast join failed", "err", err)
+			}
+		}
+		for _, name := range joined {
+			en.Logger.Info("joined multicast group", "group", name)
+		}
+		for _, name := range left {
+			en.Logger.Info("left multicast group", "group", name)
+		}
+		ifs = t.Interfaces()
+	}
+}
@@ -0,0 +1,128 @@
+package echonet
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryNetwork connects memory transports without sockets, for tests.
+type MemoryNetwork struct {
+	mutex sync.Mutex
+	nodes []*MemoryTransport
+}
+
+// MemoryTransport is a node of the memory network.
+type MemoryTransport struct {
+	network *MemoryNetwork
+	addr    *net.UDPAddr
+	frames  chan memoryFrame
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type memoryFrame struct {
+	data []byte
+	src  *net.UDPAddr
+	dst  net.IP
+}
+
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{}
+}
+
+// Attach adds a node at the address, "ip" or "ip:port".
+func (n *MemoryNetwork) Attach(addr string) (*MemoryTransport, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", deviceAddr(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for _, t := range n.nodes {
+		if t.addr.String() == udpAddr.String() {
+			return nil, fmt.Errorf("address in use: %s", udpAddr)
+		}
+	}
+	t := &MemoryTransport{
+		network: n,
+		addr:    udpAddr,
+		frames:  make(chan memoryFrame, 64),
+		closed:  make(chan struct{}),
+	}
+	n.nodes = append(n.nodes, t)
+	return t, nil
+}
+
+// deliver queues the frame to the nodes; like UDP, frames to a full
+// queue are lost.
+func (n *MemoryNetwork) deliver(b []byte, src *MemoryTransport, dst *net.UDPAddr) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, t := range n.nodes {
+		if t == src {
+			continue
+		}
+		if dst != nil && !(t.addr.IP.Equal(dst.IP) && t.addr.Port == dst.Port) {
+			continue
+		}
+		f := memoryFrame{data: slices.Clone(b), src: src.addr,
+			dst: net.ParseIP(ECHONET_MULTICAST)}
+		if dst != nil {
+			f.dst = dst.IP
+		}
+		select {
+		case t.frames <- f:
+		default:
+		}
+	}
+}
+
+func (t *MemoryTransport) Send(b []byte, dst *net.UDPAddr) error {
+	t.network.deliver(b, t, dst)
+	return nil
+}
+
+func (t *MemoryTransport) Multicast(b []byte) error {
+	t.network.deliver(b, t, nil)
+	return nil
+}
+
+func (t *MemoryTransport) Recv(buf []byte, deadline time.Time) (int, *net.UDPAddr, net.IP, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case f := <-t.frames:
+		return copy(buf, f.data), f.src, f.dst, nil
+	case <-timeout:
+		return 0, nil, nil, os.ErrDeadlineExceeded
+	case <-t.closed:
+		return 0, nil, nil, net.ErrClosed
+	}
+}
+
+func (t *MemoryTransport) LocalAddr() net.Addr {
+	return t.addr
+}
+
+func (t *MemoryTransport) Close() error {
+	t.once.Do(func() {
+		close(t.closed)
+
+		n := t.network
+		n.mutex.Lock()
+		n.nodes = slices.DeleteFunc(n.nodes,
+			func(o *MemoryTransport) bool { return o == t })
+		n.mutex.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,81 @@
+package echonet
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+type fakeRead struct {
+	data []byte
+	err  error
+}
+
+// fakeConn returns the reads queued until closed.
+type fakeConn struct {
+	udpConn
+	reads  chan fakeRead
+	closed chan struct{}
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{reads: make(chan fakeRead, 4), closed: make(chan struct{})}
+}
+
+func (c *fakeConn) readFrom(b []byte) (int, net.Addr, net.IP, error) {
+	select {
+	case r := <-c.reads:
+		src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: ECHONET_PORT}
+		return copy(b, r.data), src, nil, r.err
+	case <-c.closed:
+		return 0, nil, nil, net.ErrClosed
+	}
+}
+
+func (c *fakeConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestUDPTransportReadError(t *testing.T) {
+	v4, v6 := newFakeConn(), newFakeConn()
+	tr := &UDPTransport{
+		families: []*udpFamily{{conn: v4}, {conn: v6, v6: true}},
+		frames:   make(chan udpFrame, 64),
+		closed:   make(chan struct{}),
+	}
+	for _, f := range tr.families {
+		go tr.reader(f)
+	}
+	buf := make([]byte, 1500)
+	recv := func() (string, error) {
+		t.Helper()
+		n, _, _, err := tr.Recv(buf, time.Now().Add(2*UDP_ERROR_PAUSE))
+		return string(buf[:n]), err
+	}
+
+	// a transient error of IPv6 stops neither family
+	v6.reads <- fakeRead{err: errors.New("network is down")}
+	if _, err := recv(); err == nil || err.Error() != "network is down" {
+		t.Fatalf("error %v", err)
+	}
+	v4.reads <- fakeRead{data: []byte("v4")}
+	v6.reads <- fakeRead{data: []byte("v6")}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		s, err := recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[s] = true
+	}
+	if !got["v4"] || !got["v6"] {
+		t.Errorf("frames %v expect v4 and v6", got)
+	}
+
+	tr.Close()
+	if _, err := recv(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("error %v after close", err)
+	}
+}
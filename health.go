@@ -0,0 +1,182 @@
+/// health.go ---
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+const (
+	DEFAULT_RECEIVER_TIMEOUT = 1 * time.Minute
+	DEFAULT_SEND_TIMEOUT     = 30 * time.Second
+)
+
+// thresholds of the health check
+type HealthConfig struct {
+	ReceiverTimeout string `json:"receiver_timeout"` // receiver loop stalled
+	SendTimeout     string `json:"send_timeout"`     // single send blocked
+	DeviceTimeout   string `json:"device_timeout"`   // device silent, "" to ignore
+}
+
+// Health reports whether the bridge is working.
+type Health struct {
+	mutex            sync.Mutex
+	started          time.Time
+	receiver_timeout time.Duration
+	send_timeout     time.Duration
+	device_timeout   time.Duration
+}
+
+var (
+	health = &Health{
+		started:          time.Now(),
+		receiver_timeout: DEFAULT_RECEIVER_TIMEOUT,
+		send_timeout:     DEFAULT_SEND_TIMEOUT,
+	}
+)
+
+type healthReport struct {
+	Status   string         `json:"status"`
+	Problems []string       `json:"problems,omitempty"`
+	Mqtt     mqttReport     `json:"mqtt"`
+	Echonet  echonetReport  `json:"echonet"`
+	Devices  []deviceReport `json:"devices"`
+}
+
+type mqttReport struct {
+	Connected bool `json:"connected"`
+}
+
+type echonetReport struct {
+	Receiving  bool       `json:"receiving"`
+	Error      string     `json:"error,omitempty"`
+	Heartbeat  time.Time  `json:"heartbeat"`
+	LastRecv   *time.Time `json:"last_recv"`
+	Sending    string     `json:"sending,omitempty"` // duration of current send
+	QueueDepth int        `json:"queue_depth"`
+	Interfaces []string   `json:"interfaces,omitempty"` // in the multicast group
+}
+
+type deviceReport struct {
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	LastSeen *time.Time `json:"last_seen"`
+	Stale    bool       `json:"stale,omitempty"`
+}
+
+// Configure sets the thresholds, which are checked by readConfig.
+func (h *Health) Configure(cfg HealthConfig) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.receiver_timeout = parseDuration(cfg.ReceiverTimeout,
+		DEFAULT_RECEIVER_TIMEOUT)
+	h.send_timeout = parseDuration(cfg.SendTimeout, DEFAULT_SEND_TIMEOUT)
+	h.device_timeout = parseDuration(cfg.DeviceTimeout, 0)
+}
+
+func parseDuration(s string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// report checks the bridge. Liveness problems make the bridge
+// unhealthy; readiness additionally requires the MQTT connection.
+func (h *Health) report(enet *echonet.Echonet, mqtt Mqtt,
+	ready bool) (healthReport, bool) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	now := time.Now()
+	st := enet.Status()
+	r := healthReport{
+		Mqtt: mqttReport{Connected: mqtt.IsConnected()},
+		Echonet: echonetReport{
+			Receiving:  st.Receiving,
+			Heartbeat:  st.Heartbeat,
+			QueueDepth: st.QueueDepth,
+			Interfaces: st.Interfaces,
+		},
+		Devices: []deviceReport{},
+	}
+
+	if st.RecvError != nil {
+		r.Echonet.Error = st.RecvError.Error()
+	}
+	if !st.Receiving {
+		r.Problems = append(r.Problems, "receiver stopped")
+	} else if now.Sub(st.Heartbeat) > h.receiver_timeout {
+		r.Problems = append(r.Problems, fmt.Sprintf(
+			"receiver stalled for %s", now.Sub(st.Heartbeat).Round(time.Second)))
+	}
+	if !st.LastRecv.IsZero() {
+		r.Echonet.LastRecv = &st.LastRecv
+	}
+	if !st.SendStarted.IsZero() {
+		d := now.Sub(st.SendStarted)
+		r.Echonet.Sending = d.Round(time.Millisecond).String()
+		if d > h.send_timeout {
+			r.Problems = append(r.Problems, fmt.Sprintf(
+				"send blocked for %s", d.Round(time.Second)))
+		}
+	}
+
+	for _, obj := range enet.List() {
+		d := deviceReport{
+			Type: obj.GetType(),
+			Name: obj.GetName(),
+		}
+		since := h.started
+		if seen := obj.LastSeen(); !seen.IsZero() {
+			d.LastSeen = &seen
+			since = seen
+		}
+		if h.device_timeout > 0 && now.Sub(since) > h.device_timeout {
+			d.Stale = true
+			// a silent device does not make the bridge unhealthy
+			if ready {
+				r.Problems = append(r.Problems, fmt.Sprintf(
+					"%s/%s not seen for %s", d.Type, d.Name,
+					now.Sub(since).Round(time.Second)))
+			}
+		}
+		r.Devices = append(r.Devices, d)
+	}
+
+	if ready && !r.Mqtt.Connected {
+		r.Problems = append(r.Problems, "mqtt disconnected")
+	}
+
+	ok := len(r.Problems) == 0
+	r.Status = "ok"
+	if !ok {
+		r.Status = "unhealthy"
+	}
+	return r, ok
+}
+
+// Handler serves /healthz (ready=false) or /readyz (ready=true).
+func (h *Health) Handler(enet *echonet.Echonet, mqtt Mqtt,
+	ready bool) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, ok := h.report(enet, mqtt, ready)
+		w.Header().Set("Content-Type", "application/json")
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	})
+}
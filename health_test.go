@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+func TestHealthDeviceTimeout(t *testing.T) {
+	tr, err := echonet.NewMemoryNetwork().Attach("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enet, err := echonet.NewEchonetOptions(echonet.Options{Transport: tr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enet.Close()
+	if _, err := enet.NewObject(echonet.Config{Type: "light", Name: "hall",
+		Addr: "10.0.0.2", Eoj: "029001"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := enet.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Health{started: time.Now().Add(-time.Hour)}
+	h.Configure(HealthConfig{DeviceTimeout: "1m"})
+	mqtt := &fakeMqtt{}
+
+	stale := func(ready bool) bool {
+		r, _ := h.report(enet, mqtt, ready)
+		if !r.Devices[0].Stale {
+			t.Errorf("ready %v: not stale", ready)
+		}
+		return slices.Contains(r.Problems, "light/hall not seen for 1h0m0s")
+	}
+	if stale(false) {
+		t.Error("stale device unhealthy")
+	}
+	if !stale(true) {
+		t.Error("stale device ready")
+	}
+}
+
+// blockingTransport blocks sending until unblocked.
+type blockingTransport struct {
+	echonet.Transport
+	block chan struct{}
+}
+
+func (t *blockingTransport) Send(b []byte, dst *net.UDPAddr) error {
+	<-t.block
+	return t.Transport.Send(b, dst)
+}
+
+func TestHealthChecks(t *testing.T) {
+	setupLogging(io.Discard, LogConfig{})
+	tests := []struct {
+		name    string
+		cfg     HealthConfig
+		start   bool
+		block   bool // a send blocked
+		down    bool // mqtt disconnected
+		healthz int
+		readyz  int
+	}{
+		{"ok", HealthConfig{}, true, false, false, 200, 200},
+		{"receiver stopped", HealthConfig{}, false, false, false, 503, 503},
+		{"receiver stalled", HealthConfig{ReceiverTimeout: "1ns"},
+			true, false, false, 503, 503},
+		{"send blocked", HealthConfig{SendTimeout: "1ms"},
+			true, true, false, 503, 503},
+		{"send in time", HealthConfig{}, true, true, false, 200, 200},
+		{"mqtt disconnected", HealthConfig{}, true, false, true, 200, 503},
+		{"device stale", HealthConfig{DeviceTimeout: "1m"},
+			true, false, false, 200, 503},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, err := echonet.NewMemoryNetwork().Attach("10.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bt := &blockingTransport{Transport: tr, block: make(chan struct{})}
+			enet, err := echonet.NewEchonetOptions(echonet.Options{Transport: bt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer enet.Close()
+			defer close(bt.block) // unblock the send before Close
+			enet.Interval = 0
+			obj, err := enet.NewObject(echonet.Config{Type: "light",
+				Name: "hall", Addr: "10.0.0.2", Eoj: "029001"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.start {
+				if err := enet.Start(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.block {
+				go obj.Refresh()
+				for i := 0; i < 50 && enet.Status().SendStarted.IsZero(); i++ {
+					time.Sleep(10 * time.Millisecond)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			h := &Health{started: time.Now().Add(-time.Hour)}
+			h.Configure(tt.cfg)
+			mqtt := &fakeMqtt{down: tt.down}
+			for _, check := range []struct {
+				ready  bool
+				expect int
+			}{{false, tt.healthz}, {true, tt.readyz}} {
+				rec := httptest.NewRecorder()
+				h.Handler(enet, mqtt, check.ready).ServeHTTP(rec,
+					httptest.NewRequest("GET", "/", nil))
+				if rec.Code != check.expect {
+					t.Errorf("ready %v: status %d expect %d: %s", check.ready,
+						rec.Code, check.expect, rec.Body)
+				}
+			}
+		})
+	}
+}
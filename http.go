@@ -0,0 +1,87 @@
+/// http.go ---
+
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"echonet-mqtt/echonet"
+)
+
+// startHTTP serves the bridge endpoints on the address.
+func startHTTP(addr string, enet *echonet.Echonet, mqtt Mqtt) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log_http.Info("listen", "addr", l.Addr().String())
+
+	handler := httpHandler(enet, mqtt)
+	go func() {
+		err := http.Serve(l, handler)
+		log_http.Error("server stopped", "err", err)
+	}()
+
+	return nil
+}
+
+// httpHandler routes the bridge endpoints.
+func httpHandler(enet *echonet.Echonet, mqtt Mqtt) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /", webHandler())
+	mux.Handle("GET /metrics", metrics.Handler(enet))
+	mux.Handle("GET /healthz", health.Handler(enet, mqtt, false))
+	mux.Handle("GET /readyz", health.Handler(enet, mqtt, true))
+	mux.HandleFunc("GET /devices", func(w http.ResponseWriter, r *http.Request) {
+		devicesHandler(w, r, enet)
+	})
+	mux.HandleFunc("GET /devices/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		deviceHandler(w, r, enet)
+	})
+	mux.HandleFunc("PUT /devices/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		setHandler(w, r, enet)
+	})
+	mux.HandleFunc("PATCH /devices/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		setHandler(w, r, enet)
+	})
+	mux.HandleFunc("GET /devices/{type}/{name}/properties", func(w http.ResponseWriter, r *http.Request) {
+		propertiesHandler(w, r, enet)
+	})
+	mux.Handle("GET /events", stream.EventsHandler(enet))
+	mux.Handle("GET /ws", stream.WebSocketHandler(enet))
+	mux.HandleFunc("PUT /trace/{type}/{name}", func(w http.ResponseWriter, r *http.Request) {
+		traceHandler(w, r, enet)
+	})
+	return mux
+}
+
+// traceHandler enables ("on") or disables ("off") the packet trace
+// of the device given in the body.
+func traceHandler(w http.ResponseWriter, r *http.Request,
+	enet *echonet.Echonet) {
+
+	objtype, objname := r.PathValue("type"), r.PathValue("name")
+	if enet.FindObject(objtype, objname) == nil {
+		http.Error(w, "device not found", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch strings.TrimSpace(string(body)) {
+	case "on":
+		setTrace(objtype+"/"+objname, true)
+	case "off":
+		setTrace(objtype+"/"+objname, false)
+	default:
+		http.Error(w, "body must be on or off", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
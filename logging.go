@@ -0,0 +1,245 @@
+/// logging.go ---
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// log subsystems
+var log_subsystems = []string{"bridge", "config", "echonet", "mqtt", "http"}
+
+type LogConfig struct {
+	Level      string            `json:"level"`      // debug, info, warn, error
+	Format     string            `json:"format"`     // text or json
+	Subsystems map[string]string `json:"subsystems"` // level by subsystem
+	Devices    map[string]string `json:"devices"`    // level by "type/name"
+}
+
+// state shared by all loggers
+type logRoot struct {
+	mutex      sync.Mutex
+	handler    slog.Handler
+	level      slog.Level
+	subsystems map[string]slog.Level
+	devices    map[string]slog.Level
+	trace      map[string]bool // devices with packet trace enabled
+}
+
+var (
+	log_root = &logRoot{
+		handler: slog.NewTextHandler(os.Stderr, nil),
+		level:   slog.LevelInfo,
+	}
+	log_bridge = slog.New(&logFilter{root: log_root}).With("subsystem", "bridge")
+	log_config = slog.New(&logFilter{root: log_root}).With("subsystem", "config")
+	log_mqtt   = slog.New(&logFilter{root: log_root}).With("subsystem", "mqtt")
+	log_http   = slog.New(&logFilter{root: log_root}).With("subsystem", "http")
+)
+
+// setupLogging installs the root logger writing to w.
+func setupLogging(w io.Writer, cfg LogConfig) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	log_root.mutex.Lock()
+	if cfg.Format == "json" {
+		log_root.handler = slog.NewJSONHandler(w, opts)
+	} else {
+		log_root.handler = slog.NewTextHandler(w, opts)
+	}
+	log_root.mutex.Unlock()
+
+	configureLogging(cfg)
+	slog.SetDefault(slog.New(&logFilter{root: log_root}))
+}
+
+// configureLogging sets the levels, which are checked by readConfig.
+func configureLogging(cfg LogConfig) {
+	r := log_root
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.level = parseLevel(cfg.Level, slog.LevelInfo)
+	r.subsystems = map[string]slog.Level{}
+	for name, lv := range cfg.Subsystems {
+		r.subsystems[name] = parseLevel(lv, r.level)
+	}
+	r.devices = map[string]slog.Level{}
+	for name, lv := range cfg.Devices {
+		r.devices[name] = parseLevel(lv, r.level)
+	}
+}
+
+func parseLevel(s string, def slog.Level) slog.Level {
+	var lv slog.Level
+	if err := lv.UnmarshalText([]byte(s)); err != nil {
+		return def
+	}
+	return lv
+}
+
+// setTrace enables or disables the packet trace of the device.
+func setTrace(device string, on bool) {
+	r := log_root
+	r.mutex.Lock()
+	if r.trace == nil {
+		r.trace = map[string]bool{}
+	}
+	if on {
+		r.trace[device] = true
+	} else {
+		delete(r.trace, device)
+	}
+	r.mutex.Unlock()
+
+	log_bridge.Info("packet trace", "device", device, "enabled", on)
+}
+
+// minLevel returns the lowest level any logger may output.
+func (r *logRoot) minLevel() slog.Level {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if len(r.trace) > 0 {
+		return slog.LevelDebug
+	}
+	lv := r.level
+	for _, l := range r.subsystems {
+		lv = min(lv, l)
+	}
+	for _, l := range r.devices {
+		lv = min(lv, l)
+	}
+	return lv
+}
+
+// enabled returns the effective level; the device level wins over
+// the subsystem level.
+func (r *logRoot) enabled(level slog.Level, subsystem, device string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if device != "" {
+		if r.trace[device] {
+			return true
+		}
+		if lv, ok := r.devices[device]; ok {
+			return level >= lv
+		}
+	}
+	if lv, ok := r.subsystems[subsystem]; ok {
+		return level >= lv
+	}
+	return level >= r.level
+}
+
+// logFilter filters records by subsystem and device.
+type logFilter struct {
+	root      *logRoot
+	subsystem string
+	device    string
+	ops       []func(h slog.Handler) slog.Handler // WithAttrs/WithGroup
+}
+
+func (f *logFilter) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= f.root.minLevel()
+}
+
+func (f *logFilter) Handle(ctx context.Context, rec slog.Record) error {
+	device := f.device
+	rec.Attrs(func(a slog.Attr) bool {
+		if a.Key == "device" {
+			device = a.Value.String()
+			return false
+		}
+		return true
+	})
+	if !f.root.enabled(rec.Level, f.subsystem, device) {
+		return nil
+	}
+
+	f.root.mutex.Lock()
+	h := f.root.handler
+	f.root.mutex.Unlock()
+
+	for _, op := range f.ops {
+		h = op(h)
+	}
+	return h.Handle(ctx, rec)
+}
+
+func (f *logFilter) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *f
+	for _, a := range attrs {
+		switch a.Key {
+		case "subsystem":
+			c.subsystem = a.Value.String()
+		case "device":
+			c.device = a.Value.String()
+		}
+	}
+	c.ops = append(f.ops[:len(f.ops):len(f.ops)],
+		func(h slog.Handler) slog.Handler { return h.WithAttrs(attrs) })
+	return &c
+}
+
+func (f *logFilter) WithGroup(name string) slog.Handler {
+	c := *f
+	c.ops = append(f.ops[:len(f.ops):len(f.ops)],
+		func(h slog.Handler) slog.Handler { return h.WithGroup(name) })
+	return &c
+}
+
+// checkLogConfig reports invalid log settings.
+func (src *configSource) checkLogConfig(cfg LogConfig) {
+	checkLevel := func(path, s string) {
+		var lv slog.Level
+		if err := lv.UnmarshalText([]byte(s)); err != nil {
+			src.errorf(path,
+				"invalid level %q (must be debug, info, warn or error)", s)
+		}
+	}
+
+	if cfg.Level != "" {
+		checkLevel("log.level", cfg.Level)
+	}
+	switch cfg.Format {
+	case "", "text", "json":
+	default:
+		src.errorf("log.format", "invalid format %q (must be text or json)",
+			cfg.Format)
+	}
+	for _, name := range sortedKeys(cfg.Subsystems) {
+		path := "log.subsystems." + name
+		known := false
+		for _, s := range log_subsystems {
+			known = known || s == name
+		}
+		if !known {
+			src.errorf(path, "unknown subsystem %q (must be one of %s)",
+				name, strings.Join(log_subsystems, ", "))
+			continue
+		}
+		checkLevel(path, cfg.Subsystems[name])
+	}
+	for _, name := range sortedKeys(cfg.Devices) {
+		path := "log.devices." + name
+		if !strings.Contains(name, "/") {
+			src.errorf(path, "invalid device %q (must be type/name)", name)
+			continue
+		}
+		checkLevel(path, cfg.Devices[name])
+	}
+}
+
+// fatalf logs the error and exits.
+func fatalf(format string, args ...any) {
+	log_bridge.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
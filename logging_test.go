@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+func TestLogFilter(t *testing.T) {
+	var buf bytes.Buffer
+	setupLogging(&buf, LogConfig{
+		Level:      "warn",
+		Subsystems: map[string]string{"mqtt": "debug"},
+		Devices:    map[string]string{"light/hall": "error", "aircon/living": "info"},
+	})
+	defer setupLogging(io.Discard, LogConfig{})
+
+	logger := func(subsystem string) *slog.Logger {
+		return slog.New(&logFilter{root: log_root}).With("subsystem", subsystem)
+	}
+	bridge, mqtt := logger("bridge"), logger("mqtt")
+	living := bridge.With("device", "aircon/living")
+
+	tests := []struct {
+		log    func(msg string, args ...any)
+		msg    string
+		args   []any
+		expect bool
+	}{
+		{bridge.Info, "bridge info", nil, false},
+		{bridge.Warn, "bridge warn", nil, true},
+		{mqtt.Debug, "mqtt debug", nil, true},
+		{living.Debug, "living debug", nil, false},
+		{living.Info, "living info", nil, true},
+		{mqtt.Warn, "hall warn", []any{"device", "light/hall"}, false},
+		{mqtt.Error, "hall error", []any{"device", "light/hall"}, true},
+		{bridge.Info, "other info", []any{"device", "light/porch"}, false},
+	}
+	for _, tt := range tests {
+		tt.log(tt.msg, tt.args...)
+		if got := strings.Contains(buf.String(), tt.msg); got != tt.expect {
+			t.Errorf("%s: logged %v expect %v", tt.msg, got, tt.expect)
+		}
+	}
+
+	setTrace("light/hall", true)
+	bridge.Debug("hall trace", "device", "light/hall")
+	bridge.Debug("porch trace", "device", "light/porch")
+	setTrace("light/hall", false)
+	bridge.Debug("hall untraced", "device", "light/hall")
+	for msg, expect := range map[string]bool{
+		"hall trace":    true,
+		"porch trace":   false,
+		"hall untraced": false,
+	} {
+		if got := strings.Contains(buf.String(), msg); got != expect {
+			t.Errorf("%s: logged %v expect %v", msg, got, expect)
+		}
+	}
+	if lv := log_root.minLevel(); lv != slog.LevelDebug {
+		t.Errorf("min level %v expect DEBUG of mqtt", lv)
+	}
+}
+
+// tracing returns whether the packet trace of the device is enabled.
+func tracing(device string) bool {
+	log_root.mutex.Lock()
+	defer log_root.mutex.Unlock()
+	return log_root.trace[device]
+}
+
+func TestTraceMqtt(t *testing.T) {
+	mqtt, _, _ := startBridge(t)
+	mqtt.wait(t, "light/hall/power", "off")
+
+	wait := func(expect bool) {
+		t.Helper()
+		for i := 0; i < 50 && tracing("light/hall") != expect; i++ {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if tracing("light/hall") != expect {
+			t.Fatalf("trace %v expect %v", !expect, expect)
+		}
+	}
+	mqtt.recv <- [2]string{"light/hall/trace/set", "on"}
+	wait(true)
+	mqtt.recv <- [2]string{"light/hall/trace/set", "off"}
+	wait(false)
+}
+
+func TestTraceHTTP(t *testing.T) {
+	setupLogging(io.Discard, LogConfig{})
+	enet, _ := newEchonet(t)
+	if _, err := enet.NewObject(echonet.Config{Type: "light", Name: "hall",
+		Addr: "10.0.0.2", Eoj: "029001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, body string
+		status     int
+		trace      bool
+	}{
+		{"hall", "on\n", 204, true},
+		{"hall", "yes", 400, true},
+		{"porch", "on", 404, true},
+		{"hall", "off", 204, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("PUT", "/trace/light/"+tt.name,
+			strings.NewReader(tt.body))
+		req.SetPathValue("type", "light")
+		req.SetPathValue("name", tt.name)
+		rec := httptest.NewRecorder()
+		traceHandler(rec, req, enet)
+		if rec.Code != tt.status {
+			t.Errorf("%s %q: status %d expect %d", tt.name, tt.body,
+				rec.Code, tt.status)
+		}
+		if tracing("light/hall") != tt.trace {
+			t.Errorf("%s %q: trace %v expect %v", tt.name, tt.body,
+				!tt.trace, tt.trace)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+/// metrics.go ---
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"echonet-mqtt/echonet"
+)
+
+// buckets of round trip time in seconds
+var rtt_buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(rtt_buckets))
+	}
+	for i, le := range rtt_buckets {
+		if v <= le {
+			h.counts[i] += 1
+			break
+		}
+	}
+	h.sum += v
+	h.count += 1
+}
+
+// Metrics counts the traffic of the bridge for Prometheus.
+type Metrics struct {
+	mutex        sync.Mutex
+	sent         map[string]uint64 // by ESV
+	received     map[string]uint64 // by ESV
+	rtt          map[string]*histogram
+	parse_errors uint64
+	timeouts     uint64
+	retries      uint64
+	published    uint64
+	commands     map[string]uint64 // by command
+}
+
+var (
+	metrics = newMetrics()
+)
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		sent:     map[string]uint64{},
+		received: map[string]uint64{},
+		rtt:      map[string]*histogram{},
+		commands: map[string]uint64{},
+	}
+}
+
+// handleEvent is the listener of the echonet traffic.
+func (m *Metrics) handleEvent(ev *echonet.Event) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	switch ev.Kind {
+	case echonet.EVENT_SEND:
+		m.sent[echonet.EsvName(ev.Packet.GetEsv())] += 1
+	case echonet.EVENT_RECV:
+		esv := echonet.EsvName(ev.Packet.GetEsv())
+		if !ev.Repeated { // once per packet, the RTT per object
+			m.received[esv] += 1
+		}
+		if ev.RTT > 0 {
+			h, ok := m.rtt[esv]
+			if !ok {
+				h = &histogram{}
+				m.rtt[esv] = h
+			}
+			h.observe(ev.RTT.Seconds())
+		}
+	case echonet.EVENT_PARSE_ERROR:
+		m.parse_errors += 1
+	case echonet.EVENT_TIMEOUT:
+		m.timeouts += 1
+	case echonet.EVENT_RETRY:
+		m.retries += 1
+	}
+}
+
+func (m *Metrics) Published() {
+	m.mutex.Lock()
+	m.published += 1
+	m.mutex.Unlock()
+}
+
+func (m *Metrics) Command(cmd string) {
+	m.mutex.Lock()
+	m.commands[cmd] += 1
+	m.mutex.Unlock()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler(enet *echonet.Echonet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.write(w, enet)
+	})
+}
+
+func (m *Metrics) write(w io.Writer, enet *echonet.Echonet) {
+	p := &promWriter{w: w}
+
+	m.mutex.Lock()
+	p.counterVec("echonet_mqtt_packets_sent_total",
+		"ECHONET Lite packets sent.", "esv", m.sent)
+	p.counterVec("echonet_mqtt_packets_received_total",
+		"ECHONET Lite packets received.", "esv", m.received)
+	p.counter("echonet_mqtt_parse_errors_total",
+		"Invalid ECHONET Lite packets received.", m.parse_errors)
+	p.counter("echonet_mqtt_timeouts_total",
+		"Requests without response.", m.timeouts)
+	p.counter("echonet_mqtt_retries_total",
+		"Requests sent again after timeout.", m.retries)
+	p.histogramVec("echonet_mqtt_request_duration_seconds",
+		"Round trip time of requests by response ESV.", "esv", m.rtt)
+	p.counter("echonet_mqtt_published_total",
+		"MQTT messages published.", m.published)
+	p.counterVec("echonet_mqtt_commands_total",
+		"MQTT commands received.", "command", m.commands)
+	m.mutex.Unlock()
+
+	p.gauge("echonet_mqtt_send_queue_depth",
+		"Packets waiting to be sent.", float64(enet.QueueDepth()))
+	p.gauge("echonet_mqtt_recv_queue_depth",
+		"Device updates waiting to be published.", float64(len(enet.RecvChan)))
+
+	devices := []struct {
+		name  string
+		help  string
+		types []string
+		value func(obj *echonet.EchonetObject) (float64, bool)
+	}{
+		{"echonet_mqtt_device_power_on", "Operation status (1 = on).",
+			[]string{"aircon", "light"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				if obj.GetPower() == "on" {
+					return 1, true
+				}
+				return 0, true
+			}},
+		{"echonet_mqtt_device_target_temperature_celsius",
+			"Set temperature.", []string{"aircon"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				return metricValue(obj.GetTargetTemp())
+			}},
+		{"echonet_mqtt_device_room_temperature_celsius",
+			"Measured room temperature.", []string{"aircon"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				return metricValue(obj.GetRoomTemp())
+			}},
+		{"echonet_mqtt_device_outdoor_temperature_celsius",
+			"Measured outdoor temperature.", []string{"aircon"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				return metricValue(obj.GetOutdoorTemp())
+			}},
+		{"echonet_mqtt_device_target_humidity_percent",
+			"Set humidity.", []string{"aircon"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				return metricValue(obj.GetTargetHumidity())
+			}},
+		{"echonet_mqtt_device_room_humidity_percent",
+			"Measured room humidity.", []string{"aircon"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				return metricValue(obj.GetRoomHumidfy())
+			}},
+		{"echonet_mqtt_device_power_consumption_watts",
+			"Instantaneous power consumption.", []string{"aircon"},
+			func(obj *echonet.EchonetObject) (float64, bool) {
+				return metricValue(obj.GetWatt())
+			}},
+	}
+
+	var list []*echonet.EchonetObject
+	for _, obj := range enet.List() {
+		list = append(list, obj.Snapshot())
+	}
+	for _, d := range devices {
+		p.header(d.name, "gauge", d.help)
+		for _, obj := range list {
+			for _, t := range d.types {
+				if obj.GetType() != t {
+					continue
+				}
+				if v, ok := d.value(obj); ok {
+					p.sample(d.name, labels("type", obj.GetType(),
+						"name", obj.GetName()), v)
+				}
+			}
+		}
+	}
+}
+
+// metricValue returns the value of a gauge, false to omit the sample
+// while unknown.
+func metricValue(v int, ok bool) (float64, bool) {
+	return float64(v), ok
+}
+
+// Prometheus text format writer
+type promWriter struct {
+	w io.Writer
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (p *promWriter) sample(name, labels string, v float64) {
+	fmt.Fprintf(p.w, "%s%s %g\n", name, labels, v)
+}
+
+func (p *promWriter) counter(name, help string, v uint64) {
+	p.header(name, "counter", help)
+	p.sample(name, "", float64(v))
+}
+
+func (p *promWriter) gauge(name, help string, v float64) {
+	p.header(name, "gauge", help)
+	p.sample(name, "", v)
+}
+
+func (p *promWriter) counterVec(name, help, label string, m map[string]uint64) {
+	p.header(name, "counter", help)
+	for _, k := range sortedKeys(m) {
+		p.sample(name, labels(label, k), float64(m[k]))
+	}
+}
+
+func (p *promWriter) histogramVec(name, help, label string, m map[string]*histogram) {
+	p.header(name, "histogram", help)
+	for _, k := range sortedKeys(m) {
+		h := m[k]
+		var cum uint64
+		for i, le := range rtt_buckets {
+			cum += h.counts[i]
+			p.sample(name+"_bucket",
+				labels(label, k, "le", fmt.Sprintf("%g", le)), float64(cum))
+		}
+		p.sample(name+"_bucket", labels(label, k, "le", "+Inf"),
+			float64(h.count))
+		p.sample(name+"_sum", labels(label, k), h.sum)
+		p.sample(name+"_count", labels(label, k), float64(h.count))
+	}
+}
+
+// labels formats name/value pairs as a label set.
+func labels(kv ...string) string {
+	var ss []string
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i := 0; i+1 < len(kv); i += 2 {
+		ss = append(ss, fmt.Sprintf(`%s="%s"`, kv[i], r.Replace(kv[i+1])))
+	}
+	return "{" + strings.Join(ss, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+func TestMetrics(t *testing.T) {
+	setupLogging(io.Discard, LogConfig{})
+	enet, _ := newEchonet(t, echonet.NewSimAircon(1))
+	if err := enet.Start(); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := enet.NewObject(echonet.Config{Type: "aircon", Name: "living",
+		Addr: "10.0.0.2", Eoj: "013001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := obj.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMetrics()
+	packet := func(esv byte) *echonet.EchonetPacket {
+		pkt := echonet.NewEchonetPacket()
+		pkt.SetEsv(esv)
+		return pkt
+	}
+	for _, ev := range []*echonet.Event{
+		{Kind: echonet.EVENT_SEND, Packet: packet(echonet.ESV_GET)},
+		{Kind: echonet.EVENT_SEND, Packet: packet(echonet.ESV_GET)},
+		{Kind: echonet.EVENT_RECV, Packet: packet(echonet.ESV_GET_RES),
+			RTT: 200 * time.Millisecond},
+		{Kind: echonet.EVENT_RECV, Packet: packet(echonet.ESV_GET_RES),
+			RTT: 300 * time.Millisecond, Repeated: true}, // for a group
+		{Kind: echonet.EVENT_RECV, Packet: packet(echonet.ESV_INF)},
+		{Kind: echonet.EVENT_PARSE_ERROR},
+		{Kind: echonet.EVENT_RETRY, Packet: packet(echonet.ESV_GET)},
+		{Kind: echonet.EVENT_TIMEOUT, Packet: packet(echonet.ESV_GET)},
+	} {
+		m.handleEvent(ev)
+	}
+	m.Published()
+	m.Published()
+	m.Command("mode")
+
+	rec := httptest.NewRecorder()
+	m.Handler(enet).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4" {
+		t.Errorf("content type %q", ct)
+	}
+	body := rec.Body.String()
+
+	// exposition format: every sample follows the TYPE of its family
+	sample := regexp.MustCompile(`^([a-z_]+?)(_bucket|_sum|_count)?(\{[^}]*\})? \S+$`)
+	typed := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if name, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			typed[strings.Fields(name)[0]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		match := sample.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("invalid line %q", line)
+		} else if !typed[match[1]] && !typed[match[1]+match[2]] {
+			t.Errorf("no TYPE for %q", line)
+		}
+	}
+
+	for _, expect := range []string{
+		"# TYPE echonet_mqtt_packets_sent_total counter",
+		`echonet_mqtt_packets_sent_total{esv="Get"} 2`,
+		`echonet_mqtt_packets_received_total{esv="Get_Res"} 1`,
+		`echonet_mqtt_packets_received_total{esv="INF"} 1`,
+		"echonet_mqtt_parse_errors_total 1",
+		"echonet_mqtt_timeouts_total 1",
+		"echonet_mqtt_retries_total 1",
+		"# TYPE echonet_mqtt_request_duration_seconds histogram",
+		`echonet_mqtt_request_duration_seconds_bucket{esv="Get_Res",le="0.1"} 0`,
+		`echonet_mqtt_request_duration_seconds_bucket{esv="Get_Res",le="0.25"} 1`,
+		`echonet_mqtt_request_duration_seconds_bucket{esv="Get_Res",le="0.5"} 2`,
+		`echonet_mqtt_request_duration_seconds_bucket{esv="Get_Res",le="+Inf"} 2`,
+		`echonet_mqtt_request_duration_seconds_sum{esv="Get_Res"} 0.5`,
+		`echonet_mqtt_request_duration_seconds_count{esv="Get_Res"} 2`,
+		"echonet_mqtt_published_total 2",
+		`echonet_mqtt_commands_total{command="mode"} 1`,
+		"echonet_mqtt_send_queue_depth 0",
+		"# TYPE echonet_mqtt_device_power_on gauge",
+		`echonet_mqtt_device_power_on{type="aircon",name="living"} 0`,
+		`echonet_mqtt_device_target_temperature_celsius{type="aircon",name="living"} 26`,
+		`echonet_mqtt_device_room_temperature_celsius{type="aircon",name="living"} 28`,
+	} {
+		if !strings.Contains(body, expect+"\n") {
+			t.Errorf("missing %q in\n%s", expect, body)
+		}
+	}
+	if strings.Contains(body, `{esv="INF",le=`) {
+		t.Errorf("histogram of a notification without RTT")
+	}
+}
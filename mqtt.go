@@ -6,6 +6,15 @@ import (
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
+// Mqtt is the MQTT connection of the bridge.
+type Mqtt interface {
+	Send(topic string, payload string)
+	Subscribe(topics ...string) error
+	Unsubscribe(topics ...string) error
+	IsConnected() bool
+	Messages() <-chan [2]string // topic and payload
+}
+
 type MqttClient struct {
 	client MQTT.Client
 }
@@ -14,12 +23,20 @@ var (
 	recv_mqtt = make(chan [2]string, 32)
 )
 
-func NewMqtt(broker string) (*MqttClient, error) {
+func NewMqtt(broker, username, password string) (*MqttClient, error) {
 	mqtt := &MqttClient{}
 
 	opts := MQTT.NewClientOptions()
 	opts.AddBroker(broker)
+	opts.SetUsername(username)
+	opts.SetPassword(password)
 	opts.SetAutoReconnect(true)
+	opts.SetOnConnectHandler(func(c MQTT.Client) {
+		log_mqtt.Info("connected", "broker", broker)
+	})
+	opts.SetConnectionLostHandler(func(c MQTT.Client, err error) {
+		log_mqtt.Warn("connection lost", "err", err)
+	})
 	opts.SetDefaultPublishHandler(
 		func(c MQTT.Client, msg MQTT.Message) {
 			recv_mqtt <- [2]string{msg.Topic(), string(msg.Payload())}
@@ -33,14 +50,36 @@ func NewMqtt(broker string) (*MqttClient, error) {
 	return mqtt, nil
 }
 
-func (mqtt *MqttClient) Subscribe(topic string) error {
-	if t := mqtt.client.Subscribe(topic, 0, nil); t.Wait() && t.Error() != nil {
+func (mqtt *MqttClient) Subscribe(topics ...string) error {
+	for _, topic := range topics {
+		t := mqtt.client.Subscribe(topic, 0, nil)
+		if t.Wait() && t.Error() != nil {
+			return t.Error()
+		}
+	}
+	return nil
+}
+
+func (mqtt *MqttClient) Unsubscribe(topics ...string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	if t := mqtt.client.Unsubscribe(topics...); t.Wait() && t.Error() != nil {
 		return t.Error()
 	}
 	return nil
 }
 
+func (mqtt *MqttClient) Messages() <-chan [2]string {
+	return recv_mqtt
+}
+
+func (mqtt *MqttClient) IsConnected() bool {
+	return mqtt.client.IsConnectionOpen()
+}
+
 func (mqtt *MqttClient) Send(topic string, payload string) {
 	t := mqtt.client.Publish(topic, 0, true, payload)
 	t.Wait()
+	metrics.Published()
 }
@@ -0,0 +1,183 @@
+/// reload.go ---
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+const (
+	CONFIG_POLL_INTERVAL = 5 * time.Second
+)
+
+// config file watcher
+type configWatcher struct {
+	C     chan struct{}
+	mutex sync.Mutex
+	files []string
+	last  string // stamp of the files at the last check
+}
+
+// watchConfig notifies on SIGHUP or when one of the config files,
+// or the include directory, is modified.
+func watchConfig(files []string) *configWatcher {
+	w := &configWatcher{
+		C: make(chan struct{}, 1),
+	}
+	w.Watch(files)
+
+	notify := func() {
+		select {
+		case w.C <- struct{}{}:
+		default: // reload already pending
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	go func() {
+		ticker := time.NewTicker(CONFIG_POLL_INTERVAL)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-sig:
+				log_config.Info("reload", "reason", "SIGHUP")
+				notify()
+			case <-ticker.C:
+				if !w.modified() {
+					continue
+				}
+				log_config.Info("reload", "reason", "modified")
+				notify()
+			}
+		}
+	}()
+
+	return w
+}
+
+// Watch replaces the list of watched files, as they are now.
+func (w *configWatcher) Watch(files []string) {
+	s := stamp(files)
+	w.mutex.Lock()
+	w.files = files
+	w.last = s
+	w.mutex.Unlock()
+}
+
+// modified returns true if the files changed since the last check.
+func (w *configWatcher) modified() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	s := stamp(w.files)
+	if s == w.last {
+		return false
+	}
+	w.last = s
+	return true
+}
+
+func stamp(files []string) string {
+	var s string
+	for _, fn := range files {
+		st, err := os.Stat(fn)
+		if err != nil {
+			s += fn + ":-\n"
+			continue
+		}
+		s += fmt.Sprintf("%s:%d:%d\n", fn, st.ModTime().UnixNano(), st.Size())
+	}
+	return s
+}
+
+// reloadConfig applies the difference between the running config and
+// the new one. Objects whose config is unchanged are kept as they are,
+// so their state and TID counter survive the reload.
+func reloadConfig(enet *echonet.Echonet, mqtt Mqtt,
+	old Config, cfg Config) Config {
+
+	if cfg.Broker != old.Broker || cfg.Username != old.Username ||
+		cfg.Password != old.Password {
+		log_config.Warn("broker change requires restart", "broker", old.Broker)
+		cfg.Broker = old.Broker
+		cfg.Username = old.Username
+		cfg.Password = old.Password
+	}
+	if cfg.HTTP != old.HTTP {
+		log_config.Warn("http change requires restart", "http", old.HTTP)
+		cfg.HTTP = old.HTTP
+	}
+	if !reflect.DeepEqual(cfg.Echonet, old.Echonet) {
+		log_config.Warn("echonet change requires restart",
+			"port", old.Echonet.Port, "bind", old.Echonet.Bind,
+			"interfaces", old.Echonet.Interfaces, "mra", old.Echonet.MRA,
+			"record", old.Echonet.Record)
+		cfg.Echonet = old.Echonet
+	}
+
+	health.Configure(cfg.Health)
+
+	if cfg.Log.Format != old.Log.Format {
+		log_config.Warn("log format change requires restart",
+			"format", old.Log.Format)
+	}
+	configureLogging(cfg.Log)
+
+	for _, obj := range enet.List() {
+		c, ok := findConfig(cfg, obj.GetType(), obj.GetName())
+		if ok && reflect.DeepEqual(c, obj.GetConfig()) {
+			continue // unchanged
+		}
+
+		mqtt.Unsubscribe(commandTopics(obj)...)
+		err := enet.RemoveObject(obj)
+		if err != nil {
+			log_config.Warn("remove failed", "type", obj.GetType(),
+				"name", obj.GetName(), "err", err)
+		}
+		log_bridge.Info("removed", "addr", obj.GetConfig().Addr,
+			"eoj", fmt.Sprintf("%06x", obj.GetEoj()),
+			"type", obj.GetType(), "name", obj.GetName())
+	}
+
+	for _, c := range cfg.ObjectList {
+		if enet.FindObject(c.Type, c.Name) != nil {
+			continue // unchanged
+		}
+
+		obj, err := enet.NewObject(c)
+		if err != nil {
+			log_config.Error("add failed", "type", c.Type, "name", c.Name,
+				"err", err)
+			continue
+		}
+		log_bridge.Info("added", "addr", c.Addr,
+			"eoj", fmt.Sprintf("%06x", obj.GetEoj()),
+			"type", obj.GetType(), "name", obj.GetName())
+
+		mqtt.Subscribe(commandTopics(obj)...)
+		go obj.State()
+	}
+
+	return cfg
+}
+
+func findConfig(cfg Config, objtype, objname string) (echonet.Config, bool) {
+	for _, c := range cfg.ObjectList {
+		if c.Type == objtype && c.Name == objname {
+			return c, true
+		}
+	}
+	return echonet.Config{}, false
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+func TestReloadConfig(t *testing.T) {
+	setupLogging(io.Discard, LogConfig{})
+	enet, _ := newEchonet(t, echonet.NewSimAircon(1), echonet.NewSimAircon(2),
+		echonet.NewSimLight(1))
+	if err := enet.Start(); err != nil {
+		t.Fatal(err)
+	}
+	mqtt := &fakeMqtt{published: map[string]string{}}
+
+	old := Config{
+		Broker:  "tcp://broker:1883",
+		HTTP:    ":9100",
+		Echonet: EchonetConfig{Port: 3610},
+		ObjectList: []echonet.Config{
+			{Type: "aircon", Name: "living", Addr: "10.0.0.2", Eoj: "013001"},
+			{Type: "aircon", Name: "kitchen", Addr: "10.0.0.2", Eoj: "013002"},
+			{Type: "light", Name: "hall", Addr: "10.0.0.2", Eoj: "029001"},
+		},
+	}
+	for _, c := range old.ObjectList {
+		obj, err := enet.NewObject(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mqtt.Subscribe(commandTopics(obj)...)
+	}
+	living := enet.FindObject("aircon", "living")
+	kitchen := enet.FindObject("aircon", "kitchen")
+
+	cfg := Config{
+		Broker:  "tcp://other:1883",
+		HTTP:    ":9200",
+		Echonet: EchonetConfig{Port: 3611},
+		Log:     LogConfig{Level: "debug"},
+		ObjectList: []echonet.Config{
+			{Type: "aircon", Name: "living", Addr: "10.0.0.2", Eoj: "013001"},
+			{Type: "aircon", Name: "kitchen", Addr: "10.0.0.2", Eoj: "013002",
+				SetGet: true},
+			{Type: "light", Name: "porch", Addr: "10.0.0.2", Eoj: "029001"},
+		},
+	}
+	cfg = reloadConfig(enet, mqtt, old, cfg)
+	defer configureLogging(LogConfig{})
+
+	// restart-only settings are kept
+	if cfg.Broker != old.Broker || cfg.HTTP != old.HTTP ||
+		cfg.Echonet.Port != old.Echonet.Port {
+		t.Errorf("broker %s http %s port %d expect the running ones",
+			cfg.Broker, cfg.HTTP, cfg.Echonet.Port)
+	}
+	if cfg.Log.Level != "debug" {
+		t.Errorf("log level %q expect debug", cfg.Log.Level)
+	}
+
+	if obj := enet.FindObject("aircon", "living"); obj != living {
+		t.Errorf("unchanged living replaced")
+	}
+	obj := enet.FindObject("aircon", "kitchen")
+	if obj == nil || obj == kitchen || !obj.GetConfig().SetGet {
+		t.Errorf("changed kitchen not replaced")
+	}
+	if enet.FindObject("light", "hall") != nil {
+		t.Errorf("removed hall still exists")
+	}
+	if enet.FindObject("light", "porch") == nil {
+		t.Errorf("added porch not found")
+	}
+	if n := len(enet.List()); n != 3 {
+		t.Errorf("%d objects expect 3", n)
+	}
+
+	for topic, expect := range map[string]bool{
+		"aircon/living/mode/set":  true,
+		"aircon/kitchen/mode/set": true, // subscribed again
+		"light/porch/power/set":   true,
+		"light/hall/power/set":    false,
+		"light/hall/trace/set":    false,
+	} {
+		if mqtt.subscribed[topic] != expect {
+			t.Errorf("%s subscribed %v expect %v", topic, !expect, expect)
+		}
+	}
+}
+
+func TestConfigWatcher(t *testing.T) {
+	setupLogging(io.Discard, LogConfig{})
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.yaml")
+	b := filepath.Join(dir, "b.yaml")
+	if err := os.WriteFile(a, []byte("broker: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := watchConfig([]string{a})
+
+	if w.modified() {
+		t.Errorf("modified before write")
+	}
+	if err := os.WriteFile(a, []byte("broker: xy\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.modified() {
+		t.Errorf("not modified after write")
+	}
+	if w.modified() {
+		t.Errorf("modified twice by one write")
+	}
+
+	// the files of the reload are the current ones
+	w.Watch([]string{a, b}) // b missing
+	if w.modified() {
+		t.Errorf("modified after watch")
+	}
+	if err := os.WriteFile(b, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !w.modified() {
+		t.Errorf("not modified after create")
+	}
+
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	select {
+	case <-w.C:
+	case <-time.After(time.Second):
+		t.Errorf("no reload on SIGHUP")
+	}
+}
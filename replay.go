@@ -0,0 +1,125 @@
+/// replay.go ---
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+// printMqtt prints the messages published, when the payload changes,
+// instead of sending them to a broker.
+type printMqtt struct {
+	mutex     sync.Mutex
+	w         io.Writer
+	published map[string]string
+	recv      chan [2]string
+}
+
+func (m *printMqtt) Send(topic string, payload string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if prev, ok := m.published[topic]; ok && prev == payload {
+		return
+	}
+	m.published[topic] = payload
+	fmt.Fprintf(m.w, "%s %s\n", topic, payload)
+}
+
+func (m *printMqtt) Subscribe(topics ...string) error   { return nil }
+func (m *printMqtt) Unsubscribe(topics ...string) error { return nil }
+func (m *printMqtt) IsConnected() bool                  { return true }
+func (m *printMqtt) Messages() <-chan [2]string         { return m.recv }
+
+// replay feeds a recording to the devices of the config without a
+// network, and prints the messages published and the final states.
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(),
+			"usage: echonet2mqtt replay [flags] <CONFIG FILE> <RECORDING>")
+		fs.PrintDefaults()
+	}
+	speed := fs.Float64("speed", 0,
+		"1 for the recorded pace, 2 for twice as fast; 0 as fast as possible")
+	verbose := fs.Bool("v", false, "log packets")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	fn := fs.Arg(0)
+	cfg, err := readConfig(fn)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *verbose {
+		cfg.Log.Level = "debug"
+	}
+	setupLogging(os.Stderr, cfg.Log)
+	cfg.HTTP = "" // no network
+
+	frames, err := echonet.LoadRecording(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if cfg.Echonet.MRA != "" {
+		m, err := echonet.LoadMRA(cfg.Echonet.MRA)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		echonet.UseMRA(m)
+	}
+
+	tr := echonet.NewReplayTransport(frames)
+	tr.Speed = *speed
+	enet, err := echonet.NewEchonetOptions(echonet.Options{Transport: tr})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	enet.Retry = 0 // the requests are not answered
+	enet.Interval = 0
+
+	mqtt := &printMqtt{w: os.Stdout, published: map[string]string{},
+		recv: make(chan [2]string)}
+	stop := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- run(fn, cfg, enet, mqtt, stop)
+	}()
+
+	select {
+	case <-tr.Done():
+	case err := <-result:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	time.Sleep(100 * time.Millisecond) // the last updates published
+	close(stop)
+	<-result
+	enet.Close()
+
+	objs := enet.List()
+	sort.Slice(objs, func(i, j int) bool {
+		return objs[i].GetType()+"/"+objs[i].GetName() <
+			objs[j].GetType()+"/"+objs[j].GetName()
+	})
+	enc := json.NewEncoder(os.Stdout)
+	for _, obj := range objs {
+		enc.Encode(deviceState(obj))
+	}
+	return 0
+}
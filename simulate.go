@@ -0,0 +1,102 @@
+/// simulate.go ---
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"echonet-mqtt/echonet"
+)
+
+// simulate runs the device simulator until interrupted.
+func simulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(),
+			"usage: echonet2mqtt simulate [flags] [aircon|light|meter ...]")
+		fs.PrintDefaults()
+	}
+	addr := fs.String("addr", ":3610", "listen address")
+	peer := fs.String("peer", "",
+		"send responses and announcements to this address (e.g. 127.0.0.1:3610)")
+	tick := fs.Duration("tick", echonet.SIM_TICK, "interval of state transitions")
+	drop := fs.Float64("drop", 0, "probability to ignore a request")
+	delay := fs.Duration("delay", 0, "delay of responses")
+	sna := fs.String("sna", "", "EPCs always denied, e.g. b0,b3")
+	malformed := fs.Float64("malformed", 0, "probability to truncate a response")
+	verbose := fs.Bool("v", false, "log packets")
+	fs.Parse(args)
+
+	level := "info"
+	if *verbose {
+		level = "debug"
+	}
+	setupLogging(os.Stderr, LogConfig{Level: level})
+
+	faults := echonet.Faults{
+		Drop:      *drop,
+		Delay:     *delay,
+		Malformed: *malformed,
+	}
+	for _, s := range strings.Split(*sna, ",") {
+		if s == "" {
+			continue
+		}
+		epc, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 8)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid EPC %q\n", s)
+			return 2
+		}
+		faults.SNA = append(faults.SNA, byte(epc))
+	}
+
+	sim := echonet.NewSimulator(*addr)
+	sim.Peer = *peer
+	sim.Tick = *tick
+	sim.Logger = slog.Default().With("subsystem", "simulator")
+	sim.SetFaults(faults)
+
+	devices := fs.Args()
+	if len(devices) == 0 {
+		devices = []string{"aircon", "light", "meter"}
+	}
+	instances := map[string]byte{}
+	for _, d := range devices {
+		instances[d] += 1
+		var obj *echonet.SimObject
+		switch d {
+		case "aircon":
+			obj = echonet.NewSimAircon(instances[d])
+		case "light":
+			obj = echonet.NewSimLight(instances[d])
+		case "meter":
+			obj = echonet.NewSimMeter(instances[d])
+		default:
+			fmt.Fprintf(os.Stderr, "unknown device %q\n", d)
+			return 2
+		}
+		sim.Add(obj)
+		log_bridge.Info("simulate", "type", d,
+			"eoj", fmt.Sprintf("%06x", obj.Eoj))
+	}
+
+	err := sim.Start()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	log_bridge.Info("listen", "addr", sim.LocalAddr().String())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	sim.Close()
+	return 0
+}
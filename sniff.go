@@ -0,0 +1,297 @@
+/// sniff.go ---
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+// sniffFilter selects the frames to print; empty lists match all.
+type sniffFilter struct {
+	ips  []net.IP
+	eojs []sniffEoj
+	esvs []byte
+	epcs []byte
+}
+
+// EOJ or class of the filter
+type sniffEoj struct {
+	code  uint32
+	class bool // code is the class, any instance
+}
+
+// parseSniffFilter parses the comma separated lists of the flags.
+func parseSniffFilter(ips, eojs, esvs, epcs string) (*sniffFilter, error) {
+	f := &sniffFilter{}
+	for _, s := range splitList(ips) {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		f.ips = append(f.ips, ip)
+	}
+	for _, s := range splitList(eojs) {
+		s = strings.TrimPrefix(strings.ToLower(s), "0x")
+		v, err := strconv.ParseUint(s, 16, 24)
+		if err != nil || (len(s) != 4 && len(s) != 6) {
+			return nil, fmt.Errorf("invalid EOJ %q (e.g. 0130 or 013001)", s)
+		}
+		f.eojs = append(f.eojs, sniffEoj{uint32(v), len(s) == 4})
+	}
+	for _, s := range splitList(esvs) {
+		esv, ok := parseEsv(s)
+		if !ok {
+			return nil, fmt.Errorf("invalid ESV %q (e.g. Get or 62)", s)
+		}
+		f.esvs = append(f.esvs, esv)
+	}
+	for _, s := range splitList(epcs) {
+		epc, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EPC %q", s)
+		}
+		f.epcs = append(f.epcs, byte(epc))
+	}
+	return f, nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parseEsv parses the name of the ESV, e.g. "Get_Res", or its code.
+func parseEsv(s string) (byte, bool) {
+	for esv := 0x50; esv < 0x80; esv++ {
+		if strings.EqualFold(s, echonet.EsvName(byte(esv))) {
+			return byte(esv), true
+		}
+	}
+	v, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 8)
+	return byte(v), err == nil
+}
+
+// frames only selects the frames by address, for frames not parsed.
+func (f *sniffFilter) frames() bool {
+	return len(f.eojs) == 0 && len(f.esvs) == 0 && len(f.epcs) == 0
+}
+
+func (f *sniffFilter) matchAddr(src, dst net.IP) bool {
+	if len(f.ips) == 0 {
+		return true
+	}
+	for _, ip := range f.ips {
+		if ip.Equal(src) || ip.Equal(dst) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *sniffFilter) match(pkt *echonet.EchonetPacket) bool {
+	if len(f.eojs) > 0 {
+		match := false
+		for _, e := range f.eojs {
+			for _, eoj := range []uint32{pkt.SEOJ, pkt.DEOJ} {
+				match = match || eoj == e.code ||
+					(e.class && eoj>>8 == e.code)
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	if len(f.esvs) > 0 {
+		match := false
+		for _, esv := range f.esvs {
+			match = match || pkt.ESV == esv
+		}
+		if !match {
+			return false
+		}
+	}
+	if len(f.epcs) > 0 {
+		for _, epc := range f.epcs {
+			for _, prop := range slices.Concat(pkt.Props, pkt.GetProps) {
+				if prop.EPC == epc {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// sniffer prints the frames passing the filter.
+type sniffer struct {
+	w      io.Writer
+	filter *sniffFilter
+	raw    bool // print the frames in hex also
+}
+
+func (s *sniffer) frame(t time.Time, src, dst string, srcIP, dstIP net.IP,
+	data []byte) {
+
+	if !s.filter.matchAddr(srcIP, dstIP) {
+		return
+	}
+	ts := "-"
+	if !t.IsZero() {
+		ts = t.Format("2006-01-02T15:04:05.000")
+	}
+	pkt := echonet.NewEchonetPacket()
+	if err := pkt.Parse(data); err != nil {
+		if s.filter.frames() {
+			fmt.Fprintf(s.w, "%s %s -> %s invalid: %s %x\n", ts, src, dst,
+				err, data)
+		}
+		return
+	}
+	if !s.filter.match(pkt) {
+		return
+	}
+	fmt.Fprintf(s.w, "%s %s -> %s %s\n", ts, src, dst, pkt.Decode())
+	if s.raw {
+		fmt.Fprintf(s.w, "\t%s\n", hex.EncodeToString(data))
+	}
+}
+
+// portInUse returns true if another socket listens on the UDP port.
+// The transport shares the port with SO_REUSEADDR, a plain bind does
+// not.
+func portInUse(bind string, port int) bool {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(bind, strconv.Itoa(port)))
+	if err != nil {
+		return errors.Is(err, syscall.EADDRINUSE)
+	}
+	conn.Close()
+	return false
+}
+
+// sniff prints the ECHONET Lite frames received on the multicast
+// groups, or read from a capture file, until interrupted.
+func sniff(args []string) int {
+	fs := flag.NewFlagSet("sniff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: echonet2mqtt sniff [flags]")
+		fs.PrintDefaults()
+	}
+	file := fs.String("r", "", "read a pcap or pcapng file instead of the network")
+	bind := fs.String("bind", "", "address to listen on, default all")
+	port := fs.Int("port", echonet.ECHONET_PORT, "port to listen on and read")
+	ifaces := fs.String("interfaces", "",
+		"interface names or CIDRs to join the multicast group on, comma separated")
+	ips := fs.String("ip", "", "source or destination IP addresses")
+	eojs := fs.String("eoj", "", "source or destination EOJs or classes, e.g. 0130,029001")
+	esvs := fs.String("esv", "", "ESVs, e.g. SetC,Get_Res or 61")
+	epcs := fs.String("epc", "", "EPCs in the frame, e.g. b0,b3")
+	mra := fs.String("mra", "", "MRA directory for the names of other classes")
+	raw := fs.Bool("x", false, "print the frames in hex also")
+	force := fs.Bool("force", false,
+		"listen even if the port is in use, taking its unicast frames")
+	fs.Parse(args)
+
+	filter, err := parseSniffFilter(*ips, *eojs, *esvs, *epcs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *mra != "" {
+		m, err := echonet.LoadMRA(*mra)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		echonet.UseMRA(m)
+	}
+	s := &sniffer{w: os.Stdout, filter: filter, raw: *raw}
+
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		err = echonet.ReadCapture(f, func(f echonet.RecordedFrame) error {
+			if f.Src.Port == *port || f.Dst.Port == *port {
+				s.frame(f.Time, f.Src.String(), f.Dst.String(),
+					f.Src.IP, f.Dst.IP, f.Data)
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *file, err)
+			return 1
+		}
+		return 0
+	}
+
+	// Multicast frames reach every socket on the port, but unicast
+	// frames only the last one bound. Sharing the port of a bridge on
+	// this host takes the frames the devices unicast to the bridge: its
+	// Get_Res and Set_Res, so its requests time out, and the INF to it.
+	if portInUse(*bind, *port) {
+		if !*force {
+			fmt.Fprintf(os.Stderr, "port %d is in use, e.g. by the bridge, "+
+				"whose responses from the devices would be lost; "+
+				"record them with echonet.record and read them with -r, "+
+				"or use -force\n", *port)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: port %d is in use; the unicast "+
+			"frames to its other listener, e.g. the responses to the "+
+			"bridge, are lost while sniffing\n", *port)
+	}
+	t, err := echonet.NewUDPTransport(*bind, *port, splitList(*ifaces))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "listening on %s, interfaces %s\n", t.LocalAddr(),
+		strings.Join(t.Interfaces(), ","))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		t.Close()
+	}()
+
+	buf := make([]byte, 1500)
+	for {
+		n, src, dst, err := t.Recv(buf, time.Time{})
+		if errors.Is(err, net.ErrClosed) {
+			return 0
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		to := "-" // unknown
+		if dst != nil {
+			to = dst.String()
+		}
+		s.frame(time.Now(), src.String(), to, src.IP, dst, buf[:n])
+	}
+}
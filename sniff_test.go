@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"echonet-mqtt/echonet"
+)
+
+func testFrame() []byte {
+	pkt := echonet.NewEchonetPacket()
+	pkt.SetTid(7)
+	pkt.SetSeoj(0x013001)
+	pkt.SetDeoj(echonet.ECHONET_EOJ_NODE)
+	pkt.SetEsv(echonet.ESV_INF)
+	pkt.AddProperty(echonet.EPC_MODE, 0x43)
+	return pkt.Bytes()
+}
+
+func TestSniffFilter(t *testing.T) {
+	pkt := echonet.NewEchonetPacket()
+	if err := pkt.Parse(testFrame()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		eoj, esv, epc string
+		match         bool
+	}{
+		{"", "", "", true},
+		{"013001", "", "", true},
+		{"013002", "", "", false},
+		{"0ef0", "", "", true},
+		{"0290,0130", "Get_Res,inf", "80,b0", true},
+		{"", "73", "", true},
+		{"", "SetC", "", false},
+		{"", "", "b3", false},
+	} {
+		f, err := parseSniffFilter("", tc.eoj, tc.esv, tc.epc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := f.match(pkt); m != tc.match {
+			t.Errorf("%+v: %v", tc, m)
+		}
+	}
+	if _, err := parseSniffFilter("", "01300", "", ""); err == nil {
+		t.Error("EOJ of 5 digits: expect error")
+	}
+	if _, err := parseSniffFilter("", "", "Foo", ""); err == nil {
+		t.Error("ESV Foo: expect error")
+	}
+}
+
+func TestSniffer(t *testing.T) {
+	var out bytes.Buffer
+	filter, _ := parseSniffFilter("192.168.1.20", "0130", "", "")
+	s := &sniffer{w: &out, filter: filter}
+	src, dst := net.ParseIP("192.168.1.20"), net.ParseIP("224.0.23.0")
+	at := time.Date(2026, 10, 18, 7, 30, 0, 0, time.Local)
+	s.frame(at, "192.168.1.20:3610", "224.0.23.0:3610", src, dst, testFrame())
+	s.frame(at, "192.168.1.21:3610", "224.0.23.0:3610",
+		net.ParseIP("192.168.1.21"), dst, testFrame()) // other address
+	s.frame(at, "192.168.1.20:3610", "224.0.23.0:3610", src, dst, []byte{0x10})
+
+	expect := "2026-10-18T07:30:00.000 192.168.1.20:3610 -> 224.0.23.0:3610 " +
+		"HomeAirConditioner[013001] => NodeProfile[0ef001] TID:7 INF " +
+		"OperationMode=heat\n"
+	if s := out.String(); s != expect {
+		t.Errorf("output %q\nexpect %q", s, expect)
+	}
+}
+
+func TestPortInUse(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	if !portInUse("127.0.0.1", port) {
+		t.Errorf("port %d not in use", port)
+	}
+	conn.Close()
+	if portInUse("127.0.0.1", port) {
+		t.Errorf("port %d in use after close", port)
+	}
+}
@@ -0,0 +1,252 @@
+/// stream.go ---
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"echonet-mqtt/echonet"
+)
+
+const (
+	STREAM_BUFFER    = 64               // messages queued per client
+	STREAM_KEEPALIVE = 30 * time.Second // ping / comment interval
+)
+
+// message pushed to the clients
+type streamMessage struct {
+	Type   string         `json:"type"` // "state" or "packet"
+	Time   time.Time      `json:"time"`
+	Device string         `json:"device,omitempty"` // type/name
+	State  map[string]any `json:"state,omitempty"`
+	Packet *packetMessage `json:"packet,omitempty"`
+}
+
+type packetMessage struct {
+	Kind    string  `json:"kind"` // send, recv, parse_error, timeout, retry
+	Addr    string  `json:"addr"`
+	Data    string  `json:"data,omitempty"` // hex
+	Decoded string  `json:"decoded,omitempty"`
+	RTT     float64 `json:"rtt,omitempty"` // seconds
+	Error   string  `json:"error,omitempty"`
+}
+
+type streamClient struct {
+	ch      chan []byte
+	packets bool // wants packet messages
+}
+
+// Stream pushes the state changes and packets to the live clients.
+type Stream struct {
+	mutex   sync.Mutex
+	clients map[*streamClient]bool
+}
+
+var (
+	stream = &Stream{clients: map[*streamClient]bool{}}
+
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+	}
+)
+
+// State pushes the state of the object received from RecvChan.
+func (s *Stream) State(obj *echonet.EchonetObject) {
+	if !s.listening(false) {
+		return // no need to read the state
+	}
+	s.send(stateMessage(obj), false)
+}
+
+// handleEvent is the listener of the echonet traffic.
+func (s *Stream) handleEvent(ev *echonet.Event) {
+	if !s.listening(true) {
+		return // no need to decode the packet
+	}
+	msg := &streamMessage{
+		Type: "packet",
+		Time: ev.Time,
+		Packet: &packetMessage{
+			Kind: echonet.EventName(ev.Kind),
+			Addr: ev.Addr,
+			Data: hex.EncodeToString(ev.Data),
+			RTT:  ev.RTT.Seconds(),
+		},
+	}
+	if ev.Object != nil {
+		msg.Device = ev.Object.GetType() + "/" + ev.Object.GetName()
+	}
+	if ev.Packet != nil {
+		msg.Packet.Decoded = ev.Packet.Decode()
+	}
+	if ev.Err != nil {
+		msg.Packet.Error = ev.Err.Error()
+	}
+	s.send(msg, true)
+}
+
+func stateMessage(obj *echonet.EchonetObject) *streamMessage {
+	return &streamMessage{
+		Type:   "state",
+		Time:   time.Now(),
+		Device: obj.GetType() + "/" + obj.GetName(),
+		State:  deviceState(obj),
+	}
+}
+
+// listening returns true if a client wants the state messages, or the
+// packet messages if packet.
+func (s *Stream) listening(packet bool) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for c := range s.clients {
+		if !packet || c.packets {
+			return true
+		}
+	}
+	return false
+}
+
+// send queues the message to the clients; a client too slow to keep
+// up loses messages rather than blocking the bridge.
+func (s *Stream) send(msg *streamMessage, packet bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.clients) == 0 {
+		return
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		log_http.Warn("stream", "err", err)
+		return
+	}
+	for c := range s.clients {
+		if packet && !c.packets {
+			continue
+		}
+		select {
+		case c.ch <- b:
+		default:
+		}
+	}
+}
+
+// subscribe adds a client, which first receives the state of every
+// device.
+func (s *Stream) subscribe(enet *echonet.Echonet, packets bool) *streamClient {
+	list := enet.List()
+	c := &streamClient{
+		ch:      make(chan []byte, STREAM_BUFFER+len(list)),
+		packets: packets,
+	}
+	for _, obj := range list {
+		b, _ := json.Marshal(stateMessage(obj))
+		c.ch <- b
+	}
+
+	s.mutex.Lock()
+	s.clients[c] = true
+	s.mutex.Unlock()
+	return c
+}
+
+func (s *Stream) unsubscribe(c *streamClient) {
+	s.mutex.Lock()
+	delete(s.clients, c)
+	s.mutex.Unlock()
+}
+
+// EventsHandler serves the stream as Server-Sent Events. Packets are
+// included with ?packets=1.
+func (s *Stream) EventsHandler(enet *echonet.Echonet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported",
+				http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		c := s.subscribe(enet, r.URL.Query().Get("packets") == "1")
+		defer s.unsubscribe(c)
+
+		ticker := time.NewTicker(STREAM_KEEPALIVE)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case b := <-c.ch:
+				_, err := fmt.Fprintf(w, "data: %s\n\n", b)
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-ticker.C:
+				_, err := fmt.Fprint(w, ": keepalive\n\n")
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+// WebSocketHandler serves the stream over WebSocket, one JSON message
+// per frame. Packets are included with ?packets=1.
+func (s *Stream) WebSocketHandler(enet *echonet.Echonet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // the upgrader replied with the error
+		}
+		defer conn.Close()
+
+		c := s.subscribe(enet, r.URL.Query().Get("packets") == "1")
+		defer s.unsubscribe(c)
+
+		// read until the client goes away
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ticker := time.NewTicker(STREAM_KEEPALIVE)
+		defer ticker.Stop()
+		for {
+			var err error
+			conn.SetWriteDeadline(time.Now().Add(STREAM_KEEPALIVE))
+			select {
+			case <-closed:
+				return
+			case b := <-c.ch:
+				err = conn.WriteMessage(websocket.TextMessage, b)
+			case <-ticker.C:
+				err = conn.WriteMessage(websocket.PingMessage, nil)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"echonet-mqtt/echonet"
+)
+
+// streamEcho returns a stream and an Echonet with a light.
+func streamEcho(t *testing.T) (*Stream, *echonet.Echonet, *echonet.EchonetObject) {
+	setupLogging(io.Discard, LogConfig{})
+	enet, _ := newEchonet(t, echonet.NewSimLight(1))
+	obj, err := enet.NewObject(echonet.Config{Type: "light", Name: "hall",
+		Addr: "10.0.0.2", Eoj: "029001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Stream{clients: map[*streamClient]bool{}}, enet, obj
+}
+
+// packetEvent returns a received Get_Res of the object.
+func packetEvent(obj *echonet.EchonetObject) *echonet.Event {
+	pkt := echonet.NewEchonetPacket()
+	pkt.SetSeoj(obj.GetEoj())
+	pkt.SetEsv(echonet.ESV_GET_RES)
+	pkt.AddProperty(echonet.EPC_POWER, echonet.EDT_ON)
+	return &echonet.Event{Kind: echonet.EVENT_RECV, Time: time.Now(),
+		Addr: "10.0.0.2", Data: pkt.Bytes(), Packet: pkt, Object: obj,
+		RTT: 10 * time.Millisecond}
+}
+
+// next returns the next message of the client.
+func next(t *testing.T, ch <-chan streamMessage) streamMessage {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+	return streamMessage{}
+}
+
+// sseClient reads the messages of the Server-Sent Events.
+func sseClient(t *testing.T, url string) <-chan streamMessage {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	ch := make(chan streamMessage, 8)
+	go func() {
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			data, ok := strings.CutPrefix(sc.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var msg streamMessage
+			if err := json.Unmarshal([]byte(data), &msg); err != nil {
+				t.Errorf("invalid message %q: %s", data, err)
+			}
+			ch <- msg
+		}
+	}()
+	return ch
+}
+
+func TestStreamEvents(t *testing.T) {
+	s, enet, obj := streamEcho(t)
+	srv := httptest.NewServer(s.EventsHandler(enet))
+	t.Cleanup(srv.Close) // after the clients
+
+	if s.listening(false) {
+		t.Error("listening without clients")
+	}
+	states := sseClient(t, srv.URL)
+	packets := sseClient(t, srv.URL+"?packets=1")
+
+	// the state of every device first
+	for _, ch := range []<-chan streamMessage{states, packets} {
+		if msg := next(t, ch); msg.Type != "state" || msg.Device != "light/hall" {
+			t.Errorf("first message %+v", msg)
+		}
+	}
+	if !s.listening(true) {
+		t.Error("not listening to packets")
+	}
+
+	s.handleEvent(packetEvent(obj))
+	s.State(obj)
+
+	msg := next(t, packets)
+	if msg.Type != "packet" || msg.Device != "light/hall" || msg.Packet == nil ||
+		msg.Packet.Kind != "recv" || msg.Packet.RTT != 0.01 ||
+		!strings.Contains(msg.Packet.Decoded, "Get_Res") {
+		t.Errorf("packet message %+v", msg)
+	}
+	for _, ch := range []<-chan streamMessage{states, packets} {
+		msg := next(t, ch) // no packet for states
+		if msg.Type != "state" || msg.State["name"] != "hall" {
+			t.Errorf("state message %+v", msg)
+		}
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	s, enet, obj := streamEcho(t)
+	srv := httptest.NewServer(s.WebSocketHandler(enet))
+	t.Cleanup(srv.Close) // after the clients
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func(query string) *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	read := func(conn *websocket.Conn) streamMessage {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		var msg streamMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	states, packets := dial(""), dial("?packets=1")
+	for _, conn := range []*websocket.Conn{states, packets} {
+		if msg := read(conn); msg.Type != "state" || msg.Device != "light/hall" {
+			t.Errorf("first message %+v", msg)
+		}
+	}
+
+	s.handleEvent(packetEvent(obj))
+	s.State(obj)
+	if msg := read(packets); msg.Type != "packet" || msg.Packet.Kind != "recv" {
+		t.Errorf("packet message %+v", msg)
+	}
+	for _, conn := range []*websocket.Conn{states, packets} {
+		if msg := read(conn); msg.Type != "state" {
+			t.Errorf("state message %+v", msg)
+		}
+	}
+
+	// the client is dropped once it goes away
+	states.Close()
+	packets.Close()
+	for i := 0; i < 50 && s.listening(false); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if s.listening(false) {
+		t.Error("listening after the clients closed")
+	}
+}
@@ -0,0 +1,22 @@
+/// webui.go ---
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var web_files embed.FS
+
+// webHandler serves the dashboard, which uses the REST API and the
+// event stream.
+func webHandler() http.Handler {
+	root, err := fs.Sub(web_files, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(root))
+}
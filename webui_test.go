@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebIndex(t *testing.T) {
+	rec := httptest.NewRecorder()
+	webHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("content type %q", ct)
+	}
+	index, err := web_files.ReadFile("web/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(rec.Body); string(body) != string(index) {
+		t.Errorf("body is not the embedded index")
+	}
+}